@@ -0,0 +1,309 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/keystore"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Names of the authentication methods usable in route policies.
+const (
+	AuthNone   = "none"
+	AuthBearer = "bearer"
+	AuthBasic  = "basic"
+	AuthPeer   = "peer"
+)
+
+// AuthConfig configures the authentication of requests to the API endpoint.
+type AuthConfig struct {
+	Bearer BearerAuthConfig `config:"bearer"`
+	Basic  []BasicAuthUser  `config:"basic"`
+	Peer   *PeerAuthConfig  `config:"peer"`
+
+	// Default lists the authentication methods accepted for routes without a
+	// policy. It defaults to all configured methods.
+	Default []string `config:"default"`
+
+	// Routes configures the authentication methods accepted per route.
+	Routes []RoutePolicy `config:"routes"`
+}
+
+// BearerAuthConfig configures the tokens accepted in the Authorization header.
+type BearerAuthConfig struct {
+	Tokens []string `config:"tokens"`
+
+	// KeystoreKeys lists the keys of the tokens stored in the keystore
+	// passed to the server with WithKeystore.
+	KeystoreKeys []string `config:"keystore_keys"`
+}
+
+// BasicAuthUser is a user accepted by the basic authentication method.
+type BasicAuthUser struct {
+	Username string `config:"username" validate:"required"`
+	Password string `config:"password" validate:"required"`
+}
+
+// PeerAuthConfig configures the authentication of clients connected over a
+// Unix socket by the credentials of the peer process. Peer authentication is
+// only supported on Linux.
+type PeerAuthConfig struct {
+	// UIDs and GIDs list the user and group IDs accepted. If both are empty,
+	// only the user running the server is accepted.
+	UIDs []int `config:"uids"`
+	GIDs []int `config:"gids"`
+}
+
+// RoutePolicy sets the authentication methods accepted for a route. Like
+// with http.ServeMux patterns, a path ending in a slash matches the whole
+// subtree. The longest matching path is used.
+type RoutePolicy struct {
+	Path  string   `config:"path" validate:"required"`
+	Allow []string `config:"allow" validate:"required"`
+}
+
+// Validate checks that all methods referenced by policies are known.
+func (c *AuthConfig) Validate() error {
+	policies := append([]RoutePolicy{{Path: "default", Allow: c.Default}}, c.Routes...)
+	for _, p := range policies {
+		for _, method := range p.Allow {
+			switch method {
+			case AuthNone, AuthBearer, AuthBasic, AuthPeer:
+			default:
+				return fmt.Errorf("unknown authentication method %q for route %s", method, p.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// authenticator checks the credentials of a request.
+type authenticator interface {
+	authenticate(r *http.Request) bool
+}
+
+type authFunc func(r *http.Request) bool
+
+func (f authFunc) authenticate(r *http.Request) bool { return f(r) }
+
+// authHandler wraps a handler requiring requests to pass the policy of the
+// route they are sent to.
+type authHandler struct {
+	log            *logp.Logger
+	next           http.Handler
+	authenticators map[string]authenticator
+	defaults       []string
+	routes         []RoutePolicy
+}
+
+func newAuthHandler(log *logp.Logger, cfg *AuthConfig, ks keystore.Keystore, next http.Handler) (*authHandler, error) {
+	h := &authHandler{
+		log:            log,
+		next:           next,
+		authenticators: map[string]authenticator{AuthNone: authFunc(func(*http.Request) bool { return true })},
+	}
+
+	tokens, err := bearerTokens(cfg.Bearer, ks)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 {
+		h.authenticators[AuthBearer] = authFunc(func(r *http.Request) bool {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			return ok && token != "" && matchAny(tokens, []byte(token))
+		})
+	}
+
+	if len(cfg.Basic) > 0 {
+		users := cfg.Basic
+		h.authenticators[AuthBasic] = authFunc(func(r *http.Request) bool {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				return false
+			}
+			for _, u := range users {
+				// check both to not leak which usernames exist through timing
+				userOK := subtle.ConstantTimeCompare([]byte(u.Username), []byte(username)) == 1
+				passOK := subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+				if userOK && passOK {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if cfg.Peer != nil {
+		if !peerCredSupported {
+			return nil, errors.New("peer authentication is not supported on this platform")
+		}
+		h.authenticators[AuthPeer] = newPeerAuthenticator(cfg.Peer)
+	}
+
+	h.defaults = cfg.Default
+	if len(h.defaults) == 0 {
+		for name := range h.authenticators {
+			if name != AuthNone {
+				h.defaults = append(h.defaults, name)
+			}
+		}
+		sort.Strings(h.defaults)
+	}
+	if len(h.defaults) == 0 {
+		return nil, errors.New("no authentication method configured")
+	}
+
+	policies := append([]RoutePolicy{{Path: "default", Allow: h.defaults}}, cfg.Routes...)
+	for _, p := range policies {
+		for _, method := range p.Allow {
+			if _, ok := h.authenticators[method]; !ok {
+				return nil, fmt.Errorf("authentication method %q used by route %s is not configured", method, p.Path)
+			}
+		}
+	}
+
+	// longest path first, such that the first match is the most specific one
+	h.routes = append([]RoutePolicy(nil), cfg.Routes...)
+	sort.SliceStable(h.routes, func(i, j int) bool {
+		return len(h.routes[i].Path) > len(h.routes[j].Path)
+	})
+
+	return h, nil
+}
+
+func bearerTokens(cfg BearerAuthConfig, ks keystore.Keystore) ([][]byte, error) {
+	tokens := make([][]byte, 0, len(cfg.Tokens)+len(cfg.KeystoreKeys))
+	for i, t := range cfg.Tokens {
+		if t == "" {
+			return nil, fmt.Errorf("bearer token %d is empty", i)
+		}
+		tokens = append(tokens, []byte(t))
+	}
+
+	if len(cfg.KeystoreKeys) > 0 && ks == nil {
+		return nil, errors.New("bearer tokens reference keystore keys, but no keystore is available")
+	}
+	for _, key := range cfg.KeystoreKeys {
+		secret, err := ks.Retrieve(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve bearer token %s from the keystore: %w", key, err)
+		}
+		token, err := secret.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token %s from the keystore: %w", key, err)
+		}
+		if len(token) == 0 {
+			return nil, fmt.Errorf("bearer token %s in the keystore is empty", key)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func matchAny(secrets [][]byte, v []byte) bool {
+	found := false
+	for _, s := range secrets {
+		if subtle.ConstantTimeCompare(s, v) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// policy returns the authentication methods accepted for the path.
+func (h *authHandler) policy(path string) []string {
+	for _, p := range h.routes {
+		if path == p.Path || (strings.HasSuffix(p.Path, "/") && strings.HasPrefix(path, p.Path)) {
+			return p.Allow
+		}
+	}
+	return h.defaults
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allow := h.policy(r.URL.Path)
+	for _, method := range allow {
+		if h.authenticators[method].authenticate(r) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	h.log.Debugf("Rejected unauthenticated request to %s from %s", r.URL.Path, r.RemoteAddr)
+	for _, method := range allow {
+		switch method {
+		case AuthBearer:
+			w.Header().Add("WWW-Authenticate", "Bearer")
+		case AuthBasic:
+			w.Header().Add("WWW-Authenticate", `Basic realm="api"`)
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// peerCred holds the credentials of the process connected to a Unix socket.
+type peerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+type peerCredKey struct{}
+
+// connContext stores the peer credentials of Unix socket connections in the
+// request context. Connections without credentials store none.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	cred, err := peerCredentials(c)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+func newPeerAuthenticator(cfg *PeerAuthConfig) authenticator {
+	uids, gids := cfg.UIDs, cfg.GIDs
+	if len(uids) == 0 && len(gids) == 0 {
+		uids = []int{os.Getuid()}
+	}
+	return authFunc(func(r *http.Request) bool {
+		cred, ok := r.Context().Value(peerCredKey{}).(peerCred)
+		if !ok {
+			return false
+		}
+		return containsInt(uids, cred.UID) || containsInt(gids, cred.GID)
+	})
+}
+
+func containsInt(l []int, v int) bool {
+	for _, x := range l {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
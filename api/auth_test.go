@@ -0,0 +1,265 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/keystore"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+type mapKeystore map[string]string
+
+func (m mapKeystore) Retrieve(key string) (*keystore.SecureString, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, keystore.ErrKeyDoesntExists
+	}
+	return keystore.NewSecureString([]byte(v)), nil
+}
+
+func (m mapKeystore) GetConfig() (*config.C, error) { return config.NewConfig(), nil }
+func (m mapKeystore) IsPersisted() bool             { return false }
+
+func newTestAuthHandler(t *testing.T, settings map[string]interface{}, ks keystore.Keystore) (*authHandler, error) {
+	t.Helper()
+	var cfg AuthConfig
+	if err := config.MustNewConfigFrom(settings).Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return newAuthHandler(logp.NewLogger(""), &cfg, ks, ok)
+}
+
+func TestAuthPolicies(t *testing.T) {
+	h, err := newTestAuthHandler(t, map[string]interface{}{
+		"bearer.tokens":        []string{"stats-token"},
+		"bearer.keystore_keys": []string{"api.admin_token"},
+		"basic":                []map[string]interface{}{{"username": "admin", "password": "secret"}},
+		"routes": []map[string]interface{}{
+			{"path": "/debug/pprof/", "allow": []string{"basic"}},
+			{"path": "/debug/pprof/cmdline", "allow": []string{"none"}},
+			{"path": "/health", "allow": []string{"none"}},
+		},
+	}, mapKeystore{"api.admin_token": "admin-token"})
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		path     string
+		setup    func(r *http.Request)
+		expected int
+	}{
+		"default without credentials": {
+			path:     "/stats",
+			expected: http.StatusUnauthorized,
+		},
+		"default with token": {
+			path:     "/stats",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer stats-token") },
+			expected: http.StatusOK,
+		},
+		"default with keystore token": {
+			path:     "/stats",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-token") },
+			expected: http.StatusOK,
+		},
+		"default with invalid token": {
+			path:     "/stats",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer invalid") },
+			expected: http.StatusUnauthorized,
+		},
+		"default with empty token": {
+			path:     "/stats",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+			expected: http.StatusUnauthorized,
+		},
+		"default with basic auth": {
+			path:     "/stats",
+			setup:    func(r *http.Request) { r.SetBasicAuth("admin", "secret") },
+			expected: http.StatusOK,
+		},
+		"subtree requires basic auth": {
+			path:     "/debug/pprof/heap",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer stats-token") },
+			expected: http.StatusUnauthorized,
+		},
+		"subtree with basic auth": {
+			path:     "/debug/pprof/heap",
+			setup:    func(r *http.Request) { r.SetBasicAuth("admin", "secret") },
+			expected: http.StatusOK,
+		},
+		"subtree with wrong password": {
+			path:     "/debug/pprof/heap",
+			setup:    func(r *http.Request) { r.SetBasicAuth("admin", "wrong") },
+			expected: http.StatusUnauthorized,
+		},
+		"longest match wins": {
+			path:     "/debug/pprof/cmdline",
+			expected: http.StatusOK,
+		},
+		"public route": {
+			path:     "/health",
+			expected: http.StatusOK,
+		},
+		"exact path does not match subtree": {
+			path:     "/health/details",
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.setup != nil {
+				tc.setup(req)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expected, rec.Code)
+			if tc.expected == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Values("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthConfigErrors(t *testing.T) {
+	cases := map[string]struct {
+		settings map[string]interface{}
+		keystore keystore.Keystore
+		err      string
+	}{
+		"nothing configured": {
+			settings: map[string]interface{}{"routes": []map[string]interface{}{{"path": "/", "allow": []string{"none"}}}},
+			err:      "no authentication method configured",
+		},
+		"unknown method": {
+			settings: map[string]interface{}{"bearer.tokens": []string{"t"}, "default": []string{"magic"}},
+			err:      `unknown authentication method "magic"`,
+		},
+		"method not configured": {
+			settings: map[string]interface{}{
+				"bearer.tokens": []string{"t"},
+				"routes":        []map[string]interface{}{{"path": "/", "allow": []string{"basic"}}},
+			},
+			err: `authentication method "basic" used by route / is not configured`,
+		},
+		"missing keystore": {
+			settings: map[string]interface{}{"bearer.keystore_keys": []string{"token"}},
+			err:      "no keystore is available",
+		},
+		"missing keystore key": {
+			settings: map[string]interface{}{"bearer.keystore_keys": []string{"token"}},
+			keystore: mapKeystore{},
+			err:      "failed to retrieve bearer token token",
+		},
+		"empty token": {
+			settings: map[string]interface{}{"bearer.tokens": []string{"t", ""}},
+			err:      "bearer token 1 is empty",
+		},
+		"empty keystore token": {
+			settings: map[string]interface{}{"bearer.keystore_keys": []string{"token"}},
+			keystore: mapKeystore{"token": ""},
+			err:      "bearer token token in the keystore is empty",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newTestAuthHandler(t, tc.settings, tc.keystore)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestPeerAuth(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer authentication is only supported on Linux")
+	}
+
+	cases := map[string]struct {
+		peer     map[string]interface{}
+		expected int
+	}{
+		"same user": {
+			peer:     map[string]interface{}{},
+			expected: http.StatusOK,
+		},
+		"allowed uid": {
+			peer:     map[string]interface{}{"uids": []int{os.Getuid()}},
+			expected: http.StatusOK,
+		},
+		"allowed gid": {
+			peer:     map[string]interface{}{"gids": []int{os.Getgid()}},
+			expected: http.StatusOK,
+		},
+		"other user": {
+			peer:     map[string]interface{}{"uids": []int{os.Getuid() + 1}},
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sockFile := t.TempDir() + "/test.sock"
+			cfg := config.MustNewConfigFrom(map[string]interface{}{
+				"host":      "unix://" + sockFile,
+				"auth.peer": tc.peer,
+			})
+
+			s, err := New(nil, simpleMux(), cfg)
+			require.NoError(t, err)
+			go s.Start()
+			defer func() {
+				require.NoError(t, s.Stop())
+			}()
+
+			assert.Equal(t, tc.expected, getStatus(t, sockFile, "http://unix/echo-hello"))
+		})
+	}
+}
+
+func getStatus(t *testing.T, sockFile, url string) int {
+	t.Helper()
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", sockFile)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+	r, err := client.Do(req)
+	require.NoError(t, err)
+	defer r.Body.Close()
+	return r.StatusCode
+}
@@ -18,18 +18,96 @@
 package api
 
 import (
+	"fmt"
 	"os"
 	"time"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
 
 // Config is the configuration for the API endpoint.
 type Config struct {
-	Enabled            bool          `config:"enabled"`
+	Enabled bool `config:"enabled"`
+
+	// Host is the address to listen on. Besides a host name, it can be a
+	// URL with one of the schemes:
+	//   - http:// or https:// for TCP.
+	//   - unix:///path/to/socket for a unix socket file, or unix://@name for
+	//     a Linux abstract unix socket, that is not bound to a file.
+	//   - fd:// for the socket passed by systemd socket activation, or
+	//     fd://name for the passed socket named name in LISTEN_FDNAMES.
+	//   - npipe:///name for a Windows named pipe.
 	Host               string        `config:"host"`
 	Port               int           `config:"port"`
 	User               string        `config:"named_pipe.user"`
 	SecurityDescriptor string        `config:"named_pipe.security_descriptor"`
 	Timeout            time.Duration `config:"timeout"`
+
+	// ShutdownTimeout is the time requests in flight are given to complete
+	// when the server is stopped or moved to a new listener.
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" validate:"min=0"`
+
+	// UnixSocket configures the socket created for unix:// hosts.
+	UnixSocket UnixSocketConfig `config:"unix_socket"`
+
+	// TLS configures HTTPS for the endpoint. Setting certificate_authorities
+	// requires clients to present a certificate signed by one of them.
+	TLS *tlscommon.ServerConfig `config:"ssl"`
+
+	// Auth configures the authentication of requests. If not set, all
+	// requests are accepted.
+	Auth *AuthConfig `config:"auth"`
+
+	// AccessLog enables logging every request.
+	AccessLog bool `config:"access_log"`
+
+	// RateLimit limits the requests accepted per client.
+	RateLimit RateLimitConfig `config:"rate_limit"`
+
+	// Stream bounds the interval of the metric snapshots streamed by the
+	// /stream endpoint.
+	Stream StreamConfig `config:"stream"`
+}
+
+// UnixSocketConfig configures the ownership and mode of the Unix socket and
+// the peers allowed to connect to it.
+type UnixSocketConfig struct {
+	// Mode of the socket file, defaults to 0740.
+	Mode uint32 `config:"mode"`
+
+	// User and Group set the owner of the socket file, by name or numeric ID.
+	User  string `config:"user"`
+	Group string `config:"group"`
+
+	// AllowedUIDs and AllowedGIDs restrict the connections accepted to peer
+	// processes running with one of the user or group IDs. Connections of
+	// other peers are closed and logged. Only supported on Linux.
+	AllowedUIDs []int `config:"allowed_uids"`
+	AllowedGIDs []int `config:"allowed_gids"`
+}
+
+// Validate checks the mode is a valid permission.
+func (c *UnixSocketConfig) Validate() error {
+	if c.Mode > 0o777 {
+		return fmt.Errorf("invalid unix socket mode %o", c.Mode)
+	}
+	return nil
+}
+
+func (c *UnixSocketConfig) restrictsPeers() bool {
+	return len(c.AllowedUIDs) > 0 || len(c.AllowedGIDs) > 0
+}
+
+// managesFile returns true if settings of the socket file are configured.
+func (c *UnixSocketConfig) managesFile() bool {
+	return c.Mode != 0 || c.User != "" || c.Group != ""
+}
+
+func (c *UnixSocketConfig) fileMode() os.FileMode {
+	if c.Mode == 0 {
+		return socketFileMode
+	}
+	return os.FileMode(c.Mode)
 }
 
 // DefaultConfig is the default configuration used by the API endpoint.
@@ -39,7 +117,22 @@ func DefaultConfig() Config {
 		Host:    "localhost",
 		Port:    5066,
 		Timeout: time.Second * 5,
+
+		ShutdownTimeout: defaultShutdownTimeout,
+		Stream: StreamConfig{
+			MinInterval: defaultStreamMinInterval,
+			MaxInterval: defaultStreamMaxInterval,
+		},
+	}
+}
+
+const defaultShutdownTimeout = 5 * time.Second
+
+func (c Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
 	}
+	return c.ShutdownTimeout
 }
 
 // File mode for the socket file, owner of the process can do everything, member of the group can read.
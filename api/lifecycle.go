@@ -0,0 +1,217 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// ErrServerStopped is returned when starting or reloading a stopped server.
+var ErrServerStopped = errors.New("api server is stopped")
+
+// errAlreadyStarted is returned when starting a server twice.
+var errAlreadyStarted = errors.New("api server is already started")
+
+// start starts serving the current listener.
+func (s *Server) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrServerStopped
+	}
+	if s.started {
+		return errAlreadyStarted
+	}
+	s.started = true
+
+	go s.serve(s.srv, s.l, s.config.Host)
+	s.readyOnce.Do(func() { close(s.ready) })
+	return nil
+}
+
+func (s *Server) serve(srv *http.Server, l net.Listener, host string) {
+	s.log.Infof("Metrics endpoint listening on: %s (configured: %s)", l.Addr().String(), host)
+	err := srv.Serve(l)
+	s.log.Infof("Stats endpoint (%s) finished: %v", l.Addr().String(), err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv == srv && !s.stopped && !errors.Is(err, http.ErrServerClosed) {
+		select {
+		case s.errs <- err:
+		default:
+		}
+	}
+}
+
+// Ready returns a channel that is closed once the server accepts connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Run serves requests until ctx is cancelled, then gracefully drains the
+// server. Requests in flight are given the configured shutdown_timeout to
+// complete. Run returns an error if the server fails, or if the drain does
+// not complete in time.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-s.errs:
+		_ = s.Stop()
+		return fmt.Errorf("api server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	timeout := s.config.shutdownTimeout()
+	s.mu.Unlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		s.close()
+		return fmt.Errorf("failed to drain api server within %v: %w", timeout, err)
+	}
+	return nil
+}
+
+// close forcibly closes the current server and all connections.
+func (s *Server) close() {
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	_ = srv.Close()
+}
+
+// Reload applies a new configuration to the running server.
+// See ReloadFromConfig for details.
+func (s *Server) Reload(c *config.C) error {
+	cfg := DefaultConfig()
+	if err := c.Unpack(&cfg); err != nil {
+		return err
+	}
+	return s.ReloadFromConfig(cfg)
+}
+
+// ReloadFromConfig applies a new configuration to the running server. If
+// the address is unchanged, the listener is kept and only the request
+// handling, TLS and peer settings are updated. Otherwise the previous listener
+// is closed, such that the new one can bind the same socket, and its requests
+// in flight are drained in the background. The timeout setting only applies to
+// new listeners. On error, the server keeps running with the previous
+// configuration.
+func (s *Server) ReloadFromConfig(cfg Config) error {
+	handler, tlsConfig, err := s.build(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrServerStopped
+	}
+
+	if sameAddress(s.config, cfg) {
+		if err := s.l.configure(cfg); err != nil {
+			return err
+		}
+		s.tlsConfig.Store(tlsConfig)
+		s.handler.Store(&handlerRef{handler})
+		s.config = cfg
+		s.log.Info("Reloaded stats endpoint configuration")
+		return nil
+	}
+
+	oldSrv, oldL, oldCfg := s.srv, s.l, s.config
+	_ = oldL.Close()
+
+	l, err := s.listen(cfg)
+	if err != nil {
+		err = fmt.Errorf("failed to create listener for %s: %w", cfg.Host, err)
+
+		// keep serving the previous configuration, on the port bound before
+		restoreCfg := oldCfg
+		if oldL.Addr().Network() == tcpNetwork {
+			restoreCfg.Host = "http://" + oldL.Addr().String()
+		}
+		l, restoreErr := s.listen(restoreCfg)
+		if restoreErr != nil {
+			return fmt.Errorf("%w, failed to restore listener for %s: %w", err, oldCfg.Host, restoreErr)
+		}
+		s.replaceServer(oldSrv, oldL, oldCfg, l, oldCfg)
+		return err
+	}
+
+	s.tlsConfig.Store(tlsConfig)
+	s.handler.Store(&handlerRef{handler})
+	s.replaceServer(oldSrv, oldL, oldCfg, l, cfg)
+	return nil
+}
+
+// replaceServer serves l with a new server for cfg and drains the previous
+// server in the background.
+func (s *Server) replaceServer(oldSrv *http.Server, oldL net.Listener, oldCfg Config, l *serverListener, cfg Config) {
+	s.log.Infof("Moving stats endpoint from %s to %s", oldL.Addr().String(), l.Addr().String())
+	s.srv, s.l, s.config = s.newHTTPServer(cfg), l, cfg
+	if !s.started {
+		return
+	}
+	go s.serve(s.srv, s.l, cfg.Host)
+
+	s.draining.Add(1)
+	go func() {
+		defer s.draining.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), oldCfg.shutdownTimeout())
+		defer cancel()
+		if err := oldSrv.Shutdown(ctx); err != nil {
+			s.log.Warnf("Failed to drain stats endpoint %s: %v", oldL.Addr().String(), err)
+			_ = oldSrv.Close()
+		}
+	}()
+}
+
+// sameAddress returns true if both configurations bind the same listener.
+// They may differ in the TLS and peer settings applied to it.
+func sameAddress(a, b Config) bool {
+	return listenAddress(a) == listenAddress(b) &&
+		a.User == b.User &&
+		a.SecurityDescriptor == b.SecurityDescriptor &&
+		a.UnixSocket.Mode == b.UnixSocket.Mode &&
+		a.UnixSocket.User == b.UnixSocket.User &&
+		a.UnixSocket.Group == b.UnixSocket.Group
+}
+
+// listenAddress returns the network address cfg binds, ignoring the scheme
+// of the host.
+func listenAddress(cfg Config) string {
+	network, path, err := parse(cfg.Host, cfg.Port)
+	if err != nil {
+		return cfg.Host
+	}
+	return network + ":" + path
+}
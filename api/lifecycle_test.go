@@ -0,0 +1,287 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+// blockingMux serves /block, blocking until release is closed. Each
+// request signals entered when it started.
+func blockingMux(entered chan<- struct{}, release <-chan struct{}) *http.ServeMux {
+	mux := simpleMux()
+	mux.HandleFunc("/block", func(w http.ResponseWriter, _ *http.Request) {
+		entered <- struct{}{}
+		<-release
+		fmt.Fprintf(w, "released")
+	})
+	return mux
+}
+
+func doGet(addr, path string) (int, string, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer r.Body.Close()
+	body, err := httpcommon.ReadAll(r)
+	return r.StatusCode, string(body), err
+}
+
+type getResult struct {
+	body string
+	err  error
+}
+
+func asyncGet(addr, path string) <-chan getResult {
+	ch := make(chan getResult, 1)
+	go func() {
+		_, body, err := doGet(addr, path)
+		ch <- getResult{body, err}
+	}()
+	return ch
+}
+
+func runServer(t *testing.T, s *Server) (context.CancelFunc, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not become ready")
+	}
+	return cancel, done
+}
+
+func TestRunDrainsRequests(t *testing.T) {
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	s, err := New(nil, blockingMux(entered, release), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+	addr := s.Addr().String()
+
+	inFlight := asyncGet(addr, "/block")
+	<-entered
+	cancel()
+
+	// new connections are refused while draining
+	require.Eventually(t, func() bool {
+		_, _, err := doGet(addr, "/echo-hello")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(release)
+	res := <-inFlight
+	require.NoError(t, res.err)
+	assert.Equal(t, "released", res.body)
+	assert.NoError(t, <-done)
+}
+
+func TestRunDrainTimeout(t *testing.T) {
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s, err := New(nil, blockingMux(entered, release), config.MustNewConfigFrom(map[string]interface{}{
+		"host":             localhostURL,
+		"shutdown_timeout": "50ms",
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+
+	inFlight := asyncGet(s.Addr().String(), "/block")
+	<-entered
+	cancel()
+
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+	assert.Error(t, (<-inFlight).err, "request must be interrupted once the drain timed out")
+}
+
+func TestRunStoppedServer(t *testing.T) {
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	require.NoError(t, s.Stop())
+
+	assert.ErrorIs(t, s.Run(context.Background()), ErrServerStopped)
+}
+
+func TestReloadSameListener(t *testing.T) {
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+	addr := s.Addr().String()
+
+	status, _, err := doGet(addr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	// the port is resolved already, reload with the same host keeps it
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host":        localhostURL,
+		"auth.bearer": map[string]interface{}{"tokens": []string{"secret"}},
+	})))
+	assert.Equal(t, addr, s.Addr().String())
+
+	status, _, err = doGet(addr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// invalid configurations keep the previous one
+	err = s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host":         localhostURL,
+		"auth.default": []string{"magic"},
+	}))
+	assert.Error(t, err)
+	status, _, err = doGet(addr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestReloadNewListener(t *testing.T) {
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	s, err := New(nil, blockingMux(entered, release), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+	oldAddr := s.Addr().String()
+
+	inFlight := asyncGet(oldAddr, "/block")
+	<-entered
+
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host": "http://127.0.0.1:0",
+	})))
+	newAddr := s.Addr().String()
+	require.NotEqual(t, oldAddr, newAddr)
+
+	_, body, err := doGet(newAddr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+
+	close(release)
+	res := <-inFlight
+	require.NoError(t, res.err, "in-flight request must complete on the old listener")
+	assert.Equal(t, "released", res.body)
+
+	require.Eventually(t, func() bool {
+		_, _, err := doGet(oldAddr, "/echo-hello")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond, "old listener must be closed")
+}
+
+func TestReloadListenerFailure(t *testing.T) {
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+	addr := s.Addr().String()
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	err = s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host": "http://" + taken.Addr().String(),
+	}))
+	assert.ErrorContains(t, err, "failed to create listener")
+
+	// the previous listener is restored
+	assert.Equal(t, addr, s.Addr().String())
+	_, body, err := doGet(addr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+}
+
+func TestReloadUnixSocket(t *testing.T) {
+	if isWindows() {
+		t.Skip("Unix Sockets don't work under windows")
+		return
+	}
+
+	sockFile := t.TempDir() + "/test.sock"
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": "unix://" + sockFile,
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host":             "unix://" + sockFile,
+		"unix_socket.mode": 0o700,
+	})))
+
+	// wait for the old listener to be drained, it must not remove the new socket
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", sockFile)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	s.draining.Wait()
+
+	assert.Equal(t, "ehlo!", getResponse(t, sockFile, "http://unix/echo-hello"))
+	fi, err := os.Stat(sockFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), fi.Mode().Perm())
+
+	cancel()
+	require.NoError(t, <-done)
+	_, err = os.Stat(sockFile)
+	assert.True(t, os.IsNotExist(err))
+}
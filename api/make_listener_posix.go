@@ -24,11 +24,16 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/elastic/elastic-agent-libs/api/npipe"
+	"github.com/elastic/elastic-agent-libs/logp"
 )
 
-func makeListener(cfg Config) (net.Listener, error) {
+func makeListener(_ *logp.Logger, cfg Config) (net.Listener, error) {
 	if len(cfg.User) > 0 {
 		return nil, errors.New("specifying a user is not supported under this platform")
 	}
@@ -46,7 +51,33 @@ func makeListener(cfg Config) (net.Listener, error) {
 		return nil, err
 	}
 
-	if network == unixNetwork {
+	if network == fdNetwork {
+		return makeActivatedListener(cfg, path)
+	}
+
+	if network != unixNetwork && cfg.UnixSocket.restrictsPeers() {
+		return nil, fmt.Errorf("allowed_uids and allowed_gids require a unix socket, got host %s", cfg.Host)
+	}
+	if cfg.UnixSocket.restrictsPeers() && !peerCredSupported {
+		return nil, errors.New("restricting unix socket peers is not supported on this platform")
+	}
+
+	abstract := network == unixNetwork && isAbstract(path)
+	if abstract {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("cannot use %s as the host, abstract unix sockets are only supported on Linux", cfg.Host)
+		}
+		if cfg.UnixSocket.managesFile() {
+			return nil, fmt.Errorf("unix_socket mode, user and group cannot be set for the abstract unix socket %s", cfg.Host)
+		}
+	}
+
+	uid, gid, err := socketOwner(cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == unixNetwork && !abstract {
 		if _, err := os.Stat(path); !os.IsNotExist(err) {
 			if err := os.Remove(path); err != nil {
 				return nil, fmt.Errorf("cannot remove existing unix socket file at location %s: %w", path, err)
@@ -59,16 +90,85 @@ func makeListener(cfg Config) (net.Listener, error) {
 		return nil, err
 	}
 
-	// Ensure file mode
-	if network == unixNetwork {
-		if err := os.Chmod(path, socketFileMode); err != nil {
+	// Ensure file mode and ownership
+	if network == unixNetwork && !abstract {
+		mode := cfg.UnixSocket.fileMode()
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
 			return nil, fmt.Errorf("could not set mode %d for unix socket file at location %s: %w",
-				socketFileMode,
+				mode,
 				path,
 				err,
 			)
 		}
+		if uid != -1 || gid != -1 {
+			if err := os.Chown(path, uid, gid); err != nil {
+				l.Close()
+				return nil, fmt.Errorf("could not set owner of unix socket file at location %s: %w", path, err)
+			}
+		}
 	}
 
 	return l, nil
 }
+
+// isAbstract returns true if the unix socket path names a Linux abstract
+// socket, that does not exist in the file system.
+func isAbstract(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// makeActivatedListener creates a listener for the socket named name passed
+// by the service manager. The socket is owned by the service manager, only
+// peer restrictions can be applied.
+func makeActivatedListener(cfg Config, name string) (net.Listener, error) {
+	if cfg.UnixSocket.managesFile() {
+		return nil, fmt.Errorf("unix_socket mode, user and group cannot be set for the socket %s passed by the service manager", cfg.Host)
+	}
+
+	l, err := activatedListener(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.UnixSocket.restrictsPeers() {
+		if l.Addr().Network() != unixNetwork {
+			l.Close()
+			return nil, fmt.Errorf("allowed_uids and allowed_gids require a unix socket, the socket %s passed by the service manager is %s", cfg.Host, l.Addr().Network())
+		}
+		if !peerCredSupported {
+			l.Close()
+			return nil, errors.New("restricting unix socket peers is not supported on this platform")
+		}
+	}
+	return l, nil
+}
+
+// socketOwner resolves the configured user and group of the socket file. -1
+// is returned for an unset user or group, keeping the current one.
+func socketOwner(cfg UnixSocketConfig) (int, int, error) {
+	uid, gid := -1, -1
+	if cfg.User != "" {
+		id := cfg.User
+		if _, err := strconv.Atoi(id); err != nil {
+			u, err := user.Lookup(cfg.User)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown unix socket user %s: %w", cfg.User, err)
+			}
+			id = u.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if cfg.Group != "" {
+		id := cfg.Group
+		if _, err := strconv.Atoi(id); err != nil {
+			g, err := user.LookupGroup(cfg.Group)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown unix socket group %s: %w", cfg.Group, err)
+			}
+			id = g.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return uid, gid, nil
+}
@@ -25,13 +25,18 @@ import (
 	"net"
 
 	"github.com/elastic/elastic-agent-libs/api/npipe"
+	"github.com/elastic/elastic-agent-libs/logp"
 )
 
-func makeListener(cfg Config) (net.Listener, error) {
+func makeListener(_ *logp.Logger, cfg Config) (net.Listener, error) {
 	if len(cfg.User) > 0 && len(cfg.SecurityDescriptor) > 0 {
 		return nil, errors.New("user and security_descriptor are mutually exclusive, define only one of them")
 	}
 
+	if cfg.UnixSocket.restrictsPeers() || cfg.UnixSocket.User != "" || cfg.UnixSocket.Group != "" {
+		return nil, errors.New("unix_socket options are not supported on Windows")
+	}
+
 	if npipe.IsNPipe(cfg.Host) {
 		pipe := npipe.TransformString(cfg.Host)
 		var sd string
@@ -52,6 +57,10 @@ func makeListener(cfg Config) (net.Listener, error) {
 		return nil, err
 	}
 
+	if network == fdNetwork {
+		return nil, fmt.Errorf("cannot use %s as the host, socket activation is not supported on Windows", cfg.Host)
+	}
+
 	if network == unixNetwork {
 		return nil, fmt.Errorf(
 			"cannot use %s as the host, unix sockets are not supported on Windows, use npipe instead",
@@ -0,0 +1,374 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// Middleware wraps a handler to add functionality to all requests.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the middlewares. The first middleware is the outermost,
+// seeing each request first.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// responseRecorder records the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush supports streaming responses through the recorder.
+func (r *responseRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports taking over the connection through the recorder.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return h.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Recover returns a middleware that recovers from panics in handlers, logs
+// them with the stack trace and responds with 500 if no response has been
+// written yet.
+func Recover(log *logp.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newResponseRecorder(w)
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler { //nolint:errorlint // sentinel passed to panic, not wrapped
+					panic(err)
+				}
+
+				log.Errorw(fmt.Sprintf("Panic serving %s %s: %v", r.Method, r.URL.Path, err),
+					"stack", string(debug.Stack()))
+				if rec.status == 0 {
+					http.Error(rec, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// AccessLog returns a middleware logging every request when it completes.
+func AccessLog(log *logp.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			log.Infow(fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.statusCode()),
+				"http.request.method", r.Method,
+				"url.path", r.URL.Path,
+				"http.response.status_code", rec.statusCode(),
+				"http.response.body.bytes", rec.bytes,
+				"event.duration", time.Since(start).Nanoseconds(),
+				"client.address", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+// latencyBuckets are the upper bounds of the request latency histogram.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// routeMetrics holds the request metrics of a single route.
+type routeMetrics struct {
+	requests *monitoring.Uint
+	duration *monitoring.Uint
+
+	// latency holds cumulative bucket counters, the last one counting all
+	// requests.
+	latency []*monitoring.Uint
+
+	// status counts responses by status class, 1xx to 5xx.
+	status [5]*monitoring.Uint
+}
+
+func newRouteMetrics(reg *monitoring.Registry) *routeMetrics {
+	m := &routeMetrics{
+		requests: monitoring.NewUint(reg, "requests", monitoring.Counter),
+		duration: monitoring.NewUint(reg, "duration.ns", monitoring.Counter),
+	}
+	for _, b := range latencyBuckets {
+		m.latency = append(m.latency, monitoring.NewUint(reg, "latency.le_"+b.String(), monitoring.Counter))
+	}
+	m.latency = append(m.latency, monitoring.NewUint(reg, "latency.le_inf", monitoring.Counter))
+	for i := range m.status {
+		m.status[i] = monitoring.NewUint(reg, "status."+strconv.Itoa(i+1)+"xx", monitoring.Counter)
+	}
+	return m
+}
+
+func (m *routeMetrics) observe(status int, d time.Duration) {
+	m.requests.Inc()
+	m.duration.Add(uint64(d.Nanoseconds()))
+	for i, b := range latencyBuckets {
+		if d <= b {
+			m.latency[i].Inc()
+		}
+	}
+	m.latency[len(m.latency)-1].Inc()
+	if class := status/100 - 1; class >= 0 && class < len(m.status) {
+		m.status[class].Inc()
+	}
+}
+
+// RequestMetrics returns a middleware counting requests, their latency and
+// response status per route in reg. The route of a request is returned by
+// routeFn, requests with an empty route are reported as "other".
+func RequestMetrics(reg *monitoring.Registry, routeFn func(*http.Request) string) Middleware {
+	var (
+		mu     sync.Mutex
+		routes = map[string]*routeMetrics{}
+	)
+	metricsFor := func(route string) *routeMetrics {
+		if route == "" {
+			route = "other"
+		}
+		// dots would create nested registries
+		route = strings.ReplaceAll(route, ".", "_")
+
+		mu.Lock()
+		defer mu.Unlock()
+		m, ok := routes[route]
+		if !ok {
+			m = newRouteMetrics(reg.NewRegistry(route))
+			routes[route] = m
+		}
+		return m
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			defer func() {
+				metricsFor(routeFn(r)).observe(rec.statusCode(), time.Since(start))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// RateLimitConfig configures the number of requests accepted per client.
+type RateLimitConfig struct {
+	// Limit is the number of requests per second accepted per client. Zero
+	// disables rate limiting.
+	Limit float64 `config:"limit" validate:"min=0"`
+
+	// Burst is the number of requests a client can send at once, defaults
+	// to 1 if a limit is set.
+	Burst int `config:"burst" validate:"min=0"`
+}
+
+// IsEnabled returns true if a rate limit is configured.
+func (c RateLimitConfig) IsEnabled() bool {
+	return c.Limit > 0
+}
+
+// bucket is a token bucket of a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	limit float64
+	burst float64
+
+	mu        sync.Mutex
+	clients   map[string]*bucket
+	lastSweep time.Time
+
+	// nowFn is used to acquire the current time instead of time.Now so it can
+	// be mocked for tests.
+	nowFn func() time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		limit:   cfg.Limit,
+		burst:   float64(burst),
+		clients: map[string]*bucket{},
+		nowFn:   time.Now,
+	}
+}
+
+// allow takes a token of the client. If no token is available, the time
+// until the next token is returned.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.nowFn()
+	l.sweep(now)
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.limit)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.limit * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes clients whose buckets are full again, at most once per refill
+// period of a full bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.limit * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= refill {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// RateLimit returns a middleware limiting the requests per client IP address.
+// Requests exceeding the limit are answered with 429 and a Retry-After header.
+//
+// Unix socket and named pipe connections have no client address. Requests
+// over Unix sockets are limited per user ID of the connected process, if its
+// credentials are available. Other local requests are not limited, as they
+// would share a single limit otherwise.
+func RateLimit(cfg RateLimitConfig) Middleware {
+	l := newRateLimiter(cfg)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, ok := rateLimitKey(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, wait := l.allow(client)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey returns the key of the client's rate limit: the IP address of
+// the client, or the user ID of the process connected to a Unix socket. False
+// is returned if the client can not be identified.
+func rateLimitKey(r *http.Request) (string, bool) {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && net.ParseIP(host) != nil {
+		return host, true
+	}
+	if cred, ok := r.Context().Value(peerCredKey{}).(peerCred); ok {
+		return "uid:" + strconv.Itoa(cred.UID), true
+	}
+	return "", false
+}
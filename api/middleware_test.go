@@ -0,0 +1,254 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+func newObservedLogger() (*logp.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return logp.NewLogger("", zap.WrapCore(func(zapcore.Core) zapcore.Core { return core })), logs
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		calls = append(calls, "handler")
+	}), mark("first"), mark("second"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	log, logs := newObservedLogger()
+
+	t.Run("before response", func(t *testing.T) {
+		h := Recover(log)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "Panic serving GET /stats: boom", entries[0].Message)
+		assert.Contains(t, entries[0].ContextMap()["stack"], "TestRecover")
+	})
+
+	t.Run("after response", func(t *testing.T) {
+		h := Recover(log)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, 1, logs.Len())
+	})
+
+	t.Run("abort handler", func(t *testing.T) {
+		h := Recover(log)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	log, logs := newObservedLogger()
+	h := AccessLog(log)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/tea", nil))
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "POST /tea 418", entries[0].Message)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "POST", fields["http.request.method"])
+	assert.Equal(t, "/tea", fields["url.path"])
+	assert.EqualValues(t, http.StatusTeapot, fields["http.response.status_code"])
+	assert.EqualValues(t, 15, fields["http.response.body.bytes"])
+}
+
+func TestRequestMetrics(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	h := RequestMetrics(reg, func(r *http.Request) string { return r.URL.Path })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				http.NotFound(w, r)
+			}
+		}))
+
+	for _, path := range []string{"/stats", "/stats", "/missing", "/v1.0"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Uints
+	assert.EqualValues(t, 2, snapshot["/stats.requests"])
+	assert.EqualValues(t, 2, snapshot["/stats.status.2xx"])
+	assert.EqualValues(t, 2, snapshot["/stats.latency.le_inf"])
+	assert.EqualValues(t, 1, snapshot["/missing.status.4xx"])
+	assert.EqualValues(t, 1, snapshot["/v1_0.requests"])
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(RateLimitConfig{Limit: 1, Burst: 2})
+	l.nowFn = func() time.Time { return now }
+
+	allowed := func(client string) bool {
+		ok, _ := l.allow(client)
+		return ok
+	}
+
+	assert.True(t, allowed("a"))
+	assert.True(t, allowed("a"))
+	ok, wait := l.allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// other clients have their own bucket
+	assert.True(t, allowed("b"))
+
+	now = now.Add(time.Second)
+	assert.True(t, allowed("a"))
+	assert.False(t, allowed("a"))
+
+	// idle clients are removed
+	now = now.Add(time.Minute)
+	l.allow("c")
+	assert.NotContains(t, l.clients, "a")
+	assert.NotContains(t, l.clients, "b")
+}
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(RateLimitConfig{Limit: 0.001, Burst: 1})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req.RemoteAddr = "10.0.0.1:5678"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1000", rec.Header().Get("Retry-After"))
+}
+
+func TestRateLimitLocalClients(t *testing.T) {
+	h := RateLimit(RateLimitConfig{Limit: 0.001, Burst: 1})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(remoteAddr string, cred *peerCred) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if cred != nil {
+			req = req.WithContext(context.WithValue(req.Context(), peerCredKey{}, *cred))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// unix socket clients are limited per user
+	assert.Equal(t, http.StatusOK, serve("@", &peerCred{PID: 1, UID: 1000}))
+	assert.Equal(t, http.StatusTooManyRequests, serve("@", &peerCred{PID: 2, UID: 1000}))
+	assert.Equal(t, http.StatusOK, serve("@", &peerCred{PID: 3, UID: 1001}))
+
+	// clients without address and credentials are not limited
+	assert.Equal(t, http.StatusOK, serve("", nil))
+	assert.Equal(t, http.StatusOK, serve("", nil))
+	assert.Equal(t, http.StatusOK, serve("@", nil))
+}
+
+func TestServerMiddlewares(t *testing.T) {
+	log, logs := newObservedLogger()
+	reg := monitoring.NewRegistry()
+
+	mux := simpleMux()
+	mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+
+	cfg := config.MustNewConfigFrom(map[string]interface{}{
+		"host":       localhostURL,
+		"access_log": true,
+	})
+	s, err := New(log, mux, cfg, WithMetrics(reg))
+	require.NoError(t, err)
+	go s.Start()
+	defer func() {
+		require.NoError(t, s.Stop())
+	}()
+
+	get := func(path string) int {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+s.Addr().String()+path, nil)
+		require.NoError(t, err)
+		r, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer r.Body.Close()
+		_, err = httpcommon.ReadAll(r)
+		require.NoError(t, err)
+		return r.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/echo-hello"))
+	assert.Equal(t, http.StatusInternalServerError, get("/panic"))
+	assert.Equal(t, http.StatusNotFound, get("/unknown"))
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Uints
+	assert.EqualValues(t, 1, snapshot["/echo-hello.status.2xx"])
+	assert.EqualValues(t, 1, snapshot["/panic.status.5xx"])
+	assert.EqualValues(t, 1, snapshot["other.status.4xx"])
+
+	assert.Equal(t, 1, logs.FilterMessage("GET /echo-hello 200").Len())
+	assert.Equal(t, 1, logs.FilterMessage("GET /panic 500").Len())
+	assert.Equal(t, 1, logs.FilterMessageSnippet("Panic serving").Len())
+}
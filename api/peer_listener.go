@@ -0,0 +1,39 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// allowPeer returns true if the process connected to c runs with one of the
+// allowed user or group IDs. Connections of other peers are logged.
+func allowPeer(log *logp.Logger, c net.Conn, uids, gids []int) bool {
+	cred, err := peerCredentials(c)
+	if err != nil {
+		log.Warnf("Rejected connection on %s, cannot read peer credentials: %v", c.LocalAddr(), err)
+		return false
+	}
+	if !containsInt(uids, cred.UID) && !containsInt(gids, cred.GID) {
+		log.Warnf("Rejected connection on %s from peer pid=%d uid=%d gid=%d", c.LocalAddr(), cred.PID, cred.UID, cred.GID)
+		return false
+	}
+	return true
+}
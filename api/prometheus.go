@@ -0,0 +1,153 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// prometheusContentType is the content type of the Prometheus text format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusVisitor collects the numeric metrics of a registry as
+// Prometheus samples. Strings and string slices are not reported.
+type prometheusVisitor struct {
+	level   []string
+	hint    monitoring.MetricType
+	samples map[string]prometheusSample
+}
+
+type prometheusSample struct {
+	typ   string
+	value string
+}
+
+func newPrometheusVisitor() *prometheusVisitor {
+	return &prometheusVisitor{samples: map[string]prometheusSample{}}
+}
+
+func (vs *prometheusVisitor) OnRegistryStart() {}
+
+func (vs *prometheusVisitor) OnRegistryFinished() {
+	if len(vs.level) > 0 {
+		vs.dropName()
+	}
+}
+
+func (vs *prometheusVisitor) OnKey(name string) {
+	vs.level = append(vs.level, name)
+}
+
+func (vs *prometheusVisitor) dropName() {
+	vs.level = vs.level[:len(vs.level)-1]
+	vs.hint = monitoring.UnknownMetric
+}
+
+func (vs *prometheusVisitor) OnCounter() { vs.hint = monitoring.CounterMetric }
+func (vs *prometheusVisitor) OnGauge()   { vs.hint = monitoring.GaugeMetric }
+
+func (vs *prometheusVisitor) OnString(string)        { vs.dropName() }
+func (vs *prometheusVisitor) OnStringSlice([]string) { vs.dropName() }
+
+func (vs *prometheusVisitor) OnBool(b bool) {
+	if b {
+		vs.add("1")
+	} else {
+		vs.add("0")
+	}
+}
+
+func (vs *prometheusVisitor) OnInt(i int64)   { vs.add(strconv.FormatInt(i, 10)) }
+func (vs *prometheusVisitor) OnUint(u uint64) { vs.add(strconv.FormatUint(u, 10)) }
+
+func (vs *prometheusVisitor) OnFloat(f float64) {
+	switch {
+	case math.IsNaN(f):
+		vs.add("NaN")
+	case math.IsInf(f, 1):
+		vs.add("+Inf")
+	case math.IsInf(f, -1):
+		vs.add("-Inf")
+	default:
+		vs.add(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+}
+
+func (vs *prometheusVisitor) add(value string) {
+	typ := "untyped"
+	switch vs.hint {
+	case monitoring.CounterMetric:
+		typ = "counter"
+	case monitoring.GaugeMetric:
+		typ = "gauge"
+	}
+
+	name := prometheusName(vs.level)
+	vs.dropName()
+	// names only differing in characters not allowed by Prometheus collide,
+	// the first one wins
+	if _, exists := vs.samples[name]; !exists {
+		vs.samples[name] = prometheusSample{typ: typ, value: value}
+	}
+}
+
+// prometheusName joins the metric path with underscores and replaces all
+// characters not allowed in Prometheus metric names.
+func prometheusName(level []string) string {
+	name := []byte(strings.Join(level, "_"))
+	for i, c := range name {
+		valid := c == '_' || c == ':' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// encode writes the samples in the Prometheus text format, sorted by name.
+func (vs *prometheusVisitor) encode() []byte {
+	names := make([]string, 0, len(vs.samples))
+	for name := range vs.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		s := vs.samples[name]
+		buf.WriteString("# TYPE " + name + " " + s.typ + "\n")
+		buf.WriteString(name + " " + s.value + "\n")
+	}
+	return buf.Bytes()
+}
+
+// collectPrometheus returns the numeric metrics of the registry in the
+// Prometheus text format.
+func collectPrometheus(reg *monitoring.Registry, mode monitoring.Mode) []byte {
+	vs := newPrometheusVisitor()
+	reg.Visit(mode, vs)
+	return vs.encode()
+}
@@ -0,0 +1,158 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RoutesPath is the path listing all routes of a Routes registry.
+const RoutesPath = "/_routes"
+
+// Route describes a route registered with Routes.
+type Route struct {
+	// Method is the HTTP method the route is restricted to, or "*" if the
+	// route accepts all methods.
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// Routes is a registry of the routes served by a Server. Routes can be
+// registered concurrently, also while the server is running.
+type Routes struct {
+	mux *http.ServeMux
+
+	mu     sync.RWMutex
+	routes map[Route]struct{}
+}
+
+// NewRoutes creates a registry serving the routes listing only.
+func NewRoutes() *Routes {
+	return newRoutes(http.NewServeMux())
+}
+
+// newRoutes creates a registry on top of an existing mux. Routes registered
+// with the mux directly are served, but not listed.
+func newRoutes(mux *http.ServeMux) *Routes {
+	r := &Routes{mux: mux, routes: map[Route]struct{}{}}
+	// registering on a fresh registry only fails if the mux has the route already
+	_ = r.HandleFunc(http.MethodGet, RoutesPath, r.serveList)
+	return r
+}
+
+// Handle registers the handler for the path. If method is empty, the route
+// accepts all methods, otherwise requests with other methods are answered
+// with 405. Paths follow the http.ServeMux rules, a path ending in a slash
+// matches the whole subtree. Registering a route twice returns an error.
+func (r *Routes) Handle(method, path string, h http.Handler) (err error) {
+	if method != "" && strings.ToUpper(method) != method {
+		return fmt.Errorf("invalid method %q for route %s, methods must be upper case", method, path)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid route %q, paths must start with /", path)
+	}
+
+	pattern := path
+	route := Route{Method: "*", Path: path}
+	if method != "" {
+		pattern = method + " " + path
+		route.Method = method
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.routes[route]; exists {
+		return fmt.Errorf("route %s %s already exists", route.Method, path)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			switch p := p.(type) {
+			case error:
+				err = p
+			case string:
+				err = errors.New(p)
+			default:
+				err = fmt.Errorf("handle attempted to panic with %v", p)
+			}
+		}
+	}()
+	r.mux.Handle(pattern, h)
+	r.routes[route] = struct{}{}
+	return nil
+}
+
+// HandleFunc registers the handler function for the path. See Handle.
+func (r *Routes) HandleFunc(method, path string, h HandlerFunc) error {
+	return r.Handle(method, path, http.HandlerFunc(h))
+}
+
+// List returns all registered routes sorted by path and method.
+func (r *Routes) List() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]Route, 0, len(r.routes))
+	for route := range r.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// ServeHTTP dispatches the request to the handler of the matching route.
+func (r *Routes) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// pattern returns the pattern of the route matching the request, or an
+// empty string if no route matches.
+func (r *Routes) pattern(req *http.Request) string {
+	_, pattern := r.mux.Handler(req)
+	return pattern
+}
+
+func (r *Routes) serveList(w http.ResponseWriter, req *http.Request) {
+	var (
+		data []byte
+		err  error
+	)
+	list := map[string][]Route{"routes": r.List()}
+	if _, ok := req.URL.Query()["pretty"]; ok {
+		data, err = json.MarshalIndent(list, "", "  ")
+	} else {
+		data, err = json.Marshal(list)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(data)
+}
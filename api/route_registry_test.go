@@ -0,0 +1,165 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func respond(body string) HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, body)
+	}
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestRoutesMethods(t *testing.T) {
+	routes := NewRoutes()
+	require.NoError(t, routes.HandleFunc(http.MethodGet, "/config", respond("get")))
+	require.NoError(t, routes.HandleFunc(http.MethodPut, "/config", respond("put")))
+	require.NoError(t, routes.HandleFunc("", "/any", respond("any")))
+
+	assert.Equal(t, "get", serve(routes, http.MethodGet, "/config").Body.String())
+	assert.Equal(t, "put", serve(routes, http.MethodPut, "/config").Body.String())
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(routes, http.MethodDelete, "/config").Code)
+	assert.Equal(t, "any", serve(routes, http.MethodPost, "/any").Body.String())
+	assert.Equal(t, http.StatusNotFound, serve(routes, http.MethodGet, "/unknown").Code)
+}
+
+func TestRoutesErrors(t *testing.T) {
+	routes := NewRoutes()
+	require.NoError(t, routes.HandleFunc(http.MethodGet, "/stats", respond("")))
+
+	assert.ErrorContains(t, routes.HandleFunc(http.MethodGet, "/stats", respond("")), "already exists")
+	assert.ErrorContains(t, routes.HandleFunc("get", "/x", respond("")), "methods must be upper case")
+	assert.ErrorContains(t, routes.HandleFunc("", "x", respond("")), "paths must start with /")
+
+	// conflicts detected by the mux are returned as errors
+	require.NoError(t, routes.HandleFunc("", "/{name}/a", respond("")))
+	assert.Error(t, routes.HandleFunc("", "/b/{name}", respond("")))
+	assert.NotContains(t, routes.List(), Route{Method: "*", Path: "/b/{name}"})
+}
+
+func TestRoutesList(t *testing.T) {
+	routes := NewRoutes()
+	require.NoError(t, routes.HandleFunc(http.MethodPut, "/config", respond("")))
+	require.NoError(t, routes.HandleFunc(http.MethodGet, "/config", respond("")))
+	require.NoError(t, routes.HandleFunc("", "/stats", respond("")))
+
+	expected := []Route{
+		{Method: http.MethodGet, Path: RoutesPath},
+		{Method: http.MethodGet, Path: "/config"},
+		{Method: http.MethodPut, Path: "/config"},
+		{Method: "*", Path: "/stats"},
+	}
+	assert.Equal(t, expected, routes.List())
+
+	rec := serve(routes, http.MethodGet, RoutesPath)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Routes []Route `json:"routes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, expected, resp.Routes)
+}
+
+func TestRoutesConcurrentRegistration(t *testing.T) {
+	s, err := NewWithRoutes(nil, NewRoutes(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": localhostURL,
+	}))
+	require.NoError(t, err)
+	s.Start()
+	defer func() {
+		require.NoError(t, s.Stop())
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/route%d", i)
+			assert.NoError(t, s.Routes().HandleFunc(http.MethodGet, path, respond(path)))
+			_, body, err := doGet(s.Addr().String(), path)
+			assert.NoError(t, err)
+			assert.Equal(t, path, body)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, s.Routes().List(), 11)
+}
+
+func TestDefaultRoutesPerServer(t *testing.T) {
+	ns := func(name string) *monitoring.Namespace {
+		return monitoring.GetNamespace("api_default_routes_" + name)
+	}
+	cfg := config.MustNewConfigFrom(map[string]interface{}{"host": localhostURL})
+
+	a, err := NewWithDefaultRoutes(nil, cfg, ns)
+	require.NoError(t, err)
+	defer a.Stop()
+	b, err := NewWithDefaultRoutes(nil, cfg, ns)
+	require.NoError(t, err)
+	defer b.Stop()
+
+	require.NoError(t, a.Routes().HandleFunc(http.MethodGet, "/extra", respond("extra")))
+
+	assert.Equal(t, "extra", serve(a.Routes(), http.MethodGet, "/extra").Body.String())
+	assert.Equal(t, http.StatusNotFound, serve(b.Routes(), http.MethodGet, "/extra").Code)
+	assert.Equal(t, http.StatusOK, serve(b.Routes(), http.MethodGet, "/stats").Code)
+	assert.NotContains(t, b.Routes().List(), Route{Method: "*", Path: "/debug/registry"})
+
+	b.AttachRegistryStats(map[string]*monitoring.Namespace{"stats": ns("stats")})
+	assert.Contains(t, b.Routes().List(), Route{Method: "*", Path: "/debug/registry"})
+	assert.NotContains(t, a.Routes().List(), Route{Method: "*", Path: "/debug/registry"})
+	assert.Equal(t, http.StatusOK, serve(b.Routes(), http.MethodGet, "/debug/registry").Code)
+}
+
+func TestAddHandlerFunc(t *testing.T) {
+	ns := func(name string) *monitoring.Namespace {
+		return monitoring.GetNamespace("api_add_handler_func_" + name)
+	}
+	cfg := config.MustNewConfigFrom(map[string]interface{}{"host": localhostURL})
+
+	require.NoError(t, AddHandlerFunc("/add_handler_func_test", respond("legacy")))
+	assert.Error(t, AddHandlerFunc("/add_handler_func_test", respond("legacy")))
+
+	s, err := NewWithDefaultRoutes(nil, cfg, ns)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	assert.Equal(t, "legacy", serve(s.Routes(), http.MethodGet, "/add_handler_func_test").Body.String())
+	assert.Contains(t, s.Routes().List(), Route{Method: "*", Path: "/add_handler_func_test"})
+}
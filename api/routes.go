@@ -18,12 +18,21 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" //nolint:gosec // we want to expose pprof endpoint
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/diagnostics"
+	"github.com/elastic/elastic-agent-libs/health"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
@@ -33,30 +42,65 @@ import (
 type HandlerFunc func(http.ResponseWriter, *http.Request)
 type lookupFunc func(string) *monitoring.Namespace
 
-var handlerFuncMap = make(map[string]HandlerFunc)
+// defaultRoutes holds the routes added with the deprecated AddHandlerFunc.
+// They are served by every server created with NewWithDefaultRoutes.
+var defaultRoutes = NewRoutes()
 
-// NewWithDefaultRoutes creates a new server with default API routes.
-func NewWithDefaultRoutes(log *logp.Logger, c *config.C, ns lookupFunc) (*Server, error) {
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/", MakeRootAPIHandler(MakeAPIHandler(ns("info"))))
-	mux.HandleFunc("/state", MakeAPIHandler(ns("state")))
-	mux.HandleFunc("/stats", MakeAPIHandler(ns("stats")))
-	mux.HandleFunc("/dataset", MakeAPIHandler(ns("dataset")))
-
-	for api, h := range handlerFuncMap {
-		mux.HandleFunc(api, h)
+// NewWithDefaultRoutes creates a new server with default API routes and the
+// routes added with AddHandlerFunc. Additional routes are added with the
+// route registry returned by Server.Routes.
+func NewWithDefaultRoutes(log *logp.Logger, c *config.C, ns lookupFunc, opts ...ServerOption) (*Server, error) {
+	routes := NewRoutes()
+	for path, h := range map[string]HandlerFunc{
+		"/":        MakeRootAPIHandler(MakeAPIHandler(ns("info"))),
+		"/state":   MakeAPIHandler(ns("state")),
+		"/stats":   MakeAPIHandler(ns("stats")),
+		"/dataset": MakeAPIHandler(ns("dataset")),
+	} {
+		if err := routes.HandleFunc("", path, h); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range defaultRoutes.List() {
+		if route.Path == RoutesPath {
+			continue
+		}
+		if err := routes.Handle("", route.Path, defaultRoutes); err != nil {
+			return nil, err
+		}
 	}
-	return New(log, mux, c)
+	return NewWithRoutes(log, routes, c, opts...)
 }
 
 // AttachPprof adds /debug/pprof endpoints to the server
 func (s *Server) AttachPprof() {
 	s.log.Info("Attaching pprof endpoints")
-	s.mux.HandleFunc("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
+	s.AddRoute("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
 		http.DefaultServeMux.ServeHTTP(w, r)
 	})
+}
+
+// AttachDiagnostics adds the /debug/diagnostics endpoint streaming a
+// diagnostics bundle to the server. See MakeDiagnosticsHandler for the
+// content of the bundle.
+func (s *Server) AttachDiagnostics(reg *diagnostics.Registry, cfg *config.C, namespaces map[string]*monitoring.Namespace) {
+	s.log.Info("Attaching diagnostics endpoint")
+	s.AddRoute("/debug/diagnostics", MakeDiagnosticsHandler(reg, cfg, namespaces))
+}
+
+// AttachRegistryStats adds the /debug/registry endpoint reporting the size of
+// the registries of the given namespaces to the server. See
+// MakeRegistryStatsHandler for the format.
+func (s *Server) AttachRegistryStats(namespaces map[string]*monitoring.Namespace) {
+	s.log.Info("Attaching registry stats endpoint")
+	s.AddRoute("/debug/registry", MakeRegistryStatsHandler(namespaces))
+}
 
+// AttachHealth adds the /health endpoint reporting the checks of the given
+// health registry to the server.
+func (s *Server) AttachHealth(reg *health.Registry) {
+	s.log.Info("Attaching health endpoint")
+	s.AddRoute("/health", MakeHealthHandler(reg))
 }
 
 // MakeRootAPIHandler creates a HandlerFunc for the root / path
@@ -70,21 +114,260 @@ func MakeRootAPIHandler(handler HandlerFunc) HandlerFunc {
 	}
 }
 
-// MakeAPIHandler creates an API handler for the given namespace
+// MakeAPIHandler creates an API handler for the given namespace.
+//
+// The handler supports the query parameters:
+//   - path: report the subtree or metric at the dotted path only.
+//   - format: struct (default) for nested JSON, flat for JSON with dotted
+//     keys, or prometheus for the Prometheus text format.
+//   - mode: full (default) or reported, selecting the metrics by their
+//     monitoring.Mode.
+//   - pretty: indent JSON responses.
+//
+// Responses carry an ETag. Requests with a matching If-None-Match header are
+// answered with 304 and no body.
 func MakeAPIHandler(ns *monitoring.Namespace) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		mode, err := parseMode(query.Get("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := query.Get("format")
+		switch format {
+		case "", "struct", "flat", "prometheus":
+		default:
+			http.Error(w, fmt.Sprintf("invalid format %q", format), http.StatusBadRequest)
+			return
+		}
+
+		reg := ns.GetRegistry()
+		if path := query.Get("path"); path != "" {
+			reg = subRegistry(reg, path)
+			if reg == nil {
+				http.Error(w, fmt.Sprintf("unknown path %q", path), http.StatusNotFound)
+				return
+			}
+		}
+
+		var body []byte
+		contentType := "application/json; charset=utf-8"
+		switch format {
+		case "prometheus":
+			body = collectPrometheus(reg, mode)
+			contentType = prometheusContentType
+		case "flat":
+			data := mapstr.M(monitoring.CollectStructSnapshot(reg, mode, false)).Flatten()
+			body = encodeJSON(data, r.URL)
+		default:
+			data := monitoring.CollectStructSnapshot(reg, mode, false)
+			body = encodeJSON(data, r.URL)
+		}
+
+		writeWithETag(w, r, contentType, body)
+	}
+}
+
+// parseMode parses the mode query parameter, defaulting to monitoring.Full.
+func parseMode(m string) (monitoring.Mode, error) {
+	switch m {
+	case "", "full":
+		return monitoring.Full, nil
+	case "reported":
+		return monitoring.Reported, nil
+	}
+	return monitoring.Full, fmt.Errorf("invalid mode %q", m)
+}
+
+// subRegistry returns the registry at path. If path refers to a metric, a
+// registry holding the metric only, with the mode it has been registered
+// with, is returned. If nothing is found at path, nil is returned.
+func subRegistry(reg *monitoring.Registry, path string) *monitoring.Registry {
+	if sub := reg.GetRegistry(path); sub != nil {
+		return sub
+	}
+	v, mode := reg.GetWithMode(path)
+	if v == nil {
+		return nil
+	}
+
+	name := path
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		name = path[i+1:]
+	}
+	tmp := monitoring.NewRegistry()
+	tmp.Add(name, v, mode)
+	return tmp
+}
+
+func encodeJSON(data mapstr.M, u *url.URL) []byte {
+	if _, ok := u.Query()["pretty"]; ok {
+		return []byte(data.StringToPrint())
+	}
+	return []byte(data.String())
+}
+
+// writeWithETag writes the body with an ETag derived from its content. If the
+// request's If-None-Match header matches, 304 is returned without body.
+func writeWithETag(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(body)
+}
+
+// etagMatches checks if the If-None-Match header value matches the etag.
+// Weak comparison is used as defined for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeRegistryStatsHandler creates a HandlerFunc reporting the number of
+// variables and the approximate memory use of the registries of the given
+// namespaces, for diagnosing leaking metrics.
+// The optional depth query parameter limits the nesting of the reported subtrees.
+func MakeRegistryStatsHandler(namespaces map[string]*monitoring.Namespace) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depth := -1
+		if s := r.URL.Query().Get("depth"); s != "" {
+			d, err := strconv.Atoi(s)
+			if err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("invalid depth %q", s), http.StatusBadRequest)
+				return
+			}
+			depth = d
+		}
+
+		data := mapstr.M{}
+		for name, ns := range namespaces {
+			data[name] = trimRegistryStats(ns.GetRegistry().Stats(), depth)
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		prettyPrint(w, data, r.URL)
+	}
+}
+
+// MakeHealthHandler creates a HandlerFunc reporting the aggregated status and
+// the result of each check of the health registry. The probe query parameter
+// selects the checks, "readiness" (default) or "liveness". The response status
+// is 503 if the aggregated status is failed, 200 otherwise.
+func MakeHealthHandler(reg *health.Registry) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		probe := health.ReadinessProbe
+		switch p := r.URL.Query().Get("probe"); p {
+		case "", "readiness":
+		case "liveness":
+			probe = health.LivenessProbe
+		default:
+			http.Error(w, fmt.Sprintf("invalid probe %q", p), http.StatusBadRequest)
+			return
+		}
+
+		report := reg.Check(r.Context(), probe)
 
-		data := monitoring.CollectStructSnapshot(
-			ns.GetRegistry(),
-			monitoring.Full,
-			false,
+		var (
+			body []byte
+			err  error
 		)
+		if _, ok := r.URL.Query()["pretty"]; ok {
+			body, err = json.MarshalIndent(report, "", "  ")
+		} else {
+			body, err = json.Marshal(report)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		prettyPrint(w, data, r.URL)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status == health.Failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write(body)
+	}
+}
+
+// MakeDiagnosticsHandler creates a HandlerFunc streaming a zip bundle with
+// goroutine and heap profiles, a snapshot of the metrics of each namespace,
+// the redacted configuration and the files of all hooks registered with the
+// diagnostics registry. The hooks' files are stored in the hooks directory of
+// the bundle. The configuration and the registry are optional.
+func MakeDiagnosticsHandler(reg *diagnostics.Registry, cfg *config.C, namespaces map[string]*monitoring.Namespace) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks := []diagnostics.Hook{
+			diagnostics.GoroutineProfile(),
+			diagnostics.HeapProfile(),
+		}
+
+		names := make([]string, 0, len(namespaces))
+		for name := range namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			hooks = append(hooks, diagnostics.MetricsSnapshot(name, namespaces[name].GetRegistry()))
+		}
+
+		if cfg != nil {
+			hooks = append(hooks, diagnostics.Config(cfg))
+		}
+		if reg != nil {
+			for _, h := range reg.Hooks() {
+				h.Filename = "hooks/" + h.Filename
+				hooks = append(hooks, h)
+			}
+		}
+
+		filename := fmt.Sprintf("diagnostics-%s.zip", time.Now().UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("Cache-Control", "no-store")
+		if err := diagnostics.WriteBundle(r.Context(), w, hooks); err != nil {
+			// The response may already be partially written, abort it such that
+			// the client does not receive a truncated bundle as complete.
+			panic(http.ErrAbortHandler)
+		}
 	}
 }
 
+// trimRegistryStats removes children nested deeper than depth. A negative
+// depth keeps all children.
+func trimRegistryStats(stats monitoring.RegistryStats, depth int) monitoring.RegistryStats {
+	if depth < 0 {
+		return stats
+	}
+	if depth == 0 {
+		stats.Children = nil
+		return stats
+	}
+
+	children := make(map[string]monitoring.RegistryStats, len(stats.Children))
+	for name, child := range stats.Children {
+		children[name] = trimRegistryStats(child, depth-1)
+	}
+	stats.Children = children
+	return stats
+}
+
 func prettyPrint(w http.ResponseWriter, data mapstr.M, u *url.URL) {
 	query := u.Query()
 	if _, ok := query["pretty"]; ok {
@@ -94,13 +377,11 @@ func prettyPrint(w http.ResponseWriter, data mapstr.M, u *url.URL) {
 	}
 }
 
-// AddHandlerFunc adds a handler to the global handler map.
-// Callbacks should be added before calling NewWithDefaultRoutes()
-// This is NOT threadsafe
+// AddHandlerFunc adds a handler served by all servers created afterwards with
+// NewWithDefaultRoutes.
+//
+// Deprecated: add routes to a single server with Server.Routes or
+// NewWithRoutes instead.
 func AddHandlerFunc(api string, h HandlerFunc) error {
-	if _, exist := handlerFuncMap[api]; exist {
-		return fmt.Errorf("%s already exist", api)
-	}
-	handlerFuncMap[api] = h
-	return nil
+	return defaultRoutes.HandleFunc("", api, h)
 }
@@ -0,0 +1,285 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/diagnostics"
+	"github.com/elastic/elastic-agent-libs/health"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestRegistryStatsHandler(t *testing.T) {
+	ns := monitoring.GetNamespace("api_registry_stats_test")
+	reg := ns.GetRegistry()
+	monitoring.NewInt(reg, "inputs.a.events")
+	monitoring.NewInt(reg, "inputs.b.events")
+	monitoring.NewString(reg, "name")
+
+	handler := MakeRegistryStatsHandler(map[string]*monitoring.Namespace{"stats": ns})
+
+	t.Run("full", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/debug/registry", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp map[string]monitoring.RegistryStats
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		stats := resp["stats"]
+		assert.Equal(t, 3, stats.Vars)
+		assert.Equal(t, 3, stats.Registries)
+		assert.Greater(t, stats.MemoryBytes, int64(0))
+		assert.Equal(t, 2, stats.Children["inputs"].Vars)
+		assert.Equal(t, 1, stats.Children["inputs"].Children["a"].Vars)
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/debug/registry?depth=1", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp map[string]monitoring.RegistryStats
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, 2, resp["stats"].Children["inputs"].Vars)
+		assert.Empty(t, resp["stats"].Children["inputs"].Children)
+	})
+
+	t.Run("invalid depth", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/debug/registry?depth=x", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestHealthHandler(t *testing.T) {
+	reg := health.NewRegistry()
+	require.NoError(t, reg.Register("process", func(context.Context) error { return nil }, health.Liveness))
+	output, err := reg.NewReporter("output")
+	require.NoError(t, err)
+	output.Failed("connection refused")
+
+	handler := MakeHealthHandler(reg)
+
+	t.Run("readiness", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		var report health.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, health.Failed, report.Status)
+		assert.Equal(t, health.Healthy, report.Checks["process"].Status)
+		assert.Equal(t, "connection refused", report.Checks["output"].Message)
+	})
+
+	t.Run("liveness", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/health?probe=liveness", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var report health.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, health.Healthy, report.Status)
+		assert.NotContains(t, report.Checks, "output")
+	})
+
+	t.Run("invalid probe", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/health?probe=startup", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	ns := monitoring.GetNamespace("api_diagnostics_test")
+	monitoring.NewInt(ns.GetRegistry(), "events").Set(7)
+
+	hooks := diagnostics.NewRegistry()
+	require.NoError(t, hooks.Register("output", "", "", "", func() []byte { return []byte("connected") }))
+	cfg := config.MustNewConfigFrom(map[string]interface{}{"password": "secret", "name": "test"})
+
+	handler := MakeDiagnosticsHandler(hooks, cfg, map[string]*monitoring.Namespace{"stats": ns})
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/debug/diagnostics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		var b bytes.Buffer
+		_, err = b.ReadFrom(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[f.Name] = b.String()
+	}
+
+	assert.Contains(t, files, "goroutine.txt")
+	assert.Contains(t, files, "heap.pprof")
+	assert.Contains(t, files, diagnostics.ManifestFilename)
+	assert.JSONEq(t, `{"events": 7}`, files["metrics/stats.json"])
+	assert.Contains(t, files["config.json"], `"name": "test"`)
+	assert.NotContains(t, files["config.json"], "secret")
+	assert.Equal(t, "connected", files["hooks/output.txt"])
+}
+
+func TestAPIHandlerPathMode(t *testing.T) {
+	ns := monitoring.GetNamespace("api_handler_path_mode_test")
+	reg := ns.GetRegistry()
+	monitoring.NewInt(reg, "output.acked", monitoring.Report).Set(2)
+	monitoring.NewInt(reg, "output.failed").Set(1)
+	monitoring.NewFloat(reg, "load", monitoring.Report).Set(0.5)
+
+	handler := MakeAPIHandler(ns)
+	for url, expected := range map[string]string{
+		"/stats?mode=reported":                    `{"load": 0.5, "output": {"acked": 2}}`,
+		"/stats?path=output&mode=reported":        `{"acked": 2}`,
+		"/stats?path=output.acked&mode=reported":  `{"acked": 2}`,
+		"/stats?path=load&mode=reported":          `{"load": 0.5}`,
+		"/stats?path=output.failed&mode=reported": `null`,
+		"/stats?path=output.failed":               `{"failed": 1}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, rec.Code, url)
+		assert.JSONEq(t, expected, rec.Body.String(), url)
+	}
+}
+
+func TestAPIHandler(t *testing.T) {
+	ns := monitoring.GetNamespace("api_handler_test")
+	reg := ns.GetRegistry()
+	monitoring.NewUint(reg, "pipeline.events.total", monitoring.Counter).Set(math.MaxUint64)
+	monitoring.NewInt(reg, "pipeline.events.active", monitoring.Gauge).Set(3)
+	monitoring.NewString(reg, "pipeline.name").Set("main")
+	monitoring.NewFloat(reg, "load", monitoring.Report).Set(0.5)
+
+	handler := MakeAPIHandler(ns)
+	get := func(t *testing.T, url string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		rec := get(t, "/stats", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"load": 0.5,
+			"pipeline": {"name": "main", "events": {"total": 18446744073709551615, "active": 3}}
+		}`, rec.Body.String())
+	})
+
+	t.Run("path", func(t *testing.T) {
+		rec := get(t, "/stats?path=pipeline.events", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"total": 18446744073709551615, "active": 3}`, rec.Body.String())
+
+		rec = get(t, "/stats?path=pipeline.name", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"name": "main"}`, rec.Body.String())
+
+		rec = get(t, "/stats?path=pipeline.unknown", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		rec := get(t, "/stats?format=flat&path=pipeline", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"name": "main",
+			"events.total": 18446744073709551615,
+			"events.active": 3
+		}`, rec.Body.String())
+	})
+
+	t.Run("mode", func(t *testing.T) {
+		rec := get(t, "/stats?mode=reported", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"load": 0.5}`, rec.Body.String())
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		rec := get(t, "/stats?format=prometheus", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, prometheusContentType, rec.Header().Get("Content-Type"))
+		assert.Equal(t, `# TYPE load untyped
+load 0.5
+# TYPE pipeline_events_active gauge
+pipeline_events_active 3
+# TYPE pipeline_events_total counter
+pipeline_events_total 18446744073709551615
+`, rec.Body.String())
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get(t, "/stats?format=xml", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, get(t, "/stats?mode=some", nil).Code)
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		rec := get(t, "/stats", nil)
+		etag := rec.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		rec = get(t, "/stats", http.Header{"If-None-Match": {`"other", ` + etag}})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+
+		rec = get(t, "/stats", http.Header{"If-None-Match": {"W/" + etag}})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+
+		// the etag depends on the format
+		rec = get(t, "/stats?format=flat", http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// and on the content
+		reg.Get("pipeline.events.active").(*monitoring.Int).Inc()
+		rec = get(t, "/stats", http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	})
+}
+
+func TestPrometheusName(t *testing.T) {
+	assert.Equal(t, "beat_cpu_total_ms", prometheusName([]string{"beat", "cpu", "total.ms"}))
+	assert.Equal(t, "_xx_status", prometheusName([]string{"5xx-status"}))
+	assert.Equal(t, "a:b_c", prometheusName([]string{"a:b", "c"}))
+}
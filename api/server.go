@@ -19,34 +19,110 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/keystore"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
 
 const (
 	unixNetwork = "unix"
 	tcpNetwork  = "tcp"
+
+	// fdNetwork selects a socket passed by the service manager.
+	fdNetwork = "fd"
 )
 
 // Server takes cares of correctly starting the HTTP component of the API
-// and will answers all the routes defined in the received ServeMux.
+// and will answers all the routes defined in its route registry.
 type Server struct {
 	log    *logp.Logger
-	mux    *http.ServeMux
-	srv    *http.Server
-	l      net.Listener
-	config Config
+	routes *Routes
+	opts   serverOptions
+
+	// metrics is created once, such that the routes keep their metrics when
+	// the configuration is reloaded.
+	metrics Middleware
+
+	// handler and tlsConfig are swapped on reload, without interrupting
+	// requests in flight.
+	handler   atomic.Pointer[handlerRef]
+	tlsConfig atomic.Pointer[tls.Config]
+
+	mu      sync.Mutex
+	srv     *http.Server
+	l       *serverListener
+	config  Config
+	started bool
+	stopped bool
+
+	// draining tracks the servers replaced on reload, that are still
+	// completing their requests.
+	draining sync.WaitGroup
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// errs receives the error of the current server if serving fails.
+	errs chan error
+}
+
+type handlerRef struct {
+	http.Handler
+}
+
+// ServerOption configures optional dependencies of the Server.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	keystore    keystore.Keystore
+	metrics     *monitoring.Registry
+	middlewares []Middleware
+}
+
+// WithKeystore sets the keystore the bearer tokens configured with
+// auth.bearer.keystore_keys are loaded from.
+func WithKeystore(ks keystore.Keystore) ServerOption {
+	return func(o *serverOptions) {
+		o.keystore = ks
+	}
 }
 
-// New creates a new API Server.
-func New(log *logp.Logger, mux *http.ServeMux, c *config.C) (*Server, error) {
+// WithMetrics enables request metrics per route, reported in reg.
+func WithMetrics(reg *monitoring.Registry) ServerOption {
+	return func(o *serverOptions) {
+		o.metrics = reg
+	}
+}
+
+// WithMiddleware adds middlewares to the server. They wrap the route
+// handlers after the built-in middlewares, in the order given.
+func WithMiddleware(middlewares ...Middleware) ServerOption {
+	return func(o *serverOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// New creates a new API Server serving the routes of mux. Routes added
+// with the Server are registered with mux as well.
+func New(log *logp.Logger, mux *http.ServeMux, c *config.C, opts ...ServerOption) (*Server, error) {
+	return NewWithRoutes(log, newRoutes(mux), c, opts...)
+}
+
+// NewWithRoutes creates a new API Server serving the routes of the registry.
+func NewWithRoutes(log *logp.Logger, routes *Routes, c *config.C, opts ...ServerOption) (*Server, error) {
 	if log == nil {
 		log = logp.NewLogger("")
 	}
@@ -56,77 +132,305 @@ func New(log *logp.Logger, mux *http.ServeMux, c *config.C) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return new(log, mux, cfg)
+	return new(log, routes, cfg, opts)
 }
 
 // NewFromConfig creates a new API server from the given Config object.
-func NewFromConfig(log *logp.Logger, mux *http.ServeMux, cfg Config) (*Server, error) {
-	return new(log, mux, cfg)
+func NewFromConfig(log *logp.Logger, mux *http.ServeMux, cfg Config, opts ...ServerOption) (*Server, error) {
+	return new(log, newRoutes(mux), cfg, opts)
 }
 
 // new creates the server from a config struct
-func new(log *logp.Logger, mux *http.ServeMux, cfg Config) (*Server, error) {
-	srv := &http.Server{ReadHeaderTimeout: cfg.Timeout}
-	l, err := makeListener(cfg)
+func new(log *logp.Logger, routes *Routes, cfg Config, opts []ServerOption) (*Server, error) {
+	s := &Server{
+		log:    log.Named("api"),
+		routes: routes,
+		ready:  make(chan struct{}),
+		errs:   make(chan error, 1),
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	if s.opts.metrics != nil {
+		s.metrics = RequestMetrics(s.opts.metrics, routes.pattern)
+	}
+
+	handler, tlsConfig, err := s.build(cfg)
 	if err != nil {
 		return nil, err
 	}
+	s.handler.Store(&handlerRef{handler})
+	s.tlsConfig.Store(tlsConfig)
+
+	l, err := s.listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.srv = s.newHTTPServer(cfg)
+	s.l = l
+	s.config = cfg
+	return s, nil
+}
+
+// build creates the handler serving all routes and the TLS configuration
+// for cfg.
+func (s *Server) build(cfg Config) (http.Handler, *tls.Config, error) {
+	var handler http.Handler = s.routes
+	if cfg.Auth != nil {
+		auth, err := newAuthHandler(s.log, cfg.Auth, s.opts.keystore, s.routes)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler = auth
+	}
+	handler = Chain(handler, s.middlewares(cfg)...)
+
+	tlsConfig, err := makeTLSConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return handler, tlsConfig, nil
+}
+
+// listen creates the listener for cfg. TLS connections use the TLS
+// configuration current at the time of the handshake.
+func (s *Server) listen(cfg Config) (*serverListener, error) {
+	l, err := makeListener(s.log, cfg)
+	if err != nil {
+		return nil, err
+	}
+	sl := &serverListener{
+		Listener: l,
+		log:      s.log,
+		tlsConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsConfig.Load(), nil
+			},
+		},
+	}
+	if err := sl.configure(cfg); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return sl, nil
+}
+
+// serverListener applies the TLS and peer settings of the configuration to
+// the connections accepted by the bound listener. The settings can be
+// changed on reload, without binding the address again.
+type serverListener struct {
+	net.Listener
+	log       *logp.Logger
+	tlsConfig *tls.Config
+	settings  atomic.Pointer[listenerSettings]
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type listenerSettings struct {
+	tls        bool
+	uids, gids []int
+}
+
+// configure applies the TLS and peer settings of cfg to new connections.
+func (l *serverListener) configure(cfg Config) error {
+	if cfg.UnixSocket.restrictsPeers() {
+		if !peerCredSupported {
+			return errors.New("restricting unix socket peers is not supported on this platform")
+		}
+		if l.Addr().Network() != unixNetwork {
+			return fmt.Errorf("allowed_uids and allowed_gids require a unix socket, got host %s", cfg.Host)
+		}
+	}
+	l.settings.Store(&listenerSettings{
+		tls:  cfg.TLS.IsEnabled(),
+		uids: cfg.UnixSocket.AllowedUIDs,
+		gids: cfg.UnixSocket.AllowedGIDs,
+	})
+	return nil
+}
+
+// Accept waits for the next connection of an allowed peer. Connections of
+// other peers are closed.
+func (l *serverListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		settings := l.settings.Load()
+		if (len(settings.uids) > 0 || len(settings.gids) > 0) && !allowPeer(l.log, c, settings.uids, settings.gids) {
+			c.Close()
+			continue
+		}
+		if settings.tls {
+			return tls.Server(c, l.tlsConfig), nil
+		}
+		return c, nil
+	}
+}
+
+// Close closes the bound listener. It is safe to call Close more than once,
+// as the listener is closed on reload before the server using it is drained.
+func (l *serverListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
 
-	return &Server{mux: mux, srv: srv, l: l, config: cfg, log: log.Named("api")}, nil
+func (s *Server) newHTTPServer(cfg Config) *http.Server {
+	closing := make(chan struct{})
+	srv := &http.Server{
+		ReadHeaderTimeout: cfg.Timeout,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return withServerClosing(connContext(ctx, c), closing)
+		},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.handler.Load().ServeHTTP(w, r)
+		}),
+	}
+	var once sync.Once
+	srv.RegisterOnShutdown(func() {
+		once.Do(func() { close(closing) })
+	})
+	return srv
 }
 
-// AddRoute adds a route to the server mux
+// Routes returns the route registry of the server. Routes can be added
+// while the server is running.
+func (s *Server) Routes() *Routes {
+	return s.routes
+}
+
+// AddRoute adds a route accepting all methods to the server.
 func (s *Server) AddRoute(path string, handler HandlerFunc) {
-	s.mux.HandleFunc(path, handler)
+	if err := s.routes.HandleFunc("", path, handler); err != nil {
+		s.log.Errorf("Failed to add route %s: %v", path, err)
+	}
 }
 
 // Start starts the HTTP server and accepting new connection.
 func (s *Server) Start() {
 	s.log.Info("Starting stats endpoint")
-	go func(l net.Listener) {
-		s.log.Infof("Metrics endpoint listening on: %s (configured: %s)", l.Addr().String(), s.config.Host)
-		s.srv.Handler = s.mux
-		err := s.srv.Serve(l)
-		s.log.Infof("Stats endpoint (%s) finished: %v", l.Addr().String(), err)
-	}(s.l)
+	if err := s.start(); err != nil {
+		s.log.Errorf("Failed to start stats endpoint: %v", err)
+	}
 }
 
 // Stop stops the API server and free any resource associated with the process like unix sockets.
 func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
 	return s.l.Close()
 }
 
-// Shutdown gracefully drains the API server of connections by using the go [net/http.Server.Shutdown] function
+// Shutdown gracefully drains the API server of connections by using the go [net/http.Server.Shutdown] function.
+// Servers replaced by a reload and still draining are waited for as well.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.srv.Shutdown(ctx)
+	s.mu.Lock()
+	s.stopped = true
+	srv, l, started := s.srv, s.l, s.started
+	s.mu.Unlock()
+
+	if !started {
+		// the listener is only closed by the http.Server if it serves it
+		_ = l.Close()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.draining.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Addr returns the network address of the server
 // This is useful for tests, where we usually pass the port as `0` to get allocated a random free port
 func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.l.Addr()
 }
 
 // AttachHandler will attach a handler at the specified route and return an error instead of panicing.
-func (s *Server) AttachHandler(route string, h http.Handler) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			switch r := r.(type) {
-			case error:
-				err = r
-			case string:
-				err = errors.New(r)
-			default:
-				err = fmt.Errorf("handle attempted to panic with %v", r)
-			}
-		}
-	}()
+func (s *Server) AttachHandler(route string, h http.Handler) error {
 	s.log.Infof("Attempting to attach %q to server.", route)
-	s.mux.Handle(route, h)
-	return //nolint:nakedret // returning from recover
+	return s.routes.Handle("", route, h)
+}
+
+// middlewares returns the middlewares wrapping all routes. Panics are
+// recovered inside the access log and metrics, such that they report the
+// failed requests.
+func (s *Server) middlewares(cfg Config) []Middleware {
+	var m []Middleware
+	if cfg.AccessLog {
+		m = append(m, AccessLog(s.log))
+	}
+	if s.metrics != nil {
+		m = append(m, s.metrics)
+	}
+	m = append(m, Recover(s.log))
+	if cfg.RateLimit.IsEnabled() {
+		m = append(m, RateLimit(cfg.RateLimit))
+	}
+	return append(m, s.opts.middlewares...)
+}
+
+// makeTLSConfig returns the TLS configuration of the server, or nil if TLS is
+// not enabled. The https scheme requires TLS to be configured.
+func makeTLSConfig(cfg Config) (*tls.Config, error) {
+	if !cfg.TLS.IsEnabled() {
+		if isHTTPS(cfg.Host) {
+			return nil, fmt.Errorf("host %s uses the https scheme, but ssl is not configured", cfg.Host)
+		}
+		return nil, nil
+	}
+
+	tlsCfg, err := tlscommon.LoadTLSServerConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssl configuration: %w", err)
+	}
+	return tlsCfg.BuildServerConfig(hostname(cfg.Host)), nil
+}
+
+func isHTTPS(host string) bool {
+	url, err := url.Parse(host)
+	return err == nil && url.Scheme == "https"
+}
+
+// hostname returns the host name of the configured host, without scheme and port.
+func hostname(host string) string {
+	url, err := url.Parse(host)
+	if err != nil || len(url.Host) == 0 {
+		return host
+	}
+	return url.Hostname()
 }
 
 func parse(host string, port int) (string, string, error) {
+	// The name of abstract unix sockets would be parsed as user info.
+	if name, ok := strings.CutPrefix(host, "unix://@"); ok {
+		if name == "" {
+			return "", "", fmt.Errorf("missing abstract unix socket name in host string %s", host)
+		}
+		return unixNetwork, "@" + name, nil
+	}
+
 	url, err := url.Parse(host)
 	if err != nil {
 		return "", "", err
@@ -139,10 +443,12 @@ func parse(host string, port int) (string, string, error) {
 	}
 
 	switch url.Scheme {
-	case "http":
+	case "http", "https":
 		return tcpNetwork, url.Host, nil
 	case "unix":
 		return url.Scheme, url.Path, nil
+	case "fd":
+		return fdNetwork, url.Host, nil
 	default:
 		return "", "", fmt.Errorf("unknown scheme %s for host string %s", url.Scheme, host)
 	}
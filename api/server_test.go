@@ -24,12 +24,18 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 )
 
@@ -235,3 +241,110 @@ type testHandler struct{}
 func (t *testHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "test!")
 }
+
+func TestUnixSocketOptions(t *testing.T) {
+	if isWindows() {
+		t.Skip("Unix Sockets don't work under windows")
+		return
+	}
+
+	t.Run("mode and owner", func(t *testing.T) {
+		sockFile := t.TempDir() + "/test.sock"
+		cfg := config.MustNewConfigFrom(map[string]interface{}{
+			"host":              "unix://" + sockFile,
+			"unix_socket.mode":  0o600,
+			"unix_socket.user":  strconv.Itoa(os.Getuid()),
+			"unix_socket.group": strconv.Itoa(os.Getgid()),
+		})
+
+		s, err := New(nil, simpleMux(), cfg)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, s.Stop())
+		}()
+
+		fi, err := os.Stat(sockFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		cfg := config.MustNewConfigFrom(map[string]interface{}{
+			"host":             "unix://" + t.TempDir() + "/test.sock",
+			"unix_socket.mode": 0o1777,
+		})
+		_, err := New(nil, simpleMux(), cfg)
+		assert.ErrorContains(t, err, "invalid unix socket mode")
+	})
+
+	t.Run("peer restrictions require unix socket", func(t *testing.T) {
+		cfg := config.MustNewConfigFrom(map[string]interface{}{
+			"host":                     localhostURL,
+			"unix_socket.allowed_uids": []int{0},
+		})
+		_, err := New(nil, simpleMux(), cfg)
+		assert.Error(t, err)
+	})
+}
+
+func TestUnixSocketPeerRestrictions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+
+	t.Run("allowed peer", func(t *testing.T) {
+		sockFile := t.TempDir() + "/test.sock"
+		cfg := config.MustNewConfigFrom(map[string]interface{}{
+			"host":                     "unix://" + sockFile,
+			"unix_socket.allowed_uids": []int{os.Getuid()},
+		})
+
+		s, err := New(nil, simpleMux(), cfg)
+		require.NoError(t, err)
+		go s.Start()
+		defer func() {
+			require.NoError(t, s.Stop())
+		}()
+
+		assert.Equal(t, "ehlo!", getResponse(t, sockFile, "http://unix/echo-hello"))
+	})
+
+	t.Run("rejected peer", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		log := logp.NewLogger("", zap.WrapCore(func(zapcore.Core) zapcore.Core { return core }))
+
+		sockFile := t.TempDir() + "/test.sock"
+		cfg := config.MustNewConfigFrom(map[string]interface{}{
+			"host":                     "unix://" + sockFile,
+			"unix_socket.allowed_uids": []int{os.Getuid() + 1},
+			"unix_socket.allowed_gids": []int{os.Getgid() + 1},
+		})
+
+		s, err := New(log, simpleMux(), cfg)
+		require.NoError(t, err)
+		go s.Start()
+		defer func() {
+			require.NoError(t, s.Stop())
+		}()
+
+		client := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial("unix", sockFile)
+				},
+			},
+		}
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://unix/echo-hello", nil)
+		require.NoError(t, err)
+		r, err := client.Do(req)
+		if err == nil {
+			r.Body.Close()
+		}
+		require.Error(t, err)
+
+		require.Eventually(t, func() bool {
+			return logs.FilterMessageSnippet("Rejected connection").Len() > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, logs.All()[0].Message, fmt.Sprintf("uid=%d", os.Getuid()))
+	})
+}
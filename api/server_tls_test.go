@@ -0,0 +1,208 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/testing/certutil"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+type testCerts struct {
+	caPool     *x509.CertPool
+	caFile     string
+	certFile   string
+	keyFile    string
+	clientCert tls.Certificate
+}
+
+func newTestCerts(t *testing.T) testCerts {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, caCert, caPair, err := certutil.NewRootCA()
+	require.NoError(t, err)
+	_, serverPair, err := certutil.GenerateChildCert("localhost", []net.IP{net.IPv4(127, 0, 0, 1)}, caKey, caCert)
+	require.NoError(t, err)
+	clientCert, _, err := certutil.GenerateChildCert("client", nil, caKey, caCert, certutil.WithClientCert(true))
+	require.NoError(t, err)
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		return path
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return testCerts{
+		caPool:     pool,
+		caFile:     write("ca.pem", caPair.Cert),
+		certFile:   write("server.pem", serverPair.Cert),
+		keyFile:    write("server.key", serverPair.Key),
+		clientCert: *clientCert,
+	}
+}
+
+func startTLSServer(t *testing.T, ssl map[string]interface{}) *Server {
+	t.Helper()
+	cfg := config.MustNewConfigFrom(map[string]interface{}{
+		"host": "https://localhost:0",
+		"ssl":  ssl,
+	})
+
+	s, err := New(nil, simpleMux(), cfg)
+	require.NoError(t, err)
+	go s.Start()
+	t.Cleanup(func() {
+		require.NoError(t, s.Stop())
+	})
+	return s
+}
+
+func tlsGet(s *Server, tlsConfig *tls.Config) (string, error) {
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://"+s.Addr().String()+"/echo-hello", nil)
+	if err != nil {
+		return "", err
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+
+	body, err := httpcommon.ReadAll(r)
+	return string(body), err
+}
+
+func TestTLS(t *testing.T) {
+	certs := newTestCerts(t)
+
+	s := startTLSServer(t, map[string]interface{}{
+		"certificate": certs.certFile,
+		"key":         certs.keyFile,
+	})
+
+	body, err := tlsGet(s, &tls.Config{RootCAs: certs.caPool, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+
+	// plain HTTP is rejected
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+s.Addr().String()+"/echo-hello", nil)
+	require.NoError(t, err)
+	r, err := http.DefaultClient.Do(req)
+	if err == nil {
+		defer r.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, r.StatusCode)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	certs := newTestCerts(t)
+
+	s := startTLSServer(t, map[string]interface{}{
+		"certificate":             certs.certFile,
+		"key":                     certs.keyFile,
+		"certificate_authorities": []string{certs.caFile},
+	})
+
+	t.Run("with client certificate", func(t *testing.T) {
+		body, err := tlsGet(s, &tls.Config{
+			RootCAs:      certs.caPool,
+			Certificates: []tls.Certificate{certs.clientCert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "ehlo!", body)
+	})
+
+	t.Run("without client certificate", func(t *testing.T) {
+		_, err := tlsGet(s, &tls.Config{RootCAs: certs.caPool, MinVersion: tls.VersionTLS12})
+		assert.Error(t, err)
+	})
+}
+
+func TestHTTPSRequiresSSL(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]interface{}{
+		"host": "https://localhost:0",
+	})
+
+	_, err := New(nil, simpleMux(), cfg)
+	assert.ErrorContains(t, err, "ssl is not configured")
+}
+
+func TestReloadTLS(t *testing.T) {
+	certs := newTestCerts(t)
+	ssl := map[string]interface{}{
+		"certificate": certs.certFile,
+		"key":         certs.keyFile,
+	}
+	tlsConfig := &tls.Config{RootCAs: certs.caPool, MinVersion: tls.VersionTLS12}
+
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host": "http://127.0.0.1:0",
+	}))
+	require.NoError(t, err)
+	cancel, done := runServer(t, s)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+	addr := s.Addr().String()
+
+	// toggling TLS keeps the listener
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host": "https://127.0.0.1:0",
+		"ssl":  ssl,
+	})))
+	assert.Equal(t, addr, s.Addr().String())
+	body, err := tlsGet(s, tlsConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host": "http://127.0.0.1:0",
+	})))
+	assert.Equal(t, addr, s.Addr().String())
+	_, body, err = doGet(addr, "/echo-hello")
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+
+	// binding the port of the previous listener replaces it
+	require.NoError(t, s.Reload(config.MustNewConfigFrom(map[string]interface{}{
+		"host": "https://" + addr,
+		"ssl":  ssl,
+	})))
+	assert.Equal(t, addr, s.Addr().String())
+	body, err = tlsGet(s, tlsConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "ehlo!", body)
+}
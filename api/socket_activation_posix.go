@@ -0,0 +1,123 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !windows
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by the service manager.
+const listenFDsStart = 3
+
+// activatedSocket is a socket passed by the service manager.
+type activatedSocket struct {
+	name string
+	file *os.File
+}
+
+// activatedSockets returns the sockets passed by the service manager. They
+// are read once and kept open for the lifetime of the process, such that
+// listeners can be created for them again when the configuration is reloaded.
+var activatedSockets = sync.OnceValues(func() ([]activatedSocket, error) {
+	return listenFDs(os.Getenv, os.Getpid())
+})
+
+// listenFDs reads the sockets passed using the systemd socket activation
+// protocol, see sd_listen_fds(3). Sockets without name in LISTEN_FDNAMES are
+// named "unknown", as done by systemd.
+func listenFDs(getenv func(string) string, pid int) ([]activatedSocket, error) {
+	listenPID, listenFDs := getenv("LISTEN_PID"), getenv("LISTEN_FDS")
+	if listenPID == "" || listenFDs == "" {
+		return nil, errors.New("no sockets passed by the service manager, LISTEN_PID and LISTEN_FDS are not set")
+	}
+	if listenPID != strconv.Itoa(pid) {
+		return nil, fmt.Errorf("sockets passed by the service manager are for process %s, not %d", listenPID, pid)
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid number of sockets passed by the service manager LISTEN_FDS=%s", listenFDs)
+	}
+
+	names := make([]string, n)
+	if listenNames := getenv("LISTEN_FDNAMES"); listenNames != "" {
+		names = strings.Split(listenNames, ":")
+		if len(names) != n {
+			return nil, fmt.Errorf("LISTEN_FDNAMES has %d names, but LISTEN_FDS is %d", len(names), n)
+		}
+	}
+
+	sockets := make([]activatedSocket, n)
+	for i := range sockets {
+		fd := listenFDsStart + i
+		// do not leak the sockets to child processes
+		syscall.CloseOnExec(fd)
+
+		name := names[i]
+		if name == "" {
+			name = "unknown"
+		}
+		sockets[i] = activatedSocket{name: name, file: os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))}
+	}
+	return sockets, nil
+}
+
+// activatedListener creates a listener for the socket named name passed by
+// the service manager. If name is empty, exactly one socket must have been
+// passed.
+func activatedListener(name string) (net.Listener, error) {
+	sockets, err := activatedSockets()
+	if err != nil {
+		return nil, err
+	}
+	return selectActivatedListener(sockets, name)
+}
+
+func selectActivatedListener(sockets []activatedSocket, name string) (net.Listener, error) {
+	var selected []activatedSocket
+	for _, s := range sockets {
+		if name == "" || s.name == name {
+			selected = append(selected, s)
+		}
+	}
+
+	switch {
+	case len(selected) == 0:
+		return nil, fmt.Errorf("no socket named %s passed by the service manager", name)
+	case len(selected) > 1 && name == "":
+		return nil, fmt.Errorf("%d sockets passed by the service manager, select one with fd://<name>", len(selected))
+	case len(selected) > 1:
+		return nil, fmt.Errorf("%d sockets named %s passed by the service manager", len(selected), name)
+	}
+
+	// FileListener duplicates the file descriptor, closing the listener
+	// keeps the socket passed by the service manager open.
+	l, err := net.FileListener(selected[0].file)
+	if err != nil {
+		return nil, fmt.Errorf("socket %s passed by the service manager cannot be used as listener: %w", selected[0].name, err)
+	}
+	return l, nil
+}
@@ -0,0 +1,208 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !windows
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestListenFDsErrors(t *testing.T) {
+	pid := os.Getpid()
+	cases := map[string]struct {
+		env      map[string]string
+		expected string
+	}{
+		"not activated": {
+			env:      map[string]string{},
+			expected: "LISTEN_PID and LISTEN_FDS are not set",
+		},
+		"other process": {
+			env:      map[string]string{"LISTEN_PID": strconv.Itoa(pid + 1), "LISTEN_FDS": "1"},
+			expected: "are for process",
+		},
+		"invalid count": {
+			env:      map[string]string{"LISTEN_PID": strconv.Itoa(pid), "LISTEN_FDS": "0"},
+			expected: "invalid number of sockets",
+		},
+		"names mismatch": {
+			env:      map[string]string{"LISTEN_PID": strconv.Itoa(pid), "LISTEN_FDS": "2", "LISTEN_FDNAMES": "api"},
+			expected: "LISTEN_FDNAMES has 1 names, but LISTEN_FDS is 2",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := listenFDs(func(k string) string { return tc.env[k] }, pid)
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestSelectActivatedListener(t *testing.T) {
+	socket := func(t *testing.T, name string) activatedSocket {
+		t.Helper()
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		f, err := l.(*net.TCPListener).File()
+		require.NoError(t, err)
+		require.NoError(t, l.Close())
+		t.Cleanup(func() { f.Close() })
+		return activatedSocket{name: name, file: f}
+	}
+	api, metrics, other := socket(t, "api"), socket(t, "metrics"), socket(t, "metrics")
+
+	l, err := selectActivatedListener([]activatedSocket{api}, "")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// the passed socket stays open, a listener can be created again
+	l, err = selectActivatedListener([]activatedSocket{api, metrics}, "api")
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	require.NoError(t, l.Close())
+
+	_, err = selectActivatedListener([]activatedSocket{api, metrics}, "")
+	assert.ErrorContains(t, err, "select one with fd://<name>")
+	_, err = selectActivatedListener([]activatedSocket{api, metrics, other}, "metrics")
+	assert.ErrorContains(t, err, "2 sockets named metrics")
+	_, err = selectActivatedListener([]activatedSocket{api}, "unknown")
+	assert.ErrorContains(t, err, "no socket named unknown")
+}
+
+// TestSocketActivationHelper serves the API on the socket given by
+// API_TEST_ACTIVATION_HOST when run as child process by TestSocketActivation.
+func TestSocketActivationHelper(t *testing.T) {
+	host := os.Getenv("API_TEST_ACTIVATION_HOST")
+	if host == "" {
+		t.Skip("only run as child process of TestSocketActivation")
+	}
+	// systemd sets LISTEN_PID to the pid of the started process, that is
+	// not known before the process is started
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{"host": host}))
+	require.NoError(t, err)
+	s.Start()
+	fmt.Println("listening")
+	select {}
+}
+
+func TestSocketActivation(t *testing.T) {
+	cases := map[string]struct {
+		host  string
+		names string
+		count int
+		serve int
+	}{
+		"single socket":  {host: "fd://", count: 1, serve: 0},
+		"named socket":   {host: "fd://api", names: "metrics:api", count: 2, serve: 1},
+		"unnamed socket": {host: "fd://unknown", count: 1, serve: 0},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				files []*os.File
+				addrs []string
+			)
+			for i := 0; i < tc.count; i++ {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				require.NoError(t, err)
+				f, err := l.(*net.TCPListener).File()
+				require.NoError(t, err)
+				addrs = append(addrs, l.Addr().String())
+				files = append(files, f)
+				// the child process accepts the connections of the inherited socket
+				require.NoError(t, l.Close())
+				defer f.Close()
+			}
+
+			var out bytes.Buffer
+			cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivationHelper$", "-test.v") //nolint:gosec // runs the test binary
+			cmd.Env = append(os.Environ(),
+				"API_TEST_ACTIVATION_HOST="+tc.host,
+				"LISTEN_FDS="+strconv.Itoa(tc.count),
+				"LISTEN_FDNAMES="+tc.names,
+			)
+			cmd.ExtraFiles = files
+			cmd.Stdout, cmd.Stderr = &out, &out
+			require.NoError(t, cmd.Start())
+			defer func() {
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+			}()
+
+			var body string
+			require.Eventually(t, func() bool {
+				_, body, _ = doGet(addrs[tc.serve], "/echo-hello")
+				return body == "ehlo!"
+			}, 10*time.Second, 10*time.Millisecond, "child output: %s", &out)
+		})
+	}
+}
+
+func TestAbstractUnixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are only supported on Linux")
+	}
+	name := fmt.Sprintf("@elastic-agent-libs-api-test-%d", os.Getpid())
+
+	t.Run("serve", func(t *testing.T) {
+		s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+			"host":                     "unix://" + name,
+			"unix_socket.allowed_uids": []int{os.Getuid()},
+		}))
+		require.NoError(t, err)
+		go s.Start()
+		defer s.Stop()
+
+		assert.Equal(t, name, s.Addr().String())
+		assert.Equal(t, "ehlo!", getResponse(t, name, "http://unix/echo-hello"))
+	})
+
+	t.Run("file settings", func(t *testing.T) {
+		_, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+			"host":             "unix://" + name,
+			"unix_socket.mode": 0o600,
+		}))
+		assert.ErrorContains(t, err, "cannot be set for the abstract unix socket")
+	})
+
+	t.Run("missing name", func(t *testing.T) {
+		_, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+			"host": "unix://@",
+		}))
+		assert.ErrorContains(t, err, "missing abstract unix socket name")
+	})
+}
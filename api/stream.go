@@ -0,0 +1,234 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// StreamConfig bounds the interval clients can request for streamed metric
+// snapshots.
+type StreamConfig struct {
+	MinInterval time.Duration `config:"min_interval"`
+	MaxInterval time.Duration `config:"max_interval"`
+}
+
+const (
+	defaultStreamMinInterval = time.Second
+	defaultStreamMaxInterval = time.Minute
+
+	// defaultStreamInterval is used if the client does not request an
+	// interval. It is adjusted to the configured bounds.
+	defaultStreamInterval = 10 * time.Second
+)
+
+// Validate checks the bounds are positive and ordered.
+func (c *StreamConfig) Validate() error {
+	if c.MinInterval <= 0 {
+		return fmt.Errorf("stream min_interval must be positive, got %v", c.MinInterval)
+	}
+	if c.MaxInterval < c.MinInterval {
+		return fmt.Errorf("stream max_interval %v is less than min_interval %v", c.MaxInterval, c.MinInterval)
+	}
+	return nil
+}
+
+// interval returns the requested interval adjusted to the bounds. Unset
+// bounds are replaced by the defaults.
+func (c StreamConfig) interval(requested time.Duration) time.Duration {
+	lo, hi := c.MinInterval, c.MaxInterval
+	if lo <= 0 {
+		lo = defaultStreamMinInterval
+	}
+	if hi < lo {
+		hi = defaultStreamMaxInterval
+		if hi < lo {
+			hi = lo
+		}
+	}
+
+	if requested < lo {
+		return lo
+	}
+	if requested > hi {
+		return hi
+	}
+	return requested
+}
+
+// AttachStream adds the /stream endpoint streaming metric snapshots of the
+// namespaces to the server. The interval bounds are taken from the stream
+// settings of the current configuration. See MakeStreamHandler for the
+// supported query parameters.
+func (s *Server) AttachStream(namespaces map[string]*monitoring.Namespace) {
+	s.log.Info("Attaching stream endpoint")
+	s.AddRoute("/stream", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		cfg := s.config.Stream
+		s.mu.Unlock()
+		serveStream(w, r, namespaces, cfg)
+	})
+}
+
+// MakeStreamHandler creates a HandlerFunc streaming metric snapshots as
+// Server-Sent Events. A snapshot event is sent immediately and then at the
+// requested interval, until the client disconnects or the server shuts down.
+//
+// The handler supports the query parameters:
+//   - interval: time between snapshots, e.g. 5s. The interval is adjusted to
+//     the bounds of cfg. Defaults to 10s.
+//   - namespace: report the metrics of this namespace only. By default, the
+//     snapshot holds one object per namespace.
+//   - path: report the subtree or metric at the dotted path of the
+//     namespace only. Requires namespace.
+//   - format: struct (default) for nested JSON, or flat for JSON with dotted
+//     keys.
+//   - mode: full (default) or reported, selecting the metrics by their
+//     monitoring.Mode.
+func MakeStreamHandler(namespaces map[string]*monitoring.Namespace, cfg StreamConfig) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveStream(w, r, namespaces, cfg)
+	}
+}
+
+func serveStream(w http.ResponseWriter, r *http.Request, namespaces map[string]*monitoring.Namespace, cfg StreamConfig) {
+	query := r.URL.Query()
+
+	interval := defaultStreamInterval
+	if s := query.Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid interval %q", s), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	interval = cfg.interval(interval)
+
+	mode, err := parseMode(query.Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flat := false
+	switch format := query.Get("format"); format {
+	case "", "struct":
+	case "flat":
+		flat = true
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	collect, status, err := streamCollector(namespaces, query.Get("namespace"), query.Get("path"), mode, flat)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// ask clients to reconnect after one interval if the stream is interrupted
+	rc := http.NewResponseController(w)
+	fmt.Fprintf(w, "retry: %d\n\n", interval.Milliseconds())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	closing := serverClosing(r.Context())
+	for id := 1; ; id++ {
+		fmt.Fprintf(w, "event: snapshot\nid: %d\ndata: %s\n\n", id, collect().String())
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		}
+	}
+}
+
+// streamCollector returns a function collecting the snapshots selected by
+// namespace and path. If the selection is invalid, an error with the
+// response status is returned.
+func streamCollector(namespaces map[string]*monitoring.Namespace, namespace, path string, mode monitoring.Mode, flat bool) (func() mapstr.M, int, error) {
+	snapshot := func(reg *monitoring.Registry) mapstr.M {
+		data := mapstr.M(monitoring.CollectStructSnapshot(reg, mode, false))
+		if flat {
+			return data.Flatten()
+		}
+		return data
+	}
+
+	if namespace == "" {
+		if path != "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("path requires a namespace")
+		}
+		return func() mapstr.M {
+			data := make(mapstr.M, len(namespaces))
+			for name, ns := range namespaces {
+				data[name] = snapshot(ns.GetRegistry())
+			}
+			return data
+		}, 0, nil
+	}
+
+	ns, ok := namespaces[namespace]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown namespace %q", namespace)
+	}
+	if path == "" {
+		return func() mapstr.M { return snapshot(ns.GetRegistry()) }, 0, nil
+	}
+
+	reg := subRegistry(ns.GetRegistry(), path)
+	if reg == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown path %q", path)
+	}
+	return func() mapstr.M { return snapshot(reg) }, 0, nil
+}
+
+type serverClosingKey struct{}
+
+// withServerClosing stores the channel closed when the server starts
+// shutting down in the connection context. Long-lived responses use it to
+// end, as the server waits for them to complete before shutting down.
+func withServerClosing(ctx context.Context, closing <-chan struct{}) context.Context {
+	return context.WithValue(ctx, serverClosingKey{}, closing)
+}
+
+// serverClosing returns the channel closed when the server serving the
+// request shuts down. If the request is not served by a Server, a nil
+// channel is returned.
+func serverClosing(ctx context.Context) <-chan struct{} {
+	closing, _ := ctx.Value(serverClosingKey{}).(<-chan struct{})
+	return closing
+}
@@ -0,0 +1,217 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestStreamConfig(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		assert.NoError(t, (&StreamConfig{MinInterval: time.Second, MaxInterval: time.Second}).Validate())
+		assert.Error(t, (&StreamConfig{MinInterval: 0, MaxInterval: time.Second}).Validate())
+		assert.Error(t, (&StreamConfig{MinInterval: time.Minute, MaxInterval: time.Second}).Validate())
+
+		_, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+			"host":                "localhost:0",
+			"stream.min_interval": "1m",
+			"stream.max_interval": "1s",
+		}))
+		assert.Error(t, err)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		cfg := StreamConfig{MinInterval: time.Second, MaxInterval: time.Minute}
+		assert.Equal(t, time.Second, cfg.interval(time.Millisecond))
+		assert.Equal(t, 5*time.Second, cfg.interval(5*time.Second))
+		assert.Equal(t, time.Minute, cfg.interval(time.Hour))
+
+		var unset StreamConfig
+		assert.Equal(t, defaultStreamMinInterval, unset.interval(0))
+		assert.Equal(t, defaultStreamMaxInterval, unset.interval(time.Hour))
+	})
+}
+
+// readEvent reads the fields of the next Server-Sent Event.
+func readEvent(r *bufio.Reader) (map[string]string, error) {
+	event := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event, nil
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	stats := monitoring.GetNamespace("api_stream_test_stats")
+	events := monitoring.NewInt(stats.GetRegistry(), "pipeline.events")
+	monitoring.NewString(stats.GetRegistry(), "name").Set("test")
+	state := monitoring.GetNamespace("api_stream_test_state")
+	monitoring.NewBool(state.GetRegistry(), "running").Set(true)
+
+	namespaces := map[string]*monitoring.Namespace{"stats": stats, "state": state}
+	handler := MakeStreamHandler(namespaces, StreamConfig{MinInterval: 10 * time.Millisecond, MaxInterval: 20 * time.Millisecond})
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+
+	stream := func(t *testing.T, query string) (*bufio.Reader, func()) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream"+query, nil)
+		require.NoError(t, err)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	t.Run("all namespaces", func(t *testing.T) {
+		r, closeStream := stream(t, "?interval=1h")
+		defer closeStream()
+
+		retry, err := readEvent(r)
+		require.NoError(t, err)
+		assert.Equal(t, "20", retry["retry"], "interval must be bounded by max_interval")
+
+		event, err := readEvent(r)
+		require.NoError(t, err)
+		assert.Equal(t, "snapshot", event["event"])
+		assert.Equal(t, "1", event["id"])
+		assert.JSONEq(t, `{"stats": {"name": "test", "pipeline": {"events": 0}}, "state": {"running": true}}`, event["data"])
+	})
+
+	t.Run("periodic snapshots", func(t *testing.T) {
+		events.Set(0)
+		r, closeStream := stream(t, "?namespace=stats&path=pipeline&format=flat&interval=1ms")
+		defer closeStream()
+
+		retry, err := readEvent(r)
+		require.NoError(t, err)
+		assert.Equal(t, "10", retry["retry"], "interval must be bounded by min_interval")
+
+		event, err := readEvent(r)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"events": 0}`, event["data"])
+
+		events.Set(5)
+		event, err = readEvent(r)
+		require.NoError(t, err)
+		assert.Equal(t, "2", event["id"])
+		var data map[string]int
+		require.NoError(t, json.Unmarshal([]byte(event["data"]), &data))
+		assert.Equal(t, 5, data["events"])
+	})
+
+	for name, tc := range map[string]struct {
+		query  string
+		status int
+	}{
+		"invalid interval":  {"?interval=soon", http.StatusBadRequest},
+		"negative interval": {"?interval=-1s", http.StatusBadRequest},
+		"invalid mode":      {"?mode=all", http.StatusBadRequest},
+		"invalid format":    {"?format=prometheus", http.StatusBadRequest},
+		"path only":         {"?path=pipeline", http.StatusBadRequest},
+		"unknown namespace": {"?namespace=dataset", http.StatusNotFound},
+		"unknown path":      {"?namespace=stats&path=unknown", http.StatusNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/stream"+tc.query, nil))
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestStreamHandlerPathMode(t *testing.T) {
+	stats := monitoring.GetNamespace("api_stream_test_path_mode")
+	monitoring.NewInt(stats.GetRegistry(), "output.acked", monitoring.Report).Set(2)
+	monitoring.NewInt(stats.GetRegistry(), "output.failed").Set(1)
+
+	handler := MakeStreamHandler(map[string]*monitoring.Namespace{"stats": stats}, StreamConfig{})
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?namespace=stats&path=output.acked&mode=reported", nil)
+	require.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r := bufio.NewReader(resp.Body)
+	_, err = readEvent(r)
+	require.NoError(t, err)
+	event, err := readEvent(r)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"acked": 2}`, event["data"])
+}
+
+func TestStreamEndsOnShutdown(t *testing.T) {
+	s, err := New(nil, simpleMux(), config.MustNewConfigFrom(map[string]interface{}{
+		"host":                localhostURL,
+		"shutdown_timeout":    "5s",
+		"stream.min_interval": "10ms",
+	}))
+	require.NoError(t, err)
+	s.AttachStream(map[string]*monitoring.Namespace{"stats": monitoring.GetNamespace("api_stream_shutdown_test")})
+	cancel, done := runServer(t, s)
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/stream?interval=10ms") //nolint:noctx // closed by the server
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	_, err = readEvent(r)
+	require.NoError(t, err)
+	_, err = readEvent(r)
+	require.NoError(t, err)
+
+	start := time.Now()
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Less(t, time.Since(start), time.Second, "open streams must not delay the shutdown")
+
+	_, err = io.Copy(io.Discard, r)
+	assert.NoError(t, err, "stream must end cleanly")
+}
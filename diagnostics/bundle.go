@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diagnostics
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ManifestFilename is the name of the file listing the content of a bundle.
+const ManifestFilename = "manifest.json"
+
+// Manifest describes the content of a bundle.
+type Manifest struct {
+	Created time.Time       `json:"created"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a single file of a bundle.
+type ManifestEntry struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Filename    string        `json:"filename"`
+	ContentType string        `json:"content_type"`
+	Size        int           `json:"size"`
+	Duration    time.Duration `json:"duration_ns"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// nowFn is used to acquire the current time instead of time.Now so it can be
+// mocked for tests.
+var nowFn = time.Now
+
+// WriteBundle calls the hooks in order and streams a zip archive holding one
+// file per hook to w. The archive is completed by a manifest listing all
+// files. A panicking hook does not abort the bundle, the panic is recorded in
+// the manifest instead and the hook's file is left empty.
+// Writing stops with the context's error if ctx is cancelled.
+func WriteBundle(ctx context.Context, w io.Writer, hooks []Hook) error {
+	seen := make(map[string]struct{}, len(hooks))
+	for _, h := range hooks {
+		if err := validateFilename(h.Filename); err != nil {
+			return fmt.Errorf("diagnostics hook %s: %w", h.Name, err)
+		}
+		if _, exists := seen[h.Filename]; exists || h.Filename == ManifestFilename {
+			return fmt.Errorf("diagnostics hook %s: duplicate filename %q", h.Name, h.Filename)
+		}
+		seen[h.Filename] = struct{}{}
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := Manifest{Created: nowFn().UTC(), Files: make([]ManifestEntry, 0, len(hooks))}
+	for _, h := range hooks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := nowFn()
+		content, err := callHook(h.Hook)
+		entry := ManifestEntry{
+			Name:        h.Name,
+			Description: h.Description,
+			Filename:    h.Filename,
+			ContentType: h.ContentType,
+			Size:        len(content),
+			Duration:    nowFn().Sub(start),
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		manifest.Files = append(manifest.Files, entry)
+
+		if err := writeFile(zw, h.Filename, start, content); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(zw, ManifestFilename, manifest.Created, body); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func callHook(fn HookFunc) (content []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			content = nil
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	if fn == nil {
+		return nil, fmt.Errorf("hook has no function")
+	}
+	return fn(), nil
+}
+
+func writeFile(zw *zip.Writer, name string, modified time.Time, content []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", name, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package diagnostics collects diagnostic information of a process into a
+// single bundle. Components register named hooks producing the content of a
+// file in the bundle, for example the hooks returned by
+// tlscommon.Config.DiagCerts or httpcommon.HTTPTransportSettings.DiagRequests.
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HookFunc produces the content of a diagnostics file. It is called every
+// time a bundle is created.
+type HookFunc func() []byte
+
+// Hook is a named source of diagnostic information.
+type Hook struct {
+	// Name identifies the hook.
+	Name string
+
+	// Description explains the content produced by the hook.
+	Description string
+
+	// Filename is the path of the hook's file in the bundle.
+	Filename string
+
+	// ContentType is the media type of the content produced by the hook.
+	ContentType string
+
+	// Hook produces the content of the file.
+	Hook HookFunc
+}
+
+// ErrDuplicateHook is returned when registering a hook with a name or
+// filename that is already in use.
+var ErrDuplicateHook = errors.New("diagnostics hook already registered")
+
+// Registry holds the named diagnostics hooks of a process.
+type Registry struct {
+	mu    sync.RWMutex
+	hooks map[string]Hook
+}
+
+// NewRegistry creates an empty diagnostics registry.
+func NewRegistry() *Registry {
+	return &Registry{hooks: map[string]Hook{}}
+}
+
+// Register adds a hook. If filename is empty, the name with a .txt
+// extension is used. If contentType is empty, text/plain is assumed.
+func (r *Registry) Register(name, description, filename, contentType string, fn HookFunc) error {
+	if name == "" {
+		return errors.New("diagnostics hook name must not be empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("diagnostics hook %s has no function", name)
+	}
+	if filename == "" {
+		filename = name + ".txt"
+	}
+	if err := validateFilename(filename); err != nil {
+		return fmt.Errorf("diagnostics hook %s: %w", name, err)
+	}
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, h := range r.hooks {
+		if h.Name == name || h.Filename == filename {
+			return fmt.Errorf("%w: %s", ErrDuplicateHook, name)
+		}
+	}
+	r.hooks[name] = Hook{
+		Name:        name,
+		Description: description,
+		Filename:    filename,
+		ContentType: contentType,
+		Hook:        fn,
+	}
+	return nil
+}
+
+// Unregister removes the named hook. Unregistering an unknown hook is a
+// no-op.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.hooks, name)
+}
+
+// Hooks returns all registered hooks sorted by name.
+func (r *Registry) Hooks() []Hook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hooks := make([]Hook, 0, len(r.hooks))
+	for _, h := range r.hooks {
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].Name < hooks[j].Name })
+	return hooks
+}
+
+// validateFilename ensures the filename is a relative slash separated path
+// staying inside the bundle.
+func validateFilename(filename string) error {
+	if strings.Contains(filename, `\`) ||
+		path.IsAbs(filename) ||
+		path.Clean(filename) != filename ||
+		filename == "." || filename == ".." || strings.HasPrefix(filename, "../") {
+		return fmt.Errorf("invalid filename %q", filename)
+	}
+	return nil
+}
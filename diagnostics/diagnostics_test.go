@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diagnostics
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	hook := func() []byte { return []byte("content") }
+
+	require.NoError(t, reg.Register("b", "second", "", "", hook))
+	require.NoError(t, reg.Register("a", "first", "a.json", "application/json", hook))
+
+	err := reg.Register("a", "", "other.txt", "", hook)
+	assert.ErrorIs(t, err, ErrDuplicateHook)
+	err = reg.Register("c", "", "b.txt", "", hook)
+	assert.ErrorIs(t, err, ErrDuplicateHook, "filenames must be unique")
+
+	hooks := reg.Hooks()
+	require.Len(t, hooks, 2)
+	assert.Equal(t, "a", hooks[0].Name)
+	assert.Equal(t, "a.json", hooks[0].Filename)
+	assert.Equal(t, "application/json", hooks[0].ContentType)
+	assert.Equal(t, "b", hooks[1].Name)
+	assert.Equal(t, "b.txt", hooks[1].Filename)
+	assert.Equal(t, "text/plain", hooks[1].ContentType)
+
+	reg.Unregister("a")
+	reg.Unregister("unknown")
+	hooks = reg.Hooks()
+	require.Len(t, hooks, 1)
+	assert.Equal(t, "b", hooks[0].Name)
+}
+
+func TestRegistryInvalid(t *testing.T) {
+	hook := func() []byte { return nil }
+	cases := map[string]struct {
+		name, filename string
+		fn             HookFunc
+	}{
+		"empty name":      {name: "", fn: hook},
+		"missing func":    {name: "a"},
+		"absolute path":   {name: "a", filename: "/etc/passwd", fn: hook},
+		"parent dir":      {name: "a", filename: "../a.txt", fn: hook},
+		"unclean path":    {name: "a", filename: "x/../../a.txt", fn: hook},
+		"backslash":       {name: "a", filename: `x\a.txt`, fn: hook},
+		"trailing slash":  {name: "a", filename: "x/", fn: hook},
+		"current dir":     {name: "a", filename: ".", fn: hook},
+		"double slash":    {name: "a", filename: "x//a.txt", fn: hook},
+		"dot in the path": {name: "a", filename: "./a.txt", fn: hook},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := NewRegistry().Register(tc.name, "", tc.filename, "", tc.fn)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	hooks := []Hook{
+		{Name: "a", Filename: "a.txt", ContentType: "text/plain", Hook: func() []byte { return []byte("hello") }},
+		{Name: "b", Filename: "sub/b.txt", ContentType: "text/plain", Hook: func() []byte { panic("boom") }},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteBundle(context.Background(), &buf, hooks))
+
+	files := readBundle(t, buf.Bytes())
+	assert.Equal(t, "hello", string(files["a.txt"]))
+	assert.Empty(t, files["sub/b.txt"])
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(files[ManifestFilename], &manifest))
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "a", manifest.Files[0].Name)
+	assert.Equal(t, 5, manifest.Files[0].Size)
+	assert.Empty(t, manifest.Files[0].Error)
+	assert.Equal(t, "b", manifest.Files[1].Name)
+	assert.Contains(t, manifest.Files[1].Error, "boom")
+}
+
+func TestWriteBundleErrors(t *testing.T) {
+	hook := func() []byte { return nil }
+
+	t.Run("duplicate filename", func(t *testing.T) {
+		err := WriteBundle(context.Background(), io.Discard, []Hook{
+			{Name: "a", Filename: "a.txt", Hook: hook},
+			{Name: "b", Filename: "a.txt", Hook: hook},
+		})
+		assert.ErrorContains(t, err, "duplicate filename")
+	})
+
+	t.Run("reserved manifest filename", func(t *testing.T) {
+		err := WriteBundle(context.Background(), io.Discard, []Hook{
+			{Name: "a", Filename: ManifestFilename, Hook: hook},
+		})
+		assert.ErrorContains(t, err, "duplicate filename")
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := WriteBundle(ctx, io.Discard, []Hook{{Name: "a", Filename: "a.txt", Hook: hook}})
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
+
+func TestBuiltinHooks(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	monitoring.NewInt(reg, "events").Set(42)
+
+	cfg := config.MustNewConfigFrom(map[string]interface{}{
+		"output.elasticsearch": map[string]interface{}{
+			"index":    "logs-test",
+			"password": "secret",
+		},
+	})
+
+	diagReg := NewRegistry()
+	require.NoError(t, diagReg.Register("tls", "TLS certificates", "", "", (&tlscommon.Config{}).DiagCerts()))
+
+	hooks := append([]Hook{
+		GoroutineProfile(),
+		HeapProfile(),
+		MetricsSnapshot("stats", reg),
+		Config(cfg),
+	}, diagReg.Hooks()...)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteBundle(context.Background(), &buf, hooks))
+	files := readBundle(t, buf.Bytes())
+
+	assert.Contains(t, string(files["goroutine.txt"]), "goroutine")
+	assert.NotEmpty(t, files["heap.pprof"])
+	assert.JSONEq(t, `{"events": 42}`, string(files["metrics/stats.json"]))
+	assert.Contains(t, string(files["config.json"]), "logs-test")
+	assert.NotContains(t, string(files["config.json"]), "secret")
+	assert.Contains(t, string(files["tls.txt"]), "tlscommon.Config")
+}
+
+func readBundle(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[f.Name] = content
+	}
+	return files
+}
@@ -0,0 +1,94 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime/pprof"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// GoroutineProfile returns a hook producing the stack traces of all
+// goroutines in text format.
+func GoroutineProfile() Hook {
+	return Hook{
+		Name:        "goroutine",
+		Description: "Stack traces of all current goroutines",
+		Filename:    "goroutine.txt",
+		ContentType: "text/plain",
+		Hook:        profile("goroutine", 2),
+	}
+}
+
+// HeapProfile returns a hook producing a heap profile in the pprof format.
+func HeapProfile() Hook {
+	return Hook{
+		Name:        "heap",
+		Description: "A sampling of memory allocations of live objects",
+		Filename:    "heap.pprof",
+		ContentType: "application/octet-stream",
+		Hook:        profile("heap", 0),
+	}
+}
+
+func profile(name string, debug int) HookFunc {
+	return func() []byte {
+		var b bytes.Buffer
+		if err := pprof.Lookup(name).WriteTo(&b, debug); err != nil {
+			return []byte(fmt.Sprintf("error: failed to write %s profile: %v\n", name, err))
+		}
+		return b.Bytes()
+	}
+}
+
+// MetricsSnapshot returns a hook producing a JSON snapshot of all metrics of
+// the registry. The snapshot is stored as metrics/<name>.json.
+func MetricsSnapshot(name string, reg *monitoring.Registry) Hook {
+	return Hook{
+		Name:        "metrics." + name,
+		Description: fmt.Sprintf("Snapshot of the %s metrics", name),
+		Filename:    "metrics/" + name + ".json",
+		ContentType: "application/json",
+		Hook: func() []byte {
+			snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+			b, err := json.MarshalIndent(snapshot, "", "  ")
+			if err != nil {
+				return []byte(fmt.Sprintf("error: failed to encode %s metrics: %v\n", name, err))
+			}
+			return b
+		},
+	}
+}
+
+// Config returns a hook producing the configuration with private settings,
+// like passwords and tokens, masked.
+func Config(c *config.C) Hook {
+	return Hook{
+		Name:        "config",
+		Description: "Configuration with private settings redacted",
+		Filename:    "config.json",
+		ContentType: "application/json",
+		Hook: func() []byte {
+			return []byte(config.DebugString(c, true))
+		},
+	}
+}
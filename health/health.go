@@ -0,0 +1,335 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package health provides a registry for components to report their health.
+// Checks are either functions called every time the health is requested, or
+// push-style reporters updated by the component itself. The registry
+// aggregates the results of all checks into a single status that can be used
+// for liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the health status of a check or of the aggregated checks.
+type Status uint8
+
+const (
+	// Healthy reports the component working as expected.
+	Healthy Status = iota
+
+	// Degraded reports the component working with reduced functionality.
+	Degraded
+
+	// Failed reports the component not working.
+	Failed
+)
+
+var statusNames = map[Status]string{
+	Healthy:  "healthy",
+	Degraded: "degraded",
+	Failed:   "failed",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", uint8(s))
+}
+
+// MarshalText marshals the status into a human readable value.
+func (s Status) MarshalText() ([]byte, error) {
+	if name, ok := statusNames[s]; ok {
+		return []byte(name), nil
+	}
+	return nil, fmt.Errorf("unknown health status %d", uint8(s))
+}
+
+// UnmarshalText unmarshals a human readable status.
+func (s *Status) UnmarshalText(text []byte) error {
+	for status, name := range statusNames {
+		if name == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown health status %q", text)
+}
+
+// Probe selects the checks taken into account when aggregating the status.
+type Probe uint8
+
+const (
+	// ReadinessProbe aggregates all checks.
+	ReadinessProbe Probe = iota
+
+	// LivenessProbe only aggregates the checks registered with the Liveness option.
+	LivenessProbe
+)
+
+// Result is the last known state of a single check.
+type Result struct {
+	Status    Status    `json:"status"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Report is the aggregated status of the checks selected by a probe.
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// CheckFunc checks the health of a component. Returning nil reports the
+// component as healthy. Errors wrapped with Degrade report the component as
+// degraded, all other errors report it as failed.
+type CheckFunc func(ctx context.Context) error
+
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string { return e.err.Error() }
+func (e *degradedError) Unwrap() error { return e.err }
+
+// Degrade wraps err, such that a CheckFunc returning it reports the component
+// as degraded instead of failed.
+func Degrade(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &degradedError{err: err}
+}
+
+// ErrDuplicateCheck is returned when registering a check with a name that is
+// already in use.
+var ErrDuplicateCheck = errors.New("health check already registered")
+
+// DefaultTimeout is the time a CheckFunc is given to complete if the
+// registry has not been configured with a different timeout.
+const DefaultTimeout = 5 * time.Second
+
+// Registry holds the named health checks of a process.
+type Registry struct {
+	mu      sync.RWMutex
+	checks  map[string]*check
+	timeout time.Duration
+
+	// nowFn is used to acquire the current time instead of time.Now so it can
+	// be mocked for tests.
+	nowFn func() time.Time
+}
+
+type check struct {
+	opts checkOptions
+
+	// fn is set for function checks, otherwise the check is push-style and
+	// reports the last result.
+	fn CheckFunc
+
+	mu     sync.Mutex
+	result Result
+}
+
+// NewRegistry creates an empty health registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		checks:  map[string]*check{},
+		timeout: DefaultTimeout,
+		nowFn:   time.Now,
+	}
+}
+
+// SetTimeout sets the time each CheckFunc is given to complete. A check not
+// completing in time is reported as failed.
+func (r *Registry) SetTimeout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = d
+}
+
+// Register adds a function check. The function is called concurrently with
+// the other checks every time the health is requested. A panicking function
+// is reported as failed.
+func (r *Registry) Register(name string, fn CheckFunc, opts ...CheckOption) error {
+	if fn == nil {
+		return fmt.Errorf("health check %s has no function", name)
+	}
+	return r.add(name, &check{fn: fn, opts: applyCheckOptions(opts)})
+}
+
+// NewReporter adds a push-style check and returns the Reporter to update it.
+// The check reports as degraded until the first update.
+func (r *Registry) NewReporter(name string, opts ...CheckOption) (*Reporter, error) {
+	c := &check{
+		opts: applyCheckOptions(opts),
+		result: Result{
+			Status:    Degraded,
+			Message:   "no status reported yet",
+			Timestamp: r.nowFn(),
+		},
+	}
+	if err := r.add(name, c); err != nil {
+		return nil, err
+	}
+	return &Reporter{check: c, nowFn: r.nowFn}, nil
+}
+
+func (r *Registry) add(name string, c *check) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.checks[name]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateCheck, name)
+	}
+	r.checks[name] = c
+	return nil
+}
+
+// Unregister removes the named check. Unregistering an unknown check is a
+// no-op.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, name)
+}
+
+// Names returns the sorted names of all registered checks.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs the checks selected by the probe and aggregates their results.
+// The aggregated status is the worst status of all critical checks. Failing
+// checks registered with the NonCritical option only degrade the aggregated
+// status.
+func (r *Registry) Check(ctx context.Context, probe Probe) Report {
+	r.mu.RLock()
+	timeout := r.timeout
+	checks := make(map[string]*check, len(r.checks))
+	for name, c := range r.checks {
+		if probe == LivenessProbe && !c.opts.liveness {
+			continue
+		}
+		checks[name] = c
+	}
+	r.mu.RUnlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	report := Report{Status: Healthy, Checks: make(map[string]Result, len(checks))}
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c *check) {
+			defer wg.Done()
+			res := r.run(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = res
+			status := res.Status
+			if status == Failed && c.opts.nonCritical {
+				status = Degraded
+			}
+			if status > report.Status {
+				report.Status = status
+			}
+		}(name, c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	if c.fn == nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.result
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("health check panicked: %v", p)
+			}
+		}()
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("health check did not complete: %w", ctx.Err())
+	}
+
+	res := Result{Status: Healthy, Timestamp: r.nowFn()}
+	if err != nil {
+		res.Status = Failed
+		res.Message = err.Error()
+		var degraded *degradedError
+		if errors.As(err, &degraded) {
+			res.Status = Degraded
+		}
+	}
+	return res
+}
+
+// Reporter updates the status of a push-style check.
+type Reporter struct {
+	check *check
+	nowFn func() time.Time
+}
+
+// Update sets the current status of the check.
+func (r *Reporter) Update(status Status, message string) {
+	r.check.mu.Lock()
+	defer r.check.mu.Unlock()
+	r.check.result = Result{Status: status, Message: message, Timestamp: r.nowFn()}
+}
+
+// Healthy reports the component as healthy.
+func (r *Reporter) Healthy(message string) { r.Update(Healthy, message) }
+
+// Degraded reports the component as degraded.
+func (r *Reporter) Degraded(message string) { r.Update(Degraded, message) }
+
+// Failed reports the component as failed.
+func (r *Reporter) Failed(message string) { r.Update(Failed, message) }
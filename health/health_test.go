@@ -0,0 +1,169 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAggregation(t *testing.T) {
+	cases := map[string]struct {
+		checks   map[string]error
+		opts     []CheckOption
+		expected Status
+	}{
+		"no checks": {
+			expected: Healthy,
+		},
+		"all healthy": {
+			checks:   map[string]error{"a": nil, "b": nil},
+			expected: Healthy,
+		},
+		"one degraded": {
+			checks:   map[string]error{"a": nil, "b": Degrade(errors.New("slow"))},
+			expected: Degraded,
+		},
+		"one failed": {
+			checks:   map[string]error{"a": Degrade(errors.New("slow")), "b": errors.New("down")},
+			expected: Failed,
+		},
+		"non-critical failed": {
+			checks:   map[string]error{"a": nil, "b": errors.New("down")},
+			opts:     []CheckOption{NonCritical},
+			expected: Degraded,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			reg := NewRegistry()
+			for name, err := range tc.checks {
+				err := err
+				require.NoError(t, reg.Register(name, func(context.Context) error { return err }, tc.opts...))
+			}
+
+			report := reg.Check(context.Background(), ReadinessProbe)
+			assert.Equal(t, tc.expected, report.Status)
+			assert.Len(t, report.Checks, len(tc.checks))
+			for name, err := range tc.checks {
+				if err != nil {
+					assert.Equal(t, err.Error(), report.Checks[name].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryReporter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reg := NewRegistry()
+	reg.nowFn = func() time.Time { return now }
+
+	reporter, err := reg.NewReporter("output")
+	require.NoError(t, err)
+
+	report := reg.Check(context.Background(), ReadinessProbe)
+	assert.Equal(t, Degraded, report.Status)
+	assert.Equal(t, "no status reported yet", report.Checks["output"].Message)
+
+	now = now.Add(time.Minute)
+	reporter.Failed("connection refused")
+	report = reg.Check(context.Background(), ReadinessProbe)
+	assert.Equal(t, Failed, report.Status)
+	assert.Equal(t, Result{Status: Failed, Message: "connection refused", Timestamp: now}, report.Checks["output"])
+
+	reporter.Healthy("")
+	assert.Equal(t, Healthy, reg.Check(context.Background(), ReadinessProbe).Status)
+
+	_, err = reg.NewReporter("output")
+	assert.ErrorIs(t, err, ErrDuplicateCheck)
+	assert.ErrorIs(t, reg.Register("output", func(context.Context) error { return nil }), ErrDuplicateCheck)
+}
+
+func TestRegistryProbes(t *testing.T) {
+	reg := NewRegistry()
+	require.NoError(t, reg.Register("deadlock", func(context.Context) error { return nil }, Liveness))
+	require.NoError(t, reg.Register("output", func(context.Context) error { return errors.New("down") }))
+
+	live := reg.Check(context.Background(), LivenessProbe)
+	assert.Equal(t, Healthy, live.Status)
+	assert.Len(t, live.Checks, 1)
+	assert.Contains(t, live.Checks, "deadlock")
+
+	ready := reg.Check(context.Background(), ReadinessProbe)
+	assert.Equal(t, Failed, ready.Status)
+	assert.Len(t, ready.Checks, 2)
+
+	reg.Unregister("output")
+	assert.Equal(t, []string{"deadlock"}, reg.Names())
+	assert.Equal(t, Healthy, reg.Check(context.Background(), ReadinessProbe).Status)
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	reg := NewRegistry()
+	reg.SetTimeout(10 * time.Millisecond)
+
+	block := make(chan struct{})
+	defer close(block)
+	require.NoError(t, reg.Register("stuck", func(context.Context) error {
+		<-block
+		return nil
+	}))
+
+	report := reg.Check(context.Background(), ReadinessProbe)
+	assert.Equal(t, Failed, report.Status)
+	assert.Contains(t, report.Checks["stuck"].Message, "did not complete")
+}
+
+func TestRegistryPanic(t *testing.T) {
+	reg := NewRegistry()
+	require.NoError(t, reg.Register("panics", func(context.Context) error {
+		panic("boom")
+	}))
+	require.NoError(t, reg.Register("output", func(context.Context) error { return nil }))
+
+	report := reg.Check(context.Background(), ReadinessProbe)
+	assert.Equal(t, Failed, report.Status)
+	assert.Equal(t, Failed, report.Checks["panics"].Status)
+	assert.Contains(t, report.Checks["panics"].Message, "boom")
+	assert.Equal(t, Healthy, report.Checks["output"].Status)
+}
+
+func TestRegistryNilCheck(t *testing.T) {
+	reg := NewRegistry()
+	assert.Error(t, reg.Register("nil", nil))
+	assert.Empty(t, reg.Names())
+}
+
+func TestStatusJSON(t *testing.T) {
+	data, err := json.Marshal(Report{Status: Degraded, Checks: map[string]Result{}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"degraded","checks":{}}`, string(data))
+
+	var s Status
+	require.NoError(t, json.Unmarshal([]byte(`"failed"`), &s))
+	assert.Equal(t, Failed, s)
+	assert.Error(t, json.Unmarshal([]byte(`"unknown"`), &s))
+}
@@ -0,0 +1,48 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package health
+
+// CheckOption configures a health check when it is registered.
+type CheckOption func(checkOptions) checkOptions
+
+type checkOptions struct {
+	liveness    bool
+	nonCritical bool
+}
+
+// Liveness includes the check in the liveness probe. Only checks whose
+// failure requires the process to be restarted should be part of it.
+func Liveness(o checkOptions) checkOptions {
+	o.liveness = true
+	return o
+}
+
+// NonCritical limits the impact of a failing check on the aggregated status
+// to degraded.
+func NonCritical(o checkOptions) checkOptions {
+	o.nonCritical = true
+	return o
+}
+
+func applyCheckOptions(opts []CheckOption) checkOptions {
+	var o checkOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	return o
+}
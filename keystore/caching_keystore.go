@@ -0,0 +1,364 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// Default settings of the CachingKeystore.
+const (
+	DefaultCacheTTL             = 5 * time.Minute
+	DefaultCacheRefreshAhead    = 30 * time.Second
+	DefaultCacheFetchTimeout    = 10 * time.Second
+	DefaultCacheRefreshInterval = time.Second
+)
+
+// Failed refreshes of cached secrets are retried with an exponential backoff
+// between these limits, such that an unavailable source is not flooded with
+// requests.
+const (
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+// CacheOption configures a CachingKeystore.
+type CacheOption func(cacheOptions) cacheOptions
+
+type cacheOptions struct {
+	ttl             time.Duration
+	refreshAhead    time.Duration
+	fetchTimeout    time.Duration
+	refreshInterval time.Duration
+}
+
+// WithTTL sets the maximum time a secret is cached. Secrets expiring earlier
+// are cached until they expire. A zero TTL caches secrets without expiry
+// forever.
+func WithTTL(ttl time.Duration) CacheOption {
+	return func(o cacheOptions) cacheOptions {
+		o.ttl = ttl
+		return o
+	}
+}
+
+// WithRefreshAhead sets how long before its expiry a cached secret is
+// refreshed in the background, such that retrievals are not blocked by
+// fetching the new value. Zero disables refreshing ahead.
+func WithRefreshAhead(d time.Duration) CacheOption {
+	return func(o cacheOptions) cacheOptions {
+		o.refreshAhead = d
+		return o
+	}
+}
+
+// WithFetchTimeout sets the time given to the DynamicKeystore to fetch a
+// secret.
+func WithFetchTimeout(d time.Duration) CacheOption {
+	return func(o cacheOptions) cacheOptions {
+		o.fetchTimeout = d
+		return o
+	}
+}
+
+// WithRefreshInterval sets how often CachingKeystore.Run checks for cached
+// secrets to refresh.
+func WithRefreshInterval(d time.Duration) CacheOption {
+	return func(o cacheOptions) cacheOptions {
+		o.refreshInterval = d
+		return o
+	}
+}
+
+// CachingKeystore caches the secrets of a DynamicKeystore and implements the
+// Keystore interface, such that dynamic secrets can be resolved with
+// ResolverWrap. Secrets are fetched on their first retrieval and cached until
+// they expire or their TTL elapses. Secrets close to their expiry are
+// refreshed in the background, failed refreshes are retried with a backoff.
+// Subscribers registered with OnChange are
+// notified when a refreshed secret changed, for example to reload the
+// configuration using a rotated token without restarting.
+type CachingKeystore struct {
+	source DynamicKeystore
+	opts   cacheOptions
+
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	inflight    map[string]*fetchCall
+	subscribers map[int]func(key string)
+	nextID      int
+
+	// nowFn is used to acquire the current time instead of time.Now so it can
+	// be mocked for tests.
+	nowFn func() time.Time
+}
+
+type cacheEntry struct {
+	value *SecureString
+
+	// expiresAt and refreshAt are zero if the entry does not expire.
+	expiresAt  time.Time
+	refreshAt  time.Time
+	refreshing bool
+
+	// failures counts the failed refreshes since the last successful one,
+	// err is the error of the last failed refresh.
+	failures int
+	err      error
+}
+
+// fetchCall is a fetch in progress, shared by concurrent retrievals of the
+// same key.
+type fetchCall struct {
+	done  chan struct{}
+	value *SecureString
+	err   error
+}
+
+// NewCachingKeystore returns a keystore caching the secrets of source.
+func NewCachingKeystore(source DynamicKeystore, opts ...CacheOption) *CachingKeystore {
+	o := cacheOptions{
+		ttl:             DefaultCacheTTL,
+		refreshAhead:    DefaultCacheRefreshAhead,
+		fetchTimeout:    DefaultCacheFetchTimeout,
+		refreshInterval: DefaultCacheRefreshInterval,
+	}
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	return &CachingKeystore{
+		source:      source,
+		opts:        o,
+		entries:     map[string]*cacheEntry{},
+		inflight:    map[string]*fetchCall{},
+		subscribers: map[int]func(string){},
+		nowFn:       time.Now,
+	}
+}
+
+// Retrieve returns the cached secret, or fetches it if it is not cached or
+// expired. If the secret is due to be refreshed, the cached value is
+// returned and the secret is refreshed in the background. If refreshing an
+// expired secret failed, the error is returned until the refresh is retried.
+func (k *CachingKeystore) Retrieve(key string) (*SecureString, error) {
+	now := k.nowFn()
+
+	k.mu.Lock()
+	e, ok := k.entries[key]
+	switch {
+	case ok && !e.expired(now):
+		if e.refreshDue(now) {
+			e.refreshing = true
+			go k.fetch(key) //nolint:errcheck // errors are reported by retrievals after expiry
+		}
+		value := e.value
+		k.mu.Unlock()
+		return value, nil
+	case ok && e.err != nil && now.Before(e.refreshAt):
+		err := e.err
+		k.mu.Unlock()
+		return nil, err
+	}
+	k.mu.Unlock()
+
+	return k.fetch(key)
+}
+
+// fetch fetches the secret from the source and updates the cache. Concurrent
+// fetches of the same key share the result.
+func (k *CachingKeystore) fetch(key string) (*SecureString, error) {
+	k.mu.Lock()
+	if call, ok := k.inflight[key]; ok {
+		k.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	k.inflight[key] = call
+	k.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.opts.fetchTimeout)
+	value, expiresAt, err := k.source.Retrieve(ctx, key)
+	cancel()
+
+	k.mu.Lock()
+	delete(k.inflight, key)
+	changed := false
+	previous, cached := k.entries[key]
+	switch {
+	case err == nil:
+		k.entries[key] = k.newEntry(value, expiresAt)
+		changed = cached && !sameSecret(previous.value, value)
+	case errors.Is(err, ErrKeyDoesntExists):
+		delete(k.entries, key)
+		changed = cached
+	case cached:
+		// keep serving the cached secret until it expires and retry later
+		previous.refreshing = false
+		previous.failures++
+		previous.err = err
+		previous.refreshAt = k.nowFn().Add(retryBackoff(previous.failures))
+	}
+	subscribers := k.subscribersLocked(changed)
+	k.mu.Unlock()
+
+	call.value, call.err = value, err
+	close(call.done)
+
+	for _, fn := range subscribers {
+		fn(key)
+	}
+	return value, err
+}
+
+func (k *CachingKeystore) newEntry(value *SecureString, expiresAt time.Time) *cacheEntry {
+	now := k.nowFn()
+	if k.opts.ttl > 0 {
+		if ttlExpiry := now.Add(k.opts.ttl); expiresAt.IsZero() || ttlExpiry.Before(expiresAt) {
+			expiresAt = ttlExpiry
+		}
+	}
+
+	e := &cacheEntry{value: value, expiresAt: expiresAt}
+	if !expiresAt.IsZero() {
+		e.refreshAt = expiresAt.Add(-k.opts.refreshAhead)
+		if e.refreshAt.Before(now) {
+			e.refreshAt = now
+		}
+	}
+	return e
+}
+
+// retryBackoff returns the delay before retrying after the given number of
+// failed refreshes.
+func retryBackoff(failures int) time.Duration {
+	backoff := minRetryBackoff
+	for i := 1; i < failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func (e *cacheEntry) refreshDue(now time.Time) bool {
+	return !e.refreshing && !e.refreshAt.IsZero() && !now.Before(e.refreshAt)
+}
+
+func sameSecret(a, b *SecureString) bool {
+	va, _ := a.Get()
+	vb, _ := b.Get()
+	return bytes.Equal(va, vb)
+}
+
+// subscribersLocked returns the subscribers to notify, if changed is true.
+func (k *CachingKeystore) subscribersLocked(changed bool) []func(string) {
+	if !changed {
+		return nil
+	}
+	subscribers := make([]func(string), 0, len(k.subscribers))
+	for _, fn := range k.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	return subscribers
+}
+
+// OnChange registers fn to be called with the key of a cached secret that
+// changed or was removed when it was refreshed. fn is called from the
+// goroutine refreshing the secret and should not block. The returned
+// function unregisters fn.
+func (k *CachingKeystore) OnChange(fn func(key string)) func() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := k.nextID
+	k.nextID++
+	k.subscribers[id] = fn
+	return func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		delete(k.subscribers, id)
+	}
+}
+
+// Invalidate removes the secret from the cache, it is fetched again on its
+// next retrieval.
+func (k *CachingKeystore) Invalidate(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.entries, key)
+}
+
+// Run refreshes the cached secrets that are due until ctx is cancelled, such
+// that rotated secrets are detected and notified even if they are not
+// retrieved. Without Run, secrets are only refreshed when retrieved.
+func (k *CachingKeystore) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.opts.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.refreshDue()
+		}
+	}
+}
+
+// refreshDue starts refreshing all cached secrets that are due or expired.
+func (k *CachingKeystore) refreshDue() {
+	now := k.nowFn()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, e := range k.entries {
+		if e.refreshDue(now) {
+			e.refreshing = true
+			go k.fetch(key) //nolint:errcheck // errors are reported by retrievals after expiry
+		}
+	}
+}
+
+// GetConfig returns config.C representation of the cached secrets that have
+// not expired. Secrets that were never retrieved are not included, as a
+// DynamicKeystore cannot list its secrets.
+func (k *CachingKeystore) GetConfig() (*config.C, error) {
+	now := k.nowFn()
+	k.mu.Lock()
+	configHash := make(map[string]interface{}, len(k.entries))
+	for key, e := range k.entries {
+		if !e.expired(now) {
+			value, _ := e.value.Get()
+			configHash[key] = string(value)
+		}
+	}
+	k.mu.Unlock()
+	return config.NewConfigFrom(configHash)
+}
+
+// IsPersisted returns true, the secrets are persisted by the source of the
+// DynamicKeystore.
+func (k *CachingKeystore) IsPersisted() bool {
+	return true
+}
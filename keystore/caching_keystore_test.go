@@ -0,0 +1,351 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretSource is a DynamicKeystore issuing secrets valid for lifetime.
+type fakeSecretSource struct {
+	mu       sync.Mutex
+	secrets  map[string]string
+	lifetime time.Duration
+	err      error
+	now      func() time.Time
+	fetches  atomic.Int32
+}
+
+func (s *fakeSecretSource) Retrieve(_ context.Context, key string) (*SecureString, time.Time, error) {
+	s.fetches.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, time.Time{}, s.err
+	}
+	value, ok := s.secrets[key]
+	if !ok {
+		return nil, time.Time{}, ErrKeyDoesntExists
+	}
+	var expiresAt time.Time
+	if s.lifetime > 0 {
+		expiresAt = s.now().Add(s.lifetime)
+	}
+	return NewSecureString([]byte(value)), expiresAt, nil
+}
+
+func (s *fakeSecretSource) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[key] = value
+}
+
+func (s *fakeSecretSource) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, key)
+}
+
+func (s *fakeSecretSource) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// fakeClock is a mockable clock safe for use by background refreshes.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCachingKeystore(lifetime time.Duration, opts ...CacheOption) (*CachingKeystore, *fakeSecretSource, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	source := &fakeSecretSource{
+		secrets:  map[string]string{"token": "first"},
+		lifetime: lifetime,
+		now:      clock.Now,
+	}
+	ks := NewCachingKeystore(source, opts...)
+	ks.nowFn = clock.Now
+	return ks, source, clock
+}
+
+// waitForChange returns a channel receiving the keys notified by ks.
+func waitForChange(t *testing.T, ks *CachingKeystore) <-chan string {
+	t.Helper()
+	changes := make(chan string, 10)
+	t.Cleanup(ks.OnChange(func(key string) { changes <- key }))
+	return changes
+}
+
+func receiveChange(t *testing.T, changes <-chan string) string {
+	t.Helper()
+	select {
+	case key := <-changes:
+		return key
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no change notification received")
+		return ""
+	}
+}
+
+func TestCachingKeystoreTTL(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(0, WithTTL(time.Minute), WithRefreshAhead(0))
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.set("token", "second")
+	clock.Advance(59 * time.Second)
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 1, source.fetches.Load())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "second", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 2, source.fetches.Load())
+}
+
+func TestCachingKeystoreSecretExpiry(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(10*time.Second, WithTTL(time.Minute), WithRefreshAhead(0))
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.set("token", "second")
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "second", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 2, source.fetches.Load())
+
+	t.Run("zero TTL caches secrets without expiry", func(t *testing.T) {
+		ks, source, clock := newTestCachingKeystore(0, WithTTL(0))
+		assert.Equal(t, "first", retrieveString(t, ks, "token"))
+		clock.Advance(24 * time.Hour)
+		assert.Equal(t, "first", retrieveString(t, ks, "token"))
+		assert.EqualValues(t, 1, source.fetches.Load())
+	})
+}
+
+func TestCachingKeystoreRefreshAhead(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute, WithRefreshAhead(10*time.Second))
+	changes := waitForChange(t, ks)
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.set("token", "second")
+
+	clock.Advance(50 * time.Second)
+	// the cached secret is returned while it is refreshed in the background
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	assert.Equal(t, "token", receiveChange(t, changes))
+	assert.Equal(t, "second", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 2, source.fetches.Load())
+}
+
+func TestCachingKeystoreNoChange(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute, WithRefreshAhead(0))
+	notified := atomic.Bool{}
+	unregister := ks.OnChange(func(string) { notified.Store(true) })
+	defer unregister()
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	clock.Advance(time.Minute)
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 2, source.fetches.Load())
+	assert.False(t, notified.Load())
+}
+
+func TestCachingKeystoreMissingKey(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute, WithRefreshAhead(0))
+	changes := waitForChange(t, ks)
+
+	_, err := ks.Retrieve("missing")
+	assert.ErrorIs(t, err, ErrKeyDoesntExists)
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.remove("token")
+	clock.Advance(time.Minute)
+	_, err = ks.Retrieve("token")
+	assert.ErrorIs(t, err, ErrKeyDoesntExists)
+	assert.Equal(t, "token", receiveChange(t, changes))
+}
+
+func TestCachingKeystoreFetchError(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute, WithRefreshAhead(30*time.Second))
+	backendErr := errors.New("backend unavailable")
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.fail(backendErr)
+
+	// failing refreshes keep the cached secret until it expires
+	clock.Advance(40 * time.Second)
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	assert.Eventually(t, func() bool { return source.fetches.Load() == 2 }, 5*time.Second, time.Millisecond)
+
+	clock.Advance(20 * time.Second)
+	_, err := ks.Retrieve("token")
+	assert.ErrorIs(t, err, backendErr)
+}
+
+func TestCachingKeystoreRetryBackoff(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute, WithRefreshAhead(30*time.Second))
+	backendErr := errors.New("backend unavailable")
+	failures := func() int {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		return ks.entries["token"].failures
+	}
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.fail(backendErr)
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	require.Eventually(t, func() bool { return failures() == 1 }, 5*time.Second, time.Millisecond)
+
+	// neither retrievals nor the refresh loop retry before the backoff elapsed
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "first", retrieveString(t, ks, "token"))
+		ks.refreshDue()
+	}
+	assert.EqualValues(t, 2, source.fetches.Load())
+
+	clock.Advance(time.Second)
+	ks.refreshDue()
+	require.Eventually(t, func() bool { return failures() == 2 }, 5*time.Second, time.Millisecond)
+
+	// the backoff doubles
+	clock.Advance(time.Second)
+	ks.refreshDue()
+	assert.EqualValues(t, 3, source.fetches.Load())
+	clock.Advance(time.Second)
+	ks.refreshDue()
+	require.Eventually(t, func() bool { return failures() == 3 }, 5*time.Second, time.Millisecond)
+	assert.EqualValues(t, 4, source.fetches.Load())
+
+	// expired secrets report the failure until the retry
+	clock.Advance(30 * time.Second)
+	for i := 0; i < 10; i++ {
+		_, err := ks.Retrieve("token")
+		assert.ErrorIs(t, err, backendErr)
+	}
+	assert.EqualValues(t, 5, source.fetches.Load())
+
+	source.fail(nil)
+	clock.Advance(maxRetryBackoff)
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	assert.EqualValues(t, 6, source.fetches.Load())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryBackoff(1))
+	assert.Equal(t, 2*time.Second, retryBackoff(2))
+	assert.Equal(t, 32*time.Second, retryBackoff(6))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(7))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(1000))
+}
+
+func TestCachingKeystoreConcurrentFetches(t *testing.T) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	source := DynamicKeystoreFunc(func(ctx context.Context, key string) (*SecureString, time.Time, error) {
+		fetches.Add(1)
+		<-release
+		return NewSecureString([]byte("value")), time.Time{}, nil
+	})
+	ks := NewCachingKeystore(source)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "value", retrieveString(t, ks, "token"))
+		}()
+	}
+	assert.Eventually(t, func() bool { return fetches.Load() == 1 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, fetches.Load())
+}
+
+func TestCachingKeystoreRun(t *testing.T) {
+	ks, source, clock := newTestCachingKeystore(time.Minute,
+		WithRefreshAhead(10*time.Second), WithRefreshInterval(time.Millisecond))
+	changes := waitForChange(t, ks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ks.Run(ctx)
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.set("token", "second")
+	clock.Advance(50 * time.Second)
+
+	// rotated secrets are notified without being retrieved
+	assert.Equal(t, "token", receiveChange(t, changes))
+	assert.Equal(t, "second", retrieveString(t, ks, "token"))
+}
+
+func TestCachingKeystoreInvalidate(t *testing.T) {
+	ks, source, _ := newTestCachingKeystore(0)
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	source.set("token", "second")
+	ks.Invalidate("token")
+	assert.Equal(t, "second", retrieveString(t, ks, "token"))
+}
+
+func TestCachingKeystoreResolver(t *testing.T) {
+	ks, _, clock := newTestCachingKeystore(time.Minute)
+
+	secrets, err := ks.GetConfig()
+	require.NoError(t, err)
+	assert.Empty(t, secrets.FlattenedKeys())
+
+	assert.Equal(t, "first", retrieveString(t, ks, "token"))
+	secrets, err = ks.GetConfig()
+	require.NoError(t, err)
+	token, err := secrets.String("token", -1)
+	require.NoError(t, err)
+	assert.Equal(t, "first", token)
+
+	clock.Advance(time.Minute)
+	secrets, err = ks.GetConfig()
+	require.NoError(t, err)
+	assert.Empty(t, secrets.FlattenedKeys())
+
+	resolver := ResolverWrap(ks)
+	value, _, err := resolver("token")
+	require.NoError(t, err)
+	assert.Equal(t, "first", value)
+}
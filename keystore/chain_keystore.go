@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// ChainKeystore queries several keystores in order, a key is retrieved from
+// the first keystore holding it. Wrapped with ResolverWrap, it resolves
+// references to secrets from whichever source provides them.
+type ChainKeystore struct {
+	keystores []Keystore
+}
+
+// NewChainKeystore returns a keystore querying the keystores in the given
+// order. Nil keystores are ignored.
+func NewChainKeystore(keystores ...Keystore) Keystore {
+	chain := &ChainKeystore{}
+	for _, ks := range keystores {
+		if ks != nil {
+			chain.keystores = append(chain.keystores, ks)
+		}
+	}
+	return chain
+}
+
+// Retrieve returns the secret of the first keystore holding the key. Errors
+// other than ErrKeyDoesntExists stop the search, such that a failing
+// keystore does not silently resolve the key from the next one.
+func (k *ChainKeystore) Retrieve(key string) (*SecureString, error) {
+	for _, ks := range k.keystores {
+		secret, err := ks.Retrieve(key)
+		if err == nil {
+			return secret, nil
+		}
+		if !errors.Is(err, ErrKeyDoesntExists) {
+			return nil, err
+		}
+	}
+	return nil, ErrKeyDoesntExists
+}
+
+// List returns the keys of all listing keystores of the chain, without
+// duplicates.
+func (k *ChainKeystore) List() ([]string, error) {
+	seen := map[string]struct{}{}
+	var keys []string
+	for _, ks := range k.keystores {
+		listing, ok := ks.(ListingKeystore)
+		if !ok {
+			continue
+		}
+		list, err := listing.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range list {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// GetConfig returns the merged configuration of all keystores. Keys present
+// in several keystores have the value of the first one.
+func (k *ChainKeystore) GetConfig() (*config.C, error) {
+	merged := config.NewConfig()
+	for i := len(k.keystores) - 1; i >= 0; i-- {
+		cfg, err := k.keystores[i].GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.Merge(cfg); err != nil {
+			return nil, fmt.Errorf("cannot merge the keystore configurations: %w", err)
+		}
+	}
+	return merged, nil
+}
+
+// IsPersisted returns true if any keystore of the chain is persisted.
+func (k *ChainKeystore) IsPersisted() bool {
+	for _, ks := range k.keystores {
+		if ks.IsPersisted() {
+			return true
+		}
+	}
+	return false
+}
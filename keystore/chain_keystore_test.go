@@ -0,0 +1,115 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	ucfg "github.com/elastic/go-ucfg"
+)
+
+// failingKeystore fails to retrieve any key.
+type failingKeystore struct{}
+
+func (failingKeystore) Retrieve(string) (*SecureString, error) {
+	return nil, errors.New("backend unavailable")
+}
+func (failingKeystore) GetConfig() (*config.C, error) { return nil, errors.New("backend unavailable") }
+func (failingKeystore) IsPersisted() bool             { return false }
+
+func newTestChain(t *testing.T) Keystore {
+	t.Helper()
+	t.Setenv("KEYSTORE_CHAIN_TEST_password", "from env")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "password"), []byte("from directory"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("from directory"), 0o600))
+
+	env, err := NewEnvKeystore("KEYSTORE_CHAIN_TEST_")
+	require.NoError(t, err)
+	directory, err := NewDirectoryKeystore(dir)
+	require.NoError(t, err)
+	file := CreateAnExistingKeystore(GetTemporaryKeystoreFile(t))
+
+	return NewChainKeystore(env, nil, directory, file)
+}
+
+func TestChainKeystore(t *testing.T) {
+	ks := newTestChain(t)
+	assert.True(t, ks.IsPersisted())
+
+	assert.Equal(t, "from env", retrieveString(t, ks, "password"), "the first keystore holding the key wins")
+	assert.Equal(t, "from directory", retrieveString(t, ks, "token"))
+	assert.Equal(t, string(secretValue), retrieveString(t, ks, keyValue))
+	_, err := ks.Retrieve("missing")
+	assert.ErrorIs(t, err, ErrKeyDoesntExists)
+
+	keys, err := ks.(ListingKeystore).List()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"password", "token", keyValue}, keys)
+
+	cfg, err := ks.GetConfig()
+	require.NoError(t, err)
+	password, err := cfg.String("password", -1)
+	require.NoError(t, err)
+	assert.Equal(t, "from env", password)
+	esPassword, err := cfg.String(keyValue, -1)
+	require.NoError(t, err)
+	assert.Equal(t, string(secretValue), esPassword)
+}
+
+func TestChainKeystoreResolver(t *testing.T) {
+	resolver := ResolverWrap(newTestChain(t))
+
+	v, _, err := resolver("token")
+	require.NoError(t, err)
+	assert.Equal(t, "from directory", v)
+
+	_, _, err = resolver("missing")
+	assert.Equal(t, ucfg.ErrMissing, err)
+
+	cfg, err := ucfg.NewFrom(map[string]interface{}{"password": "${password}"}, ucfg.VarExp)
+	require.NoError(t, err)
+	var out struct {
+		Password string `config:"password"`
+	}
+	require.NoError(t, cfg.Unpack(&out, ucfg.Resolve(resolver), ucfg.VarExp))
+	assert.Equal(t, "from env", out.Password)
+}
+
+func TestChainKeystoreFailingBackend(t *testing.T) {
+	file := CreateAnExistingKeystore(GetTemporaryKeystoreFile(t))
+	ks := NewChainKeystore(failingKeystore{}, file)
+
+	_, err := ks.Retrieve(keyValue)
+	assert.ErrorContains(t, err, "backend unavailable", "a failing keystore must not be skipped")
+	_, err = ks.GetConfig()
+	assert.Error(t, err)
+	assert.True(t, ks.IsPersisted())
+
+	keys, err := ks.(ListingKeystore).List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{keyValue}, keys, "keystores not listing keys are skipped")
+}
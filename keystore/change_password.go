@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ChangePassword re-encrypts the keystore with a new password and persists
+// it, together with any unsaved change. The old password must match the
+// password the keystore was opened with, otherwise ErrInvalidPassword is
+// returned. The keystore file is replaced atomically using
+// file.SafeFileRotate: if saving fails or is interrupted, the keystore file
+// keeps the old password. On error, the keystore keeps the old password as well.
+func (k *FileKeystore) ChangePassword(oldPassword, newPassword *SecureString) error {
+	if oldPassword == nil || newPassword == nil {
+		return errors.New("the old and new keystore passwords must be set")
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	current, _ := k.password.Get()
+	old, _ := oldPassword.Get()
+	if subtle.ConstantTimeCompare(current, old) != 1 {
+		return ErrInvalidPassword
+	}
+
+	previousPassword, previousDirty := k.password, k.dirty
+	k.password = newPassword
+	k.dirty = true
+	if err := k.doSave(true); err != nil {
+		k.password, k.dirty = previousPassword, previousDirty
+		return fmt.Errorf("cannot re-encrypt the keystore with the new password: %w", err)
+	}
+	return nil
+}
+
+// ChangeKeystorePassword opens the existing keystore file at path with the
+// old password and re-encrypts it with the new password, see
+// FileKeystore.ChangePassword. It is intended for command line tools
+// managing keystores: if the old password is wrong, the returned error
+// matches ErrInvalidPassword with errors.Is.
+func ChangeKeystorePassword(path string, oldPassword, newPassword *SecureString, strictPerms bool) error {
+	if oldPassword == nil || newPassword == nil {
+		return errors.New("the old and new keystore passwords must be set")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("cannot find the keystore at '%s': %w", path, err)
+	}
+
+	keystore, err := NewFileKeystoreWithPasswordAndStrictPerms(path, oldPassword, strictPerms)
+	if err != nil {
+		return fmt.Errorf("cannot open the keystore at '%s': %w", path, err)
+	}
+	return keystore.(*FileKeystore).ChangePassword(oldPassword, newPassword)
+}
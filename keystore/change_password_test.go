@@ -0,0 +1,181 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldPassword = NewSecureString([]byte("old password"))
+	newPassword = NewSecureString([]byte("new password"))
+)
+
+// createKeystoreWithPassword creates a keystore holding keyValue, encrypted
+// with the password. The cheapest KDF is used to keep the tests fast.
+func createKeystoreWithPassword(t *testing.T, path string, password *SecureString) *FileKeystore {
+	t.Helper()
+	ks, err := NewFileKeystoreWithPassword(path, password)
+	require.NoError(t, err)
+	require.NoError(t, ks.(*FileKeystore).SetKDF(KDFParams{Algorithm: PBKDF2SHA512, Iterations: iterationsCount}))
+	require.NoError(t, ks.(*FileKeystore).Store(keyValue, secretValue))
+	require.NoError(t, ks.(*FileKeystore).Save())
+	return ks.(*FileKeystore)
+}
+
+// assertOpensWithPassword asserts the keystore file can be decrypted with
+// the password only and holds keyValue.
+func assertOpensWithPassword(t *testing.T, path string, password, wrongPassword *SecureString) {
+	t.Helper()
+	ks, err := NewFileKeystoreWithPassword(path, password)
+	require.NoError(t, err)
+	secret, err := ks.Retrieve(keyValue)
+	require.NoError(t, err)
+	value, _ := secret.Get()
+	assert.Equal(t, secretValue, value)
+
+	_, err = NewFileKeystoreWithPassword(path, wrongPassword)
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func mockRotateFile(t *testing.T, fn func(path, tempfile string) error) {
+	t.Helper()
+	rotate := rotateFile
+	rotateFile = fn
+	t.Cleanup(func() { rotateFile = rotate })
+}
+
+func TestChangePassword(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks := createKeystoreWithPassword(t, path, oldPassword)
+
+	require.NoError(t, ks.ChangePassword(oldPassword, newPassword))
+	assertOpensWithPassword(t, path, newPassword, oldPassword)
+
+	// the in memory keystore uses the new password for later changes
+	require.NoError(t, ks.Store("other", []byte("value")))
+	require.NoError(t, ks.Save())
+	assertOpensWithPassword(t, path, newPassword, oldPassword)
+}
+
+func TestChangePasswordWrongOldPassword(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks := createKeystoreWithPassword(t, path, oldPassword)
+
+	err := ks.ChangePassword(NewSecureString([]byte("guess")), newPassword)
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+	assertOpensWithPassword(t, path, oldPassword, newPassword)
+}
+
+func TestChangePasswordPersistsUnsavedChanges(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks := createKeystoreWithPassword(t, path, oldPassword)
+	require.NoError(t, ks.Store("unsaved", []byte("value")))
+
+	require.NoError(t, ks.ChangePassword(oldPassword, newPassword))
+	reopened, err := NewFileKeystoreWithPassword(path, newPassword)
+	require.NoError(t, err)
+	_, err = reopened.Retrieve("unsaved")
+	assert.NoError(t, err)
+}
+
+func TestChangePasswordCrashSafety(t *testing.T) {
+	t.Run("rotation fails", func(t *testing.T) {
+		path := GetTemporaryKeystoreFile(t)
+		ks := createKeystoreWithPassword(t, path, oldPassword)
+		mockRotateFile(t, func(string, string) error { return errors.New("disk failure") })
+
+		err := ks.ChangePassword(oldPassword, newPassword)
+		assert.ErrorContains(t, err, "disk failure")
+		assertOpensWithPassword(t, path, oldPassword, newPassword)
+		assert.NoFileExists(t, path+".tmp")
+
+		// the keystore keeps the old password
+		assert.ErrorIs(t, ks.ChangePassword(newPassword, oldPassword), ErrInvalidPassword)
+	})
+
+	t.Run("writing fails", func(t *testing.T) {
+		path := GetTemporaryKeystoreFile(t)
+		ks := createKeystoreWithPassword(t, path, oldPassword)
+		// the temporary file cannot be created
+		require.NoError(t, os.Mkdir(path+".tmp", 0o700))
+
+		assert.Error(t, ks.ChangePassword(oldPassword, newPassword))
+		assertOpensWithPassword(t, path, oldPassword, newPassword)
+	})
+
+	t.Run("crash before rotation", func(t *testing.T) {
+		path := GetTemporaryKeystoreFile(t)
+		ks := createKeystoreWithPassword(t, path, oldPassword)
+		safeFileRotate := rotateFile
+
+		// keep the temporary file re-encrypted with the new password, as
+		// left behind by a crash before it replaced the keystore
+		var leftover []byte
+		mockRotateFile(t, func(_, tempfile string) error {
+			var err error
+			leftover, err = os.ReadFile(tempfile)
+			require.NoError(t, err)
+			return errors.New("crash")
+		})
+		require.Error(t, ks.ChangePassword(oldPassword, newPassword))
+		require.NoError(t, os.WriteFile(path+".tmp", leftover, filePermission))
+		assertOpensWithPassword(t, path, oldPassword, newPassword)
+
+		rotateFile = safeFileRotate
+		require.NoError(t, ks.ChangePassword(oldPassword, newPassword))
+		assertOpensWithPassword(t, path, newPassword, oldPassword)
+		assert.NoFileExists(t, path+".tmp")
+	})
+
+	t.Run("crash during rotation", func(t *testing.T) {
+		path := GetTemporaryKeystoreFile(t)
+		ks := createKeystoreWithPassword(t, path, oldPassword)
+
+		// file.SafeFileRotate moves the keystore to <path>.old before
+		// moving the new file in place on Windows
+		mockRotateFile(t, func(path, _ string) error {
+			require.NoError(t, os.Rename(path, path+".old"))
+			return errors.New("crash")
+		})
+		require.Error(t, ks.ChangePassword(oldPassword, newPassword))
+		require.NoFileExists(t, path)
+
+		assertOpensWithPassword(t, path, oldPassword, newPassword)
+		assert.NoFileExists(t, path+".old")
+	})
+}
+
+func TestChangeKeystorePassword(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	createKeystoreWithPassword(t, path, oldPassword)
+
+	err := ChangeKeystorePassword(path, NewSecureString([]byte("guess")), newPassword, false)
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	require.NoError(t, ChangeKeystorePassword(path, oldPassword, newPassword, false))
+	assertOpensWithPassword(t, path, newPassword, oldPassword)
+
+	err = ChangeKeystorePassword(path+".missing", oldPassword, newPassword, false)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -20,6 +20,10 @@ package keystore
 // Config Define keystore configurable options
 type Config struct {
 	Path string `config:"path"`
+
+	// KDF configures the key derivation function used when the keystore is
+	// saved. If not set, the KDF of the existing keystore is kept.
+	KDF *KDFParams `config:"kdf"`
 }
 
 func defaultConfig() Config {
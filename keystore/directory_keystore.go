@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// DirectoryKeystore retrieves secrets from the files of a directory, one
+// secret per file named after its key. This is the layout of Kubernetes
+// secret volumes and Docker secrets mounted in /run/secrets. Files are read
+// on every retrieval, such that updated secrets are picked up. A single
+// trailing newline is removed from the content of a file.
+type DirectoryKeystore struct {
+	Path string
+}
+
+// NewDirectoryKeystore returns a keystore retrieving secrets from the files
+// in the directory at path.
+func NewDirectoryKeystore(path string) (Keystore, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the keystore directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("the keystore path '%s' is not a directory", path)
+	}
+	return &DirectoryKeystore{Path: path}, nil
+}
+
+// Retrieve returns the content of the file named key.
+func (k *DirectoryKeystore) Retrieve(key string) (*SecureString, error) {
+	if !validDirectoryKey(key) {
+		return nil, ErrKeyDoesntExists
+	}
+
+	value, err := k.read(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecureString(value), nil
+}
+
+func (k *DirectoryKeystore) read(key string) ([]byte, error) {
+	path := filepath.Join(k.Path, key)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrKeyDoesntExists
+		}
+		return nil, fmt.Errorf("cannot read the secret '%s': %w", key, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, ErrKeyDoesntExists
+	}
+
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the secret '%s': %w", key, err)
+	}
+	value = bytes.TrimSuffix(value, []byte("\n"))
+	value = bytes.TrimSuffix(value, []byte("\r"))
+	return value, nil
+}
+
+// validDirectoryKey returns true if the key names a file of the directory.
+// Hidden files are excluded, they include the data directories and links
+// maintained by Kubernetes.
+func validDirectoryKey(key string) bool {
+	return key != "" &&
+		!strings.HasPrefix(key, ".") &&
+		!strings.ContainsAny(key, `/\`) &&
+		filepath.Base(key) == key
+}
+
+// List returns the names of the regular files of the directory, following
+// symbolic links.
+func (k *DirectoryKeystore) List() ([]string, error) {
+	entries, err := os.ReadDir(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the keystore directory: %w", err)
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if !validDirectoryKey(entry.Name()) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(k.Path, entry.Name()))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		keys = append(keys, entry.Name())
+	}
+	return keys, nil
+}
+
+// GetConfig returns config.C representation of the key / secret pair to be merged with other
+// loaded configuration.
+func (k *DirectoryKeystore) GetConfig() (*config.C, error) {
+	keys, err := k.List()
+	if err != nil {
+		return nil, err
+	}
+
+	configHash := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		value, err := k.read(key)
+		if err != nil {
+			return nil, err
+		}
+		configHash[key] = string(value)
+	}
+	return config.NewConfigFrom(configHash)
+}
+
+// IsPersisted returns true if the directory exists.
+func (k *DirectoryKeystore) IsPersisted() bool {
+	info, err := os.Stat(k.Path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func retrieveString(t *testing.T, ks Keystore, key string) string {
+	t.Helper()
+	secret, err := ks.Retrieve(key)
+	require.NoError(t, err)
+	value, err := secret.Get()
+	require.NoError(t, err)
+	return string(value)
+}
+
+func TestDirectoryKeystore(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "es_password"), []byte("s3cret\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api_key"), []byte("key\r\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "multiline"), []byte("line 1\nline 2\n\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("hidden"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(t.TempDir(), "outside"), []byte("outside"), 0o600))
+
+	ks, err := NewDirectoryKeystore(dir)
+	require.NoError(t, err)
+	assert.True(t, ks.IsPersisted())
+
+	assert.Equal(t, "s3cret", retrieveString(t, ks, "es_password"))
+	assert.Equal(t, "key", retrieveString(t, ks, "api_key"))
+	assert.Equal(t, "line 1\nline 2\n", retrieveString(t, ks, "multiline"), "only one trailing newline is removed")
+
+	for _, key := range []string{"missing", ".hidden", "subdir", "", "../outside", "subdir/../es_password", "."} {
+		_, err := ks.Retrieve(key)
+		assert.ErrorIs(t, err, ErrKeyDoesntExists, "key %q", key)
+	}
+
+	// updated secrets are picked up
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "es_password"), []byte("rotated"), 0o600))
+	assert.Equal(t, "rotated", retrieveString(t, ks, "es_password"))
+
+	listing, err := AsListingKeystore(ks)
+	require.NoError(t, err)
+	keys, err := listing.List()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"es_password", "api_key", "multiline"}, keys)
+
+	cfg, err := ks.GetConfig()
+	require.NoError(t, err)
+	apiKey, err := cfg.String("api_key", -1)
+	require.NoError(t, err)
+	assert.Equal(t, "key", apiKey)
+}
+
+func TestDirectoryKeystoreKubernetesLayout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on Windows")
+	}
+
+	// Kubernetes mounts the secrets in a timestamped directory, linked by
+	// ..data, and links each key to ..data/<key>
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..2024_01_01_00_00_00.000000000")
+	require.NoError(t, os.Mkdir(data, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "token"), []byte("abc"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Base(data), filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "token"), filepath.Join(dir, "token")))
+
+	ks, err := NewDirectoryKeystore(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", retrieveString(t, ks, "token"))
+
+	keys, err := ks.(*DirectoryKeystore).List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token"}, keys)
+}
+
+func TestNewDirectoryKeystoreErrors(t *testing.T) {
+	_, err := NewDirectoryKeystore(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = NewDirectoryKeystore(file)
+	assert.ErrorContains(t, err, "is not a directory")
+}
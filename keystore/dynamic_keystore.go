@@ -0,0 +1,43 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"context"
+	"time"
+)
+
+// DynamicKeystore retrieves secrets on demand from their source, for example
+// a remote secret manager issuing short-lived tokens. Secrets may expire and
+// change between retrievals. Use a CachingKeystore to use a DynamicKeystore
+// where a Keystore is expected.
+type DynamicKeystore interface {
+	// Retrieve fetches the current value of the secret and the time it
+	// expires at. A zero time means the secret does not expire. If the
+	// secret does not exist, ErrKeyDoesntExists is returned.
+	Retrieve(ctx context.Context, key string) (*SecureString, time.Time, error)
+}
+
+// DynamicKeystoreFunc is an adapter to allow the use of ordinary functions as
+// DynamicKeystore.
+type DynamicKeystoreFunc func(ctx context.Context, key string) (*SecureString, time.Time, error)
+
+// Retrieve calls f(ctx, key).
+func (f DynamicKeystoreFunc) Retrieve(ctx context.Context, key string) (*SecureString, time.Time, error) {
+	return f(ctx, key)
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+// EnvKeystore retrieves secrets from the environment variables starting with
+// a prefix. The key of a secret is the name of its variable without the
+// prefix, e.g. the variable SECRET_ES_PASSWORD holds the key ES_PASSWORD of a
+// keystore with the prefix SECRET_. Variables are read on every retrieval.
+type EnvKeystore struct {
+	prefix string
+}
+
+// NewEnvKeystore returns a keystore retrieving secrets from the environment
+// variables starting with prefix. The prefix must not be empty, such that
+// only the variables meant to hold secrets are exposed.
+func NewEnvKeystore(prefix string) (Keystore, error) {
+	if prefix == "" {
+		return nil, errors.New("the environment variable prefix of the keystore must not be empty")
+	}
+	return &EnvKeystore{prefix: prefix}, nil
+}
+
+// Retrieve returns the value of the variable named prefix + key.
+func (k *EnvKeystore) Retrieve(key string) (*SecureString, error) {
+	if key == "" {
+		return nil, ErrKeyDoesntExists
+	}
+	value, ok := os.LookupEnv(k.prefix + key)
+	if !ok {
+		return nil, ErrKeyDoesntExists
+	}
+	return NewSecureString([]byte(value)), nil
+}
+
+// List returns the keys of all variables starting with the prefix.
+func (k *EnvKeystore) List() ([]string, error) {
+	var keys []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if key, ok := strings.CutPrefix(name, k.prefix); ok && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// GetConfig returns config.C representation of the key / secret pair to be merged with other
+// loaded configuration.
+func (k *EnvKeystore) GetConfig() (*config.C, error) {
+	configHash := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if key, ok := strings.CutPrefix(name, k.prefix); ok && key != "" {
+			configHash[key] = value
+		}
+	}
+	return config.NewConfigFrom(configHash)
+}
+
+// IsPersisted returns true if any variable starts with the prefix.
+func (k *EnvKeystore) IsPersisted() bool {
+	keys, _ := k.List()
+	return len(keys) > 0
+}
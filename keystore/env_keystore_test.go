@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvKeystore(t *testing.T) {
+	t.Setenv("KEYSTORE_TEST_ES_PASSWORD", "s3cret")
+	t.Setenv("KEYSTORE_TEST_EMPTY", "")
+	t.Setenv("ES_USERNAME", "elastic")
+
+	ks, err := NewEnvKeystore("KEYSTORE_TEST_")
+	require.NoError(t, err)
+	assert.True(t, ks.IsPersisted())
+
+	secret, err := ks.Retrieve("ES_PASSWORD")
+	require.NoError(t, err)
+	value, _ := secret.Get()
+	assert.Equal(t, []byte("s3cret"), value)
+
+	secret, err = ks.Retrieve("EMPTY")
+	require.NoError(t, err)
+	value, _ = secret.Get()
+	assert.Empty(t, value)
+
+	_, err = ks.Retrieve("ES_USERNAME")
+	assert.ErrorIs(t, err, ErrKeyDoesntExists, "variables without prefix must not be exposed")
+	_, err = ks.Retrieve("")
+	assert.ErrorIs(t, err, ErrKeyDoesntExists)
+
+	listing, err := AsListingKeystore(ks)
+	require.NoError(t, err)
+	keys, err := listing.List()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ES_PASSWORD", "EMPTY"}, keys)
+
+	cfg, err := ks.GetConfig()
+	require.NoError(t, err)
+	password, err := cfg.String("ES_PASSWORD", -1)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+	assert.False(t, cfg.HasField("ES_USERNAME"))
+
+	_, err = AsWritableKeystore(ks)
+	assert.ErrorIs(t, err, ErrNotWritable)
+}
+
+func TestEnvKeystoreEmptyPrefix(t *testing.T) {
+	_, err := NewEnvKeystore("")
+	assert.Error(t, err)
+
+	ks, err := NewEnvKeystore("KEYSTORE_TEST_UNSET_")
+	require.NoError(t, err)
+	assert.False(t, ks.IsPersisted())
+}
@@ -33,14 +33,22 @@ import (
 	"github.com/elastic/elastic-agent-libs/file"
 )
 
+// Version of the keystore format, will be added at the beginning of the file.
+// The format records the parameters of the key derivation function, see
+// encryptV3.
+var version = []byte("v3")
+
 const (
 	filePermission = 0600
 
 	// Encryption Related constants
-	iVLength        = 12
-	saltLength      = 64
+	iVLength   = 12
+	saltLength = 64
+	keyLength  = 32
+
+	// iterationsCount is the number of PBKDF2 iterations of the legacy
+	// formats.
 	iterationsCount = 10000
-	keyLength       = 32
 )
 
 // Packager defines a keystore that we can read the raw bytes and be packaged in an artifact.
@@ -57,6 +65,9 @@ type FileKeystore struct {
 	dirty         bool
 	password      *SecureString
 	isStrictPerms bool
+
+	// kdf derives the encryption key when saving the keystore.
+	kdf KDFParams
 }
 
 // Allow the original SecureString type to be correctly serialized to json.
@@ -83,7 +94,15 @@ func Factory(c *config.C, defaultPath string, strictPerms bool) (Keystore, error
 	}
 
 	keystore, err := NewFileKeystoreWithStrictPerms(cfg.Path, strictPerms)
-	return keystore, err
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KDF != nil {
+		if err := keystore.(*FileKeystore).SetKDF(*cfg.KDF); err != nil {
+			return nil, fmt.Errorf("invalid keystore kdf configuration: %w", err)
+		}
+	}
+	return keystore, nil
 }
 
 // NewFileKeystore returns an new File based keystore or an error, currently users cannot set their
@@ -125,6 +144,32 @@ func NewFileKeystoreWithPassword(keystoreFile string, password *SecureString) (K
 	return NewFileKeystoreWithPasswordAndStrictPerms(keystoreFile, password, false)
 }
 
+// SetKDF sets the key derivation function used to encrypt the keystore. If
+// the parameters differ from the ones of the keystore file, the keystore is
+// re-encrypted on the next Save.
+func (k *FileKeystore) SetKDF(params KDFParams) error {
+	params = params.withDefaults()
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	if params != k.kdf {
+		k.kdf = params
+		k.dirty = true
+	}
+	return nil
+}
+
+// KDF returns the parameters of the key derivation function used to encrypt
+// the keystore.
+func (k *FileKeystore) KDF() KDFParams {
+	k.RLock()
+	defer k.RUnlock()
+	return k.kdf
+}
+
 // Retrieve return a SecureString instance that will contains both the key and the secret.
 func (k *FileKeystore) Retrieve(key string) (*SecureString, error) {
 	k.RLock()
@@ -157,7 +202,8 @@ func (k *FileKeystore) Delete(key string) error {
 	return nil
 }
 
-// Save persists the in memory data to disk if needed.
+// Save persists the in memory data to disk if needed. Keystores read from
+// a file in a legacy format are always persisted in the current format.
 func (k *FileKeystore) Save() error {
 	k.Lock()
 	err := k.doSave(true)
@@ -233,7 +279,7 @@ func (k *FileKeystore) doSave(override bool) error {
 		return fmt.Errorf("cannot serialize the keystore before saving it to disk: %w", err)
 	}
 
-	encrypted, err := k.encrypt(w)
+	encrypted, err := k.encryptV3(w.Bytes())
 	if err != nil {
 		return fmt.Errorf("cannot encrypt the keystore: %w", err)
 	}
@@ -250,14 +296,13 @@ func (k *FileKeystore) doSave(override bool) error {
 		return fmt.Errorf("cannot open file to save the keystore to '%s', error: %w", k.Path, err)
 	}
 
-	_, _ = f.Write(version)
-	base64Encoder := base64.NewEncoder(base64.StdEncoding, f)
-	_, _ = io.Copy(base64Encoder, encrypted)
-	base64Encoder.Close()
-	_ = f.Sync()
-	f.Close()
+	// the existing keystore must only be replaced by a complete file
+	if err := writeKeystoreFile(f, encrypted); err != nil {
+		os.Remove(temporaryPath)
+		return fmt.Errorf("cannot write the keystore to '%s', error: %w", temporaryPath, err)
+	}
 
-	err = file.SafeFileRotate(k.Path, temporaryPath)
+	err = rotateFile(k.Path, temporaryPath)
 	if err != nil {
 		os.Remove(temporaryPath)
 		return fmt.Errorf("cannot replace the existing keystore, with the new keystore file at '%s', error: %w", k.Path, err)
@@ -268,6 +313,47 @@ func (k *FileKeystore) doSave(override bool) error {
 	return nil
 }
 
+// rotateFile atomically replaces the keystore file, it can be mocked in
+// tests to simulate crashes.
+var rotateFile = file.SafeFileRotate
+
+// writeKeystoreFile writes the version and the encoded encrypted keystore to
+// f and closes it.
+func writeKeystoreFile(f *os.File, encrypted []byte) error {
+	_, err := f.Write(version)
+	if err == nil {
+		base64Encoder := base64.NewEncoder(base64.StdEncoding, f)
+		_, err = base64Encoder.Write(encrypted)
+		if err == nil {
+			err = base64Encoder.Close()
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// recoverInterruptedRotation restores the keystore file if saving it was
+// interrupted after file.SafeFileRotate moved it to <path>.old, as done on
+// Windows, but before the new file was moved in place.
+func (k *FileKeystore) recoverInterruptedRotation() error {
+	old := k.Path + ".old"
+	if _, err := os.Stat(k.Path); !os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(old); err != nil {
+		return nil
+	}
+	if err := os.Rename(old, k.Path); err != nil {
+		return fmt.Errorf("cannot restore the keystore from '%s', error: %w", old, err)
+	}
+	return nil
+}
+
 func (k *FileKeystore) loadRaw() ([]byte, error) {
 	raw, err := os.ReadFile(k.Path)
 	if err != nil {
@@ -283,9 +369,13 @@ func (k *FileKeystore) loadRaw() ([]byte, error) {
 		}
 	}
 
+	if len(raw) < len(version) {
+		return nil, fmt.Errorf("corrupt or empty keystore")
+	}
+
 	v := raw[0:len(version)]
-	if !bytes.Equal(v, version) {
-		return nil, fmt.Errorf("keystore format doesn't match expected version: '%s' got '%s'", version, v)
+	if !bytes.Equal(v, version) && !bytes.Equal(v, legacyVersion) {
+		return nil, fmt.Errorf("keystore format doesn't match expected version: '%s' or '%s' got '%s'", version, legacyVersion, v)
 	}
 
 	if len(raw) <= len(version) {
@@ -299,17 +389,30 @@ func (k *FileKeystore) load() error {
 	k.Lock()
 	defer k.Unlock()
 
+	if err := k.recoverInterruptedRotation(); err != nil {
+		return err
+	}
+
 	raw, err := k.loadRaw()
 	if err != nil {
 		return err
 	}
 
 	if len(raw) == 0 {
+		k.kdf = DefaultKDFParams()
 		return nil
 	}
 
 	base64Decoder := base64.NewDecoder(base64.StdEncoding, bytes.NewReader(raw[len(version):]))
-	plaintext, err := k.decrypt(base64Decoder)
+	var plaintext io.Reader
+	if bytes.Equal(raw[0:len(version)], version) {
+		plaintext, err = k.decryptV3(base64Decoder)
+	} else {
+		// persist the keystore in the current format on the next save
+		k.kdf = DefaultKDFParams()
+		k.dirty = true
+		plaintext, err = k.decryptLegacy(base64Decoder)
+	}
 	if err != nil {
 		return fmt.Errorf("could not decrypt the keystore: %w", err)
 	}
@@ -317,6 +420,79 @@ func (k *FileKeystore) load() error {
 	return jsonDecoder.Decode(&k.secrets)
 }
 
+// encryptV3 encrypts the data with AES-256-GCM, using a key derived from the
+// password with the configured KDF. The output format is
+// KDF HEADER|NONCE|PAYLOAD, where the KDF header holds the algorithm, its
+// parameters and the salt, and is authenticated with the payload.
+func (k *FileKeystore) encryptV3(data []byte) ([]byte, error) {
+	salt, err := randomBytes(saltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	password, _ := k.password.Get()
+	key, err := k.kdf.deriveKey(password, salt)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password, error: %w", err)
+	}
+
+	header := marshalKDFHeader(k.kdf, salt)
+	encrypted, err := sealGCM(key, data, v3AdditionalData(header))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, encrypted...), nil
+}
+
+// decryptV3 decrypts the output of encryptV3 and sets the KDF of the
+// keystore to the one recorded in the header.
+func (k *FileKeystore) decryptV3(reader io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read all the data from the encrypted file, error: %w", err)
+	}
+
+	params, salt, n, err := unmarshalKDFHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	password, _ := k.password.Get()
+	key, err := params.deriveKey(password, salt)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password, error: %w", err)
+	}
+
+	header := data[:n]
+	decrypted, err := openGCM(key, data[n:], v3AdditionalData(header))
+	if err != nil {
+		return nil, err
+	}
+
+	k.kdf = params
+	return bytes.NewReader(decrypted), nil
+}
+
+// v3AdditionalData returns the data authenticated with the payload: the
+// version and the KDF header.
+func v3AdditionalData(header []byte) []byte {
+	ad := make([]byte, 0, len(version)+len(header))
+	ad = append(ad, version...)
+	return append(ad, header...)
+}
+
+// decryptError reports that the keystore data could not be authenticated,
+// because the password is wrong or the keystore is corrupted.
+type decryptError struct {
+	err error
+}
+
+func (e *decryptError) Error() string { return e.err.Error() }
+func (e *decryptError) Unwrap() error { return e.err }
+
+// Is reports the error as ErrInvalidPassword.
+func (e *decryptError) Is(target error) bool { return target == ErrInvalidPassword }
+
 // checkPermission enforces permission on the keystore file itself, the file should have strict
 // permission (0600) and the keystore should refuses to start if its not the case.
 func (k *FileKeystore) checkPermissions(f string) error {
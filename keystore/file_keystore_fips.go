@@ -27,51 +27,46 @@ import (
 	"io"
 )
 
-// Version of the keystore format, will be added at the beginning of the file.
-var version = []byte("v2")
-
-// Encrypt the data payload using a derived keys and the AES-256-GCM algorithm.
-func (k *FileKeystore) encrypt(reader io.Reader) (io.Reader, error) {
-	salt, err := randomBytes(saltLength)
+// legacyVersion of the keystore format written before v3, using a fixed
+// number of PBKDF2 iterations. It can still be read.
+var legacyVersion = []byte("v2")
+
+// sealGCM encrypts the data with AES-256-GCM using a random nonce. The
+// output is NONCE|CIPHERTEXT.
+func sealGCM(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
 	}
 
-	// Stretch the user provided key
-	password, _ := k.password.Get()
-	passwordBytes, err := k.hashPassword(string(password), salt)
+	aesgcm, err := cipher.NewGCMWithRandomNonce(block)
 	if err != nil {
-		return nil, fmt.Errorf("could not hash password, error: %w", err)
+		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
 	}
+	return aesgcm.Seal(nil, nil, data, additionalData), nil
+}
 
-	// Select AES-256: because len(passwordBytes) == 32 bytes
-	block, err := aes.NewCipher(passwordBytes)
+// openGCM decrypts the output of sealGCM.
+func openGCM(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
+		return nil, fmt.Errorf("could not create the keystore cipher to decrypt the data: %w", err)
 	}
 
 	aesgcm, err := cipher.NewGCMWithRandomNonce(block)
 	if err != nil {
-		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
+		return nil, fmt.Errorf("could not create the keystore cipher to decrypt the data: %w", err)
 	}
 
-	data, err := io.ReadAll(reader)
+	decodedBytes, err := aesgcm.Open(nil, nil, data, additionalData)
 	if err != nil {
-		return nil, fmt.Errorf("could not read unencrypted data, error: %w", err)
+		return nil, fmt.Errorf("could not decrypt keystore data: %w", &decryptError{err})
 	}
-
-	encodedBytes := aesgcm.Seal(nil, nil, data, nil)
-
-	// Generate the payload with all the additional information required to decrypt the
-	// output format of the document: VERSION|SALT|PAYLOAD
-	buf := bytes.NewBuffer(salt)
-	buf.Write(encodedBytes)
-
-	return buf, nil
+	return decodedBytes, nil
 }
 
-// should receive an io.reader...
-func (k *FileKeystore) decrypt(reader io.Reader) (io.Reader, error) {
+// decryptLegacy decrypts the payload of the v2 format: SALT|PAYLOAD.
+func (k *FileKeystore) decryptLegacy(reader io.Reader) (io.Reader, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("could not read all the data from the encrypted file, error: %w", err)
@@ -103,7 +98,7 @@ func (k *FileKeystore) decrypt(reader io.Reader) (io.Reader, error) {
 
 	decodedBytes, err := aesgcm.Open(nil, nil, encodedBytes, nil)
 	if err != nil {
-		return nil, fmt.Errorf("could not decrypt keystore data: %w", err)
+		return nil, fmt.Errorf("could not decrypt keystore data: %w", &decryptError{err})
 	}
 
 	return bytes.NewReader(decodedBytes), nil
@@ -43,7 +43,7 @@ func TestShouldRaiseAndErrorWhenVersionDontMatch(t *testing.T) {
 
 	_, err = NewFileKeystoreWithPassword(temporaryPath, NewSecureString([]byte("")))
 	if assert.Error(t, err, "Expect version check error") {
-		assert.Equal(t, err, fmt.Errorf("keystore format doesn't match expected version: 'v2' got 'v1'"))
+		assert.Equal(t, err, fmt.Errorf("keystore format doesn't match expected version: 'v3' or 'v2' got 'v1'"))
 	}
 }
 
@@ -62,3 +62,17 @@ func TestFailsToOpenV1(t *testing.T) {
 	_, err := NewFileKeystoreWithPassword(filepath.Join("testdata", "keystore.v1"), NewSecureString([]byte("")))
 	require.Error(t, err)
 }
+
+func TestFailsToOpenV3Argon2id(t *testing.T) {
+	_, err := NewFileKeystoreWithPassword(filepath.Join("testdata", "keystore.v3"), NewSecureString([]byte("")))
+	require.ErrorContains(t, err, "not allowed in FIPS mode")
+}
+
+func TestOnlyApprovedKDF(t *testing.T) {
+	assert.Equal(t, KDFParams{Algorithm: PBKDF2SHA512, Iterations: 210000}, DefaultKDFParams())
+
+	path := GetTemporaryKeystoreFile(t)
+	ks := CreateAnExistingKeystore(path).(*FileKeystore)
+	assert.ErrorContains(t, ks.SetKDF(KDFParams{Algorithm: Argon2id}), "not allowed in FIPS mode")
+	assert.ErrorContains(t, ks.SetKDF(KDFParams{Algorithm: Scrypt}), "not allowed in FIPS mode")
+}
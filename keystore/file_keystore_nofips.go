@@ -27,60 +27,54 @@ import (
 	"io"
 )
 
-// Version of the keystore format, will be added at the beginning of the file.
-var version = []byte("v1")
-
-// Encrypt the data payload using a derived keys and the AES-256-GCM algorithm.
-func (k *FileKeystore) encrypt(reader io.Reader) (io.Reader, error) {
-	// randomly generate the salt and the initialization vector, this information will be saved
-	// on disk in the file as part of the header
-	iv, err := randomBytes(iVLength)
-
+// legacyVersion of the keystore format written before v3, using a fixed
+// number of PBKDF2 iterations. It can still be read.
+var legacyVersion = []byte("v1")
+
+// sealGCM encrypts the data with AES-256-GCM using a random nonce. The
+// output is NONCE|CIPHERTEXT.
+func sealGCM(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
 	}
 
-	salt, err := randomBytes(saltLength)
+	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
 	}
 
-	// Stretch the user provided key
-	password, _ := k.password.Get()
-	passwordBytes, err := k.hashPassword(string(password), salt)
+	nonce, err := randomBytes(iVLength)
 	if err != nil {
-		return nil, fmt.Errorf("could not hash password, error: %w", err)
+		return nil, err
 	}
+	return aesgcm.Seal(nonce, nonce, data, additionalData), nil
+}
 
-	// Select AES-256: because len(passwordBytes) == 32 bytes
-	block, err := aes.NewCipher(passwordBytes)
+// openGCM decrypts the output of sealGCM.
+func openGCM(key, data, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
+		return nil, fmt.Errorf("could not create the keystore cipher to decrypt the data: %w", err)
 	}
 
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, fmt.Errorf("could not create the keystore cipher to encrypt, error: %w", err)
+		return nil, fmt.Errorf("could not create the keystore cipher to decrypt the data: %w", err)
 	}
 
-	data, err := io.ReadAll(reader)
+	if len(data) < iVLength {
+		return nil, fmt.Errorf("missing information in the file for decrypting the keystore")
+	}
+	decodedBytes, err := aesgcm.Open(nil, data[:iVLength], data[iVLength:], additionalData)
 	if err != nil {
-		return nil, fmt.Errorf("could not read unencrypted data, error: %w", err)
+		return nil, fmt.Errorf("could not decrypt keystore data: %w", &decryptError{err})
 	}
-
-	encodedBytes := aesgcm.Seal(nil, iv, data, nil)
-
-	// Generate the payload with all the additional information required to decrypt the
-	// output format of the document: VERSION|SALT|IV|PAYLOAD
-	buf := bytes.NewBuffer(salt)
-	buf.Write(iv)
-	buf.Write(encodedBytes)
-
-	return buf, nil
+	return decodedBytes, nil
 }
 
-// should receive an io.reader...
-func (k *FileKeystore) decrypt(reader io.Reader) (io.Reader, error) {
+// decryptLegacy decrypts the payload of the v1 format: SALT|IV|PAYLOAD.
+func (k *FileKeystore) decryptLegacy(reader io.Reader) (io.Reader, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("could not read all the data from the encrypted file, error: %w", err)
@@ -113,7 +107,7 @@ func (k *FileKeystore) decrypt(reader io.Reader) (io.Reader, error) {
 
 	decodedBytes, err := aesgcm.Open(nil, iv, encodedBytes, nil)
 	if err != nil {
-		return nil, fmt.Errorf("could not decrypt keystore data: %w", err)
+		return nil, fmt.Errorf("could not decrypt keystore data: %w", &decryptError{err})
 	}
 
 	return bytes.NewReader(decodedBytes), nil
@@ -44,7 +44,7 @@ func TestShouldRaiseAndErrorWhenVersionDontMatch(t *testing.T) {
 
 	_, err = NewFileKeystoreWithPassword(temporaryPath, NewSecureString([]byte("")))
 	if assert.Error(t, err, "Expect version check error") {
-		assert.Equal(t, err, fmt.Errorf("keystore format doesn't match expected version: 'v1' got 'v2'"))
+		assert.Equal(t, err, fmt.Errorf("keystore format doesn't match expected version: 'v3' or 'v1' got 'v2'"))
 	}
 }
 
@@ -63,3 +63,63 @@ func TestFailsToOpenV2(t *testing.T) {
 	_, err := NewFileKeystoreWithPassword(filepath.Join("testdata", "keystore.v2"), NewSecureString([]byte("")))
 	require.Error(t, err)
 }
+
+func TestOpensV3(t *testing.T) {
+	ks, err := NewFileKeystoreWithPassword(filepath.Join("testdata", "keystore.v3"), NewSecureString([]byte("")))
+	require.NoError(t, err)
+	assert.Equal(t, KDFParams{Algorithm: Argon2id, Iterations: 1, Memory: 1024, Parallelism: 1}, ks.(*FileKeystore).KDF())
+	secret, err := ks.Retrieve("key")
+	require.NoError(t, err)
+	value, _ := secret.Get()
+	require.Equal(t, []byte("value"), value)
+}
+
+func TestMemoryHardKDF(t *testing.T) {
+	for name, params := range map[string]KDFParams{
+		"argon2id": {Algorithm: Argon2id, Iterations: 1, Memory: 1024, Parallelism: 2},
+		"scrypt":   {Algorithm: Scrypt, Cost: 1024, BlockSize: 8, Parallelism: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := GetTemporaryKeystoreFile(t)
+			ks := CreateAnExistingKeystore(path).(*FileKeystore)
+			require.NoError(t, ks.SetKDF(params))
+			require.NoError(t, ks.Save())
+
+			reopened, err := NewFileKeystore(path)
+			require.NoError(t, err)
+			assert.Equal(t, params, reopened.(*FileKeystore).KDF())
+			_, err = reopened.Retrieve(keyValue)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	assert.Equal(t, KDFParams{Algorithm: Argon2id, Iterations: 3, Memory: 64 * 1024, Parallelism: 4}, DefaultKDFParams())
+
+	for name, tc := range map[string]struct {
+		params KDFParams
+		err    string
+	}{
+		"defaults":               {params: KDFParams{Algorithm: Scrypt}},
+		"argon2id memory":        {params: KDFParams{Algorithm: Argon2id, Memory: 8, Parallelism: 4}, err: "argon2id memory"},
+		"argon2id parallelism":   {params: KDFParams{Algorithm: Argon2id, Parallelism: 256}, err: "argon2id parallelism"},
+		"scrypt cost":            {params: KDFParams{Algorithm: Scrypt, Cost: 1000}, err: "power of two"},
+		"scrypt memory":          {params: KDFParams{Algorithm: Scrypt, Cost: 1 << 30, BlockSize: 8}, err: "scrypt memory"},
+		"unknown algorithm":      {params: KDFParams{Algorithm: 42}, err: "unknown kdf algorithm"},
+		"pbkdf2 low iterations":  {params: KDFParams{Algorithm: PBKDF2SHA512, Iterations: 1000}, err: "between 10000 and"},
+		"pbkdf2 high iterations": {params: KDFParams{Algorithm: PBKDF2SHA512, Iterations: 600000}},
+		"pbkdf2 max iterations":  {params: KDFParams{Algorithm: PBKDF2SHA512, Iterations: 1 << 30}, err: "pbkdf2 iterations"},
+		"argon2id iterations":    {params: KDFParams{Algorithm: Argon2id, Iterations: 1000}, err: "argon2id iterations"},
+		"scrypt parallelism":     {params: KDFParams{Algorithm: Scrypt, Parallelism: 1000}, err: "scrypt parallelism"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// KDFAlgorithm is the key derivation function used to derive the encryption
+// key of the keystore from its password.
+type KDFAlgorithm uint8
+
+const (
+	// PBKDF2SHA512 is PBKDF2 with HMAC-SHA512. It is the only algorithm
+	// approved in FIPS builds.
+	PBKDF2SHA512 KDFAlgorithm = iota + 1
+
+	// Argon2id is the memory-hard Argon2id function defined in RFC 9106.
+	Argon2id
+
+	// Scrypt is the memory-hard scrypt function defined in RFC 7914.
+	Scrypt
+)
+
+var kdfAlgorithmNames = map[KDFAlgorithm]string{
+	PBKDF2SHA512: "pbkdf2-sha512",
+	Argon2id:     "argon2id",
+	Scrypt:       "scrypt",
+}
+
+func (a KDFAlgorithm) String() string {
+	if name, ok := kdfAlgorithmNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("kdf(%d)", uint8(a))
+}
+
+// Unpack unpacks the name of the algorithm.
+func (a *KDFAlgorithm) Unpack(in interface{}) error {
+	s, ok := in.(string)
+	if !ok {
+		return fmt.Errorf("kdf algorithm must be a string, got %T", in)
+	}
+	for algorithm, name := range kdfAlgorithmNames {
+		if strings.EqualFold(name, s) {
+			*a = algorithm
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown kdf algorithm '%s'", s)
+}
+
+// KDFParams configures the key derivation function. The parameters are
+// recorded in the header of the keystore file, such that they can be changed
+// without breaking existing keystores. Parameters left to zero are set to
+// the defaults of the algorithm.
+type KDFParams struct {
+	Algorithm KDFAlgorithm `config:"algorithm"`
+
+	// Iterations is the number of iterations of PBKDF2, or the number of
+	// passes over the memory of Argon2id.
+	Iterations uint32 `config:"iterations"`
+
+	// Memory is the memory used by Argon2id in KiB.
+	Memory uint32 `config:"memory"`
+
+	// Parallelism is the number of threads of Argon2id, or the
+	// parallelization parameter p of scrypt.
+	Parallelism uint32 `config:"parallelism"`
+
+	// Cost is the CPU and memory cost parameter N of scrypt, a power of two.
+	Cost uint32 `config:"cost"`
+
+	// BlockSize is the block size parameter r of scrypt.
+	BlockSize uint32 `config:"block_size"`
+}
+
+// Limits protecting from exhausting the memory, or from deriving the key for
+// hours, when reading corrupted or crafted keystores.
+const (
+	maxArgon2idMemory     = 4 << 20 // KiB, 4 GiB
+	maxScryptMemory       = 4 << 30 // bytes, 4 GiB
+	maxPBKDF2Iterations   = 10_000_000
+	maxArgon2idIterations = 64
+	maxScryptParallelism  = 64
+)
+
+// DefaultKDFParams returns the parameters used for new keystores.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{Algorithm: defaultKDFAlgorithm}.withDefaults()
+}
+
+// withDefaults sets the parameters left to zero to the defaults of the
+// algorithm.
+func (p KDFParams) withDefaults() KDFParams {
+	if p.Algorithm == 0 {
+		p.Algorithm = defaultKDFAlgorithm
+	}
+
+	setDefault := func(v *uint32, d uint32) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	switch p.Algorithm {
+	case PBKDF2SHA512:
+		// OWASP recommendation for PBKDF2-HMAC-SHA512
+		setDefault(&p.Iterations, 210000)
+	case Argon2id:
+		// second recommended option of RFC 9106
+		setDefault(&p.Iterations, 3)
+		setDefault(&p.Memory, 64*1024)
+		setDefault(&p.Parallelism, 4)
+	case Scrypt:
+		setDefault(&p.Cost, 1<<17)
+		setDefault(&p.BlockSize, 8)
+		setDefault(&p.Parallelism, 1)
+	}
+	return p
+}
+
+// Validate checks the parameters are valid for the algorithm and that the
+// algorithm is allowed in this build.
+func (p *KDFParams) Validate() error {
+	return p.withDefaults().validate()
+}
+
+func (p KDFParams) validate() error {
+	if !kdfApproved(p.Algorithm) {
+		return fmt.Errorf("kdf algorithm %s is not allowed in FIPS mode", p.Algorithm)
+	}
+
+	switch p.Algorithm {
+	case PBKDF2SHA512:
+		if p.Iterations < iterationsCount || p.Iterations > maxPBKDF2Iterations {
+			return fmt.Errorf("pbkdf2 iterations must be between %d and %d, got %d", iterationsCount, maxPBKDF2Iterations, p.Iterations)
+		}
+	case Argon2id:
+		if p.Iterations < 1 || p.Iterations > maxArgon2idIterations {
+			return fmt.Errorf("argon2id iterations must be between 1 and %d, got %d", maxArgon2idIterations, p.Iterations)
+		}
+		if p.Parallelism < 1 || p.Parallelism > 255 {
+			return fmt.Errorf("argon2id parallelism must be between 1 and 255, got %d", p.Parallelism)
+		}
+		if p.Memory < 8*p.Parallelism || p.Memory > maxArgon2idMemory {
+			return fmt.Errorf("argon2id memory must be between %d and %d KiB, got %d", 8*p.Parallelism, maxArgon2idMemory, p.Memory)
+		}
+	case Scrypt:
+		if p.Cost < 2 || bits.OnesCount32(p.Cost) != 1 {
+			return fmt.Errorf("scrypt cost must be a power of two greater than 1, got %d", p.Cost)
+		}
+		if p.BlockSize < 1 || p.Parallelism < 1 {
+			return errors.New("scrypt block size and parallelism must be at least 1")
+		}
+		if p.Parallelism > maxScryptParallelism {
+			return fmt.Errorf("scrypt parallelism must be at most %d, got %d", maxScryptParallelism, p.Parallelism)
+		}
+		if uint64(p.BlockSize)*uint64(p.Parallelism) >= 1<<30 {
+			return errors.New("scrypt block size and parallelism are too large")
+		}
+		if 128*uint64(p.Cost)*uint64(p.BlockSize) > maxScryptMemory {
+			return fmt.Errorf("scrypt memory exceeds %d bytes", maxScryptMemory)
+		}
+	default:
+		return fmt.Errorf("unknown kdf algorithm %s", p.Algorithm)
+	}
+	return nil
+}
+
+// deriveKey derives the encryption key from the password.
+func (p KDFParams) deriveKey(password, salt []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.Algorithm == PBKDF2SHA512 {
+		return pbkdf2Key(string(password), salt, int(p.Iterations))
+	}
+	return deriveMemoryHardKey(p, password, salt)
+}
+
+// kdfHeaderLength is the length of the encoded KDF header without the salt:
+// algorithm (1 byte), 3 parameters (4 bytes each), salt length (1 byte).
+const kdfHeaderLength = 1 + 3*4 + 1
+
+// marshalKDFHeader encodes the algorithm, its parameters and the salt.
+func marshalKDFHeader(p KDFParams, salt []byte) []byte {
+	var params [3]uint32
+	switch p.Algorithm {
+	case PBKDF2SHA512:
+		params = [3]uint32{p.Iterations, 0, 0}
+	case Argon2id:
+		params = [3]uint32{p.Iterations, p.Memory, p.Parallelism}
+	case Scrypt:
+		params = [3]uint32{p.Cost, p.BlockSize, p.Parallelism}
+	}
+
+	header := make([]byte, 0, kdfHeaderLength+len(salt))
+	header = append(header, byte(p.Algorithm))
+	for _, v := range params {
+		header = binary.BigEndian.AppendUint32(header, v)
+	}
+	header = append(header, byte(len(salt)))
+	return append(header, salt...)
+}
+
+// unmarshalKDFHeader decodes the header written by marshalKDFHeader. It
+// returns the parameters, the salt and the length of the header.
+func unmarshalKDFHeader(data []byte) (KDFParams, []byte, int, error) {
+	if len(data) < kdfHeaderLength {
+		return KDFParams{}, nil, 0, errors.New("missing kdf header")
+	}
+	saltLen := int(data[kdfHeaderLength-1])
+	if saltLen == 0 || len(data) < kdfHeaderLength+saltLen {
+		return KDFParams{}, nil, 0, errors.New("missing kdf salt")
+	}
+
+	p := KDFParams{Algorithm: KDFAlgorithm(data[0])}
+	v1 := binary.BigEndian.Uint32(data[1:5])
+	v2 := binary.BigEndian.Uint32(data[5:9])
+	v3 := binary.BigEndian.Uint32(data[9:13])
+	switch p.Algorithm {
+	case PBKDF2SHA512:
+		p.Iterations = v1
+	case Argon2id:
+		p.Iterations, p.Memory, p.Parallelism = v1, v2, v3
+	case Scrypt:
+		p.Cost, p.BlockSize, p.Parallelism = v1, v2, v3
+	}
+	if err := p.validate(); err != nil {
+		return KDFParams{}, nil, 0, fmt.Errorf("invalid kdf parameters: %w", err)
+	}
+
+	n := kdfHeaderLength + saltLen
+	return p, data[kdfHeaderLength:n], n, nil
+}
@@ -0,0 +1,37 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build go1.24 && requirefips
+
+package keystore
+
+import (
+	"fmt"
+)
+
+// defaultKDFAlgorithm is used for new keystores.
+const defaultKDFAlgorithm = PBKDF2SHA512
+
+// kdfApproved returns true if the algorithm can be used in this build. Only
+// PBKDF2 is approved by FIPS 140.
+func kdfApproved(a KDFAlgorithm) bool {
+	return a == PBKDF2SHA512
+}
+
+func deriveMemoryHardKey(p KDFParams, _, _ []byte) ([]byte, error) {
+	return nil, fmt.Errorf("kdf algorithm %s is not allowed in FIPS mode", p.Algorithm)
+}
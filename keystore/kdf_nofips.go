@@ -0,0 +1,46 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !requirefips
+
+package keystore
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// defaultKDFAlgorithm is used for new keystores.
+const defaultKDFAlgorithm = Argon2id
+
+// kdfApproved returns true if the algorithm can be used in this build.
+func kdfApproved(KDFAlgorithm) bool {
+	return true
+}
+
+// deriveMemoryHardKey derives the key with Argon2id or scrypt.
+func deriveMemoryHardKey(p KDFParams, password, salt []byte) ([]byte, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		return argon2.IDKey(password, salt, p.Iterations, p.Memory, uint8(p.Parallelism), keyLength), nil
+	case Scrypt:
+		return scrypt.Key(password, salt, int(p.Cost), int(p.BlockSize), int(p.Parallelism), keyLength)
+	}
+	return nil, fmt.Errorf("unknown kdf algorithm %s", p.Algorithm)
+}
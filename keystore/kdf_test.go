@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestKDFHeader(t *testing.T) {
+	params := KDFParams{Algorithm: PBKDF2SHA512, Iterations: 12345}
+	salt := []byte("0123456789")
+
+	header := marshalKDFHeader(params, salt)
+	decoded, decodedSalt, n, err := unmarshalKDFHeader(append(header, "payload"...))
+	require.NoError(t, err)
+	assert.Equal(t, params, decoded)
+	assert.Equal(t, salt, decodedSalt)
+	assert.Equal(t, len(header), n)
+
+	_, _, _, err = unmarshalKDFHeader(header[:kdfHeaderLength-1])
+	assert.ErrorContains(t, err, "missing kdf header")
+	_, _, _, err = unmarshalKDFHeader(header[:kdfHeaderLength+2])
+	assert.ErrorContains(t, err, "missing kdf salt")
+
+	weak := marshalKDFHeader(KDFParams{Algorithm: PBKDF2SHA512, Iterations: 1}, salt)
+	_, _, _, err = unmarshalKDFHeader(weak)
+	assert.ErrorContains(t, err, "invalid kdf parameters")
+
+	for name, params := range map[string]KDFParams{
+		"pbkdf2 iterations":   {Algorithm: PBKDF2SHA512, Iterations: math.MaxUint32},
+		"argon2id iterations": {Algorithm: Argon2id, Iterations: math.MaxUint32, Memory: 1024, Parallelism: 1},
+		"scrypt parallelism":  {Algorithm: Scrypt, Cost: 2, BlockSize: 1, Parallelism: 1 << 20},
+	} {
+		_, _, _, err = unmarshalKDFHeader(marshalKDFHeader(params, salt))
+		assert.ErrorContains(t, err, "invalid kdf parameters", name)
+	}
+}
+
+func TestKDFAlgorithmUnpack(t *testing.T) {
+	var cfg struct {
+		KDF KDFParams `config:"kdf"`
+	}
+	c := config.MustNewConfigFrom(map[string]interface{}{
+		"kdf.algorithm":  "PBKDF2-SHA512",
+		"kdf.iterations": 20000,
+	})
+	require.NoError(t, c.Unpack(&cfg))
+	assert.Equal(t, KDFParams{Algorithm: PBKDF2SHA512, Iterations: 20000}, cfg.KDF)
+
+	c = config.MustNewConfigFrom(map[string]interface{}{"kdf.algorithm": "md5"})
+	assert.ErrorContains(t, c.Unpack(&cfg), "unknown kdf algorithm 'md5'")
+
+	c = config.MustNewConfigFrom(map[string]interface{}{
+		"kdf.algorithm":  "pbkdf2-sha512",
+		"kdf.iterations": 100,
+	})
+	assert.ErrorContains(t, c.Unpack(&cfg), "pbkdf2 iterations must be between")
+}
+
+func TestNewKeystoreUsesDefaultKDF(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	CreateAnExistingKeystore(path)
+
+	ks, err := NewFileKeystore(path)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultKDFParams(), ks.(*FileKeystore).KDF())
+	assertFileVersion(t, path, version)
+}
+
+func TestUpgradeLegacyKeystore(t *testing.T) {
+	legacy, err := os.ReadFile(filepath.Join("testdata", "keystore."+string(legacyVersion)))
+	require.NoError(t, err)
+	path := GetTemporaryKeystoreFile(t)
+	require.NoError(t, os.WriteFile(path, legacy, filePermission))
+
+	ks, err := NewFileKeystore(path)
+	require.NoError(t, err)
+	assertFileVersion(t, path, legacyVersion)
+
+	// saving without changes rewrites the keystore in the current format
+	require.NoError(t, ks.(*FileKeystore).Save())
+	assertFileVersion(t, path, version)
+
+	ks, err = NewFileKeystore(path)
+	require.NoError(t, err)
+	keys, err := ks.(*FileKeystore).List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key"}, keys)
+	assert.Equal(t, DefaultKDFParams(), ks.(*FileKeystore).KDF())
+}
+
+func TestSetKDF(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks := CreateAnExistingKeystore(path).(*FileKeystore)
+
+	params := KDFParams{Algorithm: PBKDF2SHA512, Iterations: 20000}
+	require.NoError(t, ks.SetKDF(params))
+	require.NoError(t, ks.Save())
+
+	reopened, err := NewFileKeystore(path)
+	require.NoError(t, err)
+	assert.Equal(t, params, reopened.(*FileKeystore).KDF(), "the kdf of the file must be kept")
+	secret, err := reopened.Retrieve(keyValue)
+	require.NoError(t, err)
+	value, _ := secret.Get()
+	assert.Equal(t, secretValue, value)
+
+	assert.Error(t, ks.SetKDF(KDFParams{Algorithm: PBKDF2SHA512, Iterations: 1}))
+	assert.Equal(t, params, ks.KDF())
+}
+
+func TestFactoryKDF(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks, err := Factory(config.MustNewConfigFrom(map[string]interface{}{
+		"path":           path,
+		"kdf.algorithm":  "pbkdf2-sha512",
+		"kdf.iterations": 30000,
+	}), "", false)
+	require.NoError(t, err)
+	assert.Equal(t, KDFParams{Algorithm: PBKDF2SHA512, Iterations: 30000}, ks.(*FileKeystore).KDF())
+}
+
+func TestTamperedKDFHeader(t *testing.T) {
+	path := GetTemporaryKeystoreFile(t)
+	ks := CreateAnExistingKeystore(path).(*FileKeystore)
+	require.NoError(t, ks.SetKDF(KDFParams{Algorithm: PBKDF2SHA512, Iterations: 20000}))
+	require.NoError(t, ks.Save())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data, err := base64.StdEncoding.DecodeString(string(raw[len(version):]))
+	require.NoError(t, err)
+	// change a byte of the salt
+	data[kdfHeaderLength] ^= 0xff
+	tampered := append(append([]byte{}, version...), base64.StdEncoding.EncodeToString(data)...)
+	require.NoError(t, os.WriteFile(path, tampered, filePermission))
+
+	_, err = NewFileKeystore(path)
+	assert.ErrorContains(t, err, "could not decrypt")
+}
+
+func assertFileVersion(t *testing.T, path string, expected []byte) {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(raw, expected), "expected keystore version %s, got %s", expected, raw[:len(expected)])
+}
@@ -37,12 +37,15 @@ var (
 
 	// ErrNotWritable is returned when the keystore is not writable
 	ErrNotListing = errors.New("the configured keystore is not listing")
+
+	// ErrInvalidPassword is returned when the password does not match the keystore.
+	ErrInvalidPassword = errors.New("invalid keystore password")
 )
 
 // Keystore implement a way to securely saves and retrieves secrets to be used in the configuration
-// Currently all credentials are loaded upfront and are not lazy retrieved, we will eventually move
-// to that concept, so we can deal with tokens that has a limited duration or can be revoked by a
-// remote keystore.
+// The file based keystore loads all credentials upfront. Credentials that are lazy retrieved, like
+// tokens that have a limited duration or can be revoked by a remote keystore, are provided by a
+// DynamicKeystore wrapped in a CachingKeystore.
 type Keystore interface {
 	// Retrieve returns a SecureString instance of the searched key or an error.
 	Retrieve(key string) (*SecureString, error)
@@ -25,5 +25,9 @@ import (
 )
 
 func (k *FileKeystore) hashPassword(password string, salt []byte) ([]byte, error) {
-	return pbkdf2.Key(sha512.New, password, salt, iterationsCount, keyLength)
+	return pbkdf2Key(password, salt, iterationsCount)
+}
+
+func pbkdf2Key(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha512.New, password, salt, iterations, keyLength)
 }
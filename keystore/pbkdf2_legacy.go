@@ -26,5 +26,9 @@ import (
 )
 
 func (k *FileKeystore) hashPassword(password string, salt []byte) ([]byte, error) {
-	return pbkdf2.Key([]byte(password), salt, iterationsCount, keyLength, sha512.New), nil
+	return pbkdf2Key(password, salt, iterationsCount)
+}
+
+func pbkdf2Key(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key([]byte(password), salt, iterations, keyLength, sha512.New), nil
 }
@@ -59,7 +59,7 @@ type FileConfig struct {
 
 // MetricsConfig contains configuration used by the monitor to output metrics into the logstream.
 //
-// Currently these options are not used through this object in beats (as monitoring is setup elsewhere).
+// The options are consumed by the reporter in monitoring/report/log.
 type MetricsConfig struct {
 	Enabled bool          `config:"enabled"`
 	Period  time.Duration `config:"period"`
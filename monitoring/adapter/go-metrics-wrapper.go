@@ -46,6 +46,7 @@ type (
 )
 
 // goMetricsWrap tries to wrap a metric for use with monitoring package.
+// Native monitoring variables are returned unchanged.
 func goMetricsWrap(metric interface{}) (monitoring.Var, bool) {
 	switch v := metric.(type) {
 	case *metrics.StandardCounter:
@@ -62,6 +63,8 @@ func goMetricsWrap(metric interface{}) (monitoring.Var, bool) {
 		return goMetricsHistogram{v}, true
 	case *metrics.StandardMeter:
 		return goMetricsMeter{v}, true
+	case monitoring.Var:
+		return v, true
 	}
 	return nil, false
 }
@@ -69,30 +72,35 @@ func goMetricsWrap(metric interface{}) (monitoring.Var, bool) {
 func (w goMetricsCounter) wrapped() interface{} { return w.c }
 func (w goMetricsCounter) Get() int64           { return w.c.Count() }
 func (w goMetricsCounter) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnCounter()
 	vs.OnInt(w.Get())
 }
 
 func (w goMetricsGauge) wrapped() interface{} { return w.g }
 func (w goMetricsGauge) Get() int64           { return w.g.Value() }
 func (w goMetricsGauge) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnGauge()
 	vs.OnInt(w.Get())
 }
 
 func (w goMetricsGaugeFloat64) wrapped() interface{} { return w.g }
 func (w goMetricsGaugeFloat64) Get() float64         { return w.g.Value() }
 func (w goMetricsGaugeFloat64) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnGauge()
 	vs.OnFloat(w.Get())
 }
 
 func (w goMetricsFuncGauge) wrapped() interface{} { return w.g }
 func (w goMetricsFuncGauge) Get() int64           { return w.g.Value() }
 func (w goMetricsFuncGauge) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnGauge()
 	vs.OnInt(w.Get())
 }
 
 func (w goMetricsFuncGaugeFloat) wrapped() interface{} { return w.g }
 func (w goMetricsFuncGaugeFloat) Get() float64         { return w.g.Value() }
 func (w goMetricsFuncGaugeFloat) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnGauge()
 	vs.OnFloat(w.Get())
 }
 
@@ -129,5 +137,6 @@ func (w goMetricsHistogram) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
 func (w goMetricsMeter) wrapped() interface{} { return w.m }
 func (w goMetricsMeter) Get() int64           { return w.m.Count() }
 func (w goMetricsMeter) Visit(_ monitoring.Mode, vs monitoring.Visitor) {
+	vs.OnCounter()
 	vs.OnInt(w.Get())
 }
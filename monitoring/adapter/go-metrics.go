@@ -15,6 +15,13 @@
 // specific language governing permissions and limitations
 // under the License.
 
+// Package adapter registers github.com/rcrowley/go-metrics based metrics with
+// the monitoring package.
+//
+// Deprecated: go-metrics is unmaintained. Use the native monitoring.Meter,
+// monitoring.GaugeFunc and monitoring.FloatGaugeFunc types instead. Code using
+// GetGoMetrics can migrate metric by metric, as a GoMetricsRegistry also
+// accepts monitoring.Var values with GetOrRegister and Register.
 package adapter
 
 import (
@@ -39,6 +46,9 @@ import (
 //       a variable satisfying any of go-metrics interfaces is returned.
 //       It's recommended to not mix go-metrics with other metrics types
 //       in the same namespace.
+//
+// Deprecated: register monitoring.Meter, monitoring.GaugeFunc or
+// monitoring.FloatGaugeFunc with a monitoring.Registry directly.
 type GoMetricsRegistry struct {
 	mutex sync.Mutex
 
@@ -56,6 +66,8 @@ type GoMetricsRegistry struct {
 // Note: with users of go-metrics potentially removing any metric at runtime,
 //       it's recommended to have the underlying registry being generated with
 //       `monitoring.IgnorePublishExpvar`.
+//
+// Deprecated: use monitoring.Registry with the native metric types instead.
 func GetGoMetrics(parent *monitoring.Registry, name string, filters ...MetricFilter) *GoMetricsRegistry {
 	v := parent.Get(name)
 	if v == nil {
@@ -66,6 +78,8 @@ func GetGoMetrics(parent *monitoring.Registry, name string, filters ...MetricFil
 
 // NewGoMetrics creates and registers a new GoMetricsRegistry with the parent
 // registry.
+//
+// Deprecated: use monitoring.Registry with the native metric types instead.
 func NewGoMetrics(parent *monitoring.Registry, name string, filters ...MetricFilter) *GoMetricsRegistry {
 	return newGoMetrics(parent.NewRegistry(name, monitoring.IgnorePublishExpvar), filters...)
 }
@@ -126,6 +140,8 @@ func (r *GoMetricsRegistry) get(name string) interface{} {
 
 // GetOrRegister retries an existing metric via `Get` or registers a new one
 // if the metric is unknown. For lazy instantiation metric can be a function.
+// Besides go-metrics types, metric can be any monitoring.Var, e.g. a
+// monitoring.Meter, which is registered with the monitoring.Registry as is.
 func (r *GoMetricsRegistry) GetOrRegister(name string, metric interface{}) interface{} {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -153,7 +169,7 @@ func (r *GoMetricsRegistry) Register(name string, metric interface{}) error {
 }
 
 func (r *GoMetricsRegistry) doRegister(name string, metric interface{}) interface{} {
-	if v := reflect.ValueOf(metric); v.Kind() == reflect.Func {
+	if v := reflect.ValueOf(metric); v.Kind() == reflect.Func && v.Type().NumIn() == 0 {
 		metric = v.Call(nil)[0].Interface()
 	}
 
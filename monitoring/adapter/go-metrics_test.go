@@ -101,13 +101,40 @@ func TestGoMetricsAdapter(t *testing.T) {
 
 	// check Each only returns metrics not registered with monitoring.Registry
 	reg.Each(func(name string, v interface{}) {
-		if strings.HasPrefix(name, "mon") {
-			t.Errorf("metric %v should not have been reported by each", name)
-		}
+		assert.False(t, strings.HasPrefix(name, "mon"), "metric %v should not have been reported by each", name)
 	})
 	monReg.Do(monitoring.Full, func(name string, v interface{}) {
-		if !strings.HasPrefix(name, "test.mon") {
-			t.Errorf("metric %v should not have been reported by each", name)
-		}
+		assert.True(t, strings.HasPrefix(name, "test.mon"), "metric %v should not have been reported by each", name)
 	})
 }
+
+func TestGoMetricsAdapterNativeVars(t *testing.T) {
+	monReg := monitoring.NewRegistry()
+	reg := GetGoMetrics(monReg, "test", WhitelistIf(func(name string) bool {
+		return strings.HasPrefix(name, "mon")
+	}))
+
+	// go-metrics and native metrics can be mixed while migrating
+	cnt, ok := reg.GetOrRegister("mon-counter", metrics.NewCounter).(metrics.Counter)
+	require.True(t, ok)
+	cnt.Inc(1)
+
+	meter, ok := reg.GetOrRegister("mon-meter", func() interface{} {
+		return &monitoring.Meter{}
+	}).(*monitoring.Meter)
+	require.True(t, ok, "expected native meter")
+	meter.Mark(3)
+	assert.Same(t, meter, reg.Get("mon-meter"))
+
+	require.NoError(t, reg.Register("mon-gauge", monitoring.FuncVar(func(_ monitoring.Mode, vs monitoring.Visitor) {
+		vs.OnInt(7)
+	})))
+	require.NoError(t, reg.Register("ignored-meter", &monitoring.Meter{}))
+
+	snapshot := monitoring.CollectFlatSnapshot(monReg, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"test.mon-counter": 1,
+		"test.mon-meter":   3,
+		"test.mon-gauge":   7,
+	}, snapshot.Ints)
+}
@@ -0,0 +1,343 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package clusteruuid resolves the Elasticsearch cluster UUID that
+// stack-monitoring documents are attributed to.
+package clusteruuid
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+const (
+	defaultPort = 9200
+
+	// DefaultRefresh is the default interval after which a resolved cluster
+	// UUID is queried again.
+	DefaultRefresh = 5 * time.Minute
+
+	// retryInterval is the interval after which a failed query is retried.
+	// Until then the cached UUID, or the error of the failed query, is
+	// returned.
+	retryInterval = 30 * time.Second
+
+	// notAvailable is returned by Elasticsearch as cluster_uuid until the
+	// cluster has elected a master for the first time.
+	notAvailable = "_na_"
+)
+
+// ErrNotResolved is returned if the cluster UUID has not been configured
+// and could not be fetched from Elasticsearch.
+var ErrNotResolved = errors.New("cluster UUID could not be resolved")
+
+// ElasticsearchConfig holds the settings of the Elasticsearch output queried
+// for the cluster UUID. It accepts the same settings as the output, unknown
+// settings are ignored.
+type ElasticsearchConfig struct {
+	Hosts    []string          `config:"hosts" validate:"required"`
+	Protocol string            `config:"protocol"`
+	Path     string            `config:"path"`
+	Username string            `config:"username"`
+	Password string            `config:"password"`
+	APIKey   string            `config:"api_key"`
+	Headers  map[string]string `config:"headers"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+// Validate ensures only one authentication method is configured.
+func (c *ElasticsearchConfig) Validate() error {
+	if c.APIKey != "" && (c.Username != "" || c.Password != "") {
+		return errors.New("cannot set both api_key and username/password")
+	}
+	return nil
+}
+
+// Resolver returns the cluster UUID configured in monitoring.cluster_uuid or,
+// if not configured, the UUID reported by the configured Elasticsearch
+// cluster. Fetched UUIDs are cached and refreshed after the configured
+// refresh interval. Failed queries are retried after a short delay, not on
+// every call. Resolver is safe for concurrent use.
+type Resolver struct {
+	log        *logp.Logger
+	configured string
+	refresh    time.Duration
+	retry      time.Duration
+
+	client  *http.Client
+	hosts   []string
+	headers http.Header
+
+	mu        sync.Mutex
+	uuid      string
+	fetchedAt time.Time
+	retryAt   time.Time
+	lastErr   error
+	inflight  *fetchCall
+
+	// nowFn is used instead of time.Now so it can be mocked for tests.
+	nowFn func() time.Time
+}
+
+// NewResolver creates a Resolver from the monitoring settings and the
+// settings of the Elasticsearch output. esCfg may be nil if no Elasticsearch
+// output is configured, in which case only the configured cluster UUID is
+// returned. A refresh of 0 uses DefaultRefresh.
+func NewResolver(log *logp.Logger, monitoringCfg, esCfg *config.C, refresh time.Duration) (*Resolver, error) {
+	if log == nil {
+		log = logp.NewLogger("")
+	}
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+
+	configured, err := monitoring.GetClusterUUID(monitoringCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{
+		log:        log.Named("monitoring"),
+		configured: configured,
+		refresh:    refresh,
+		retry:      min(refresh, retryInterval),
+		headers:    make(http.Header),
+		nowFn:      time.Now,
+	}
+	if configured != "" || esCfg == nil {
+		return r, nil
+	}
+
+	cfg := ElasticsearchConfig{
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+	if err := esCfg.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid Elasticsearch settings: %w", err)
+	}
+
+	for _, host := range cfg.Hosts {
+		u, err := makeURL(cfg.Protocol, cfg.Path, host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Elasticsearch host %q: %w", host, err)
+		}
+		r.hosts = append(r.hosts, u)
+	}
+
+	for k, v := range cfg.Headers {
+		r.headers.Set(k, v)
+	}
+	switch {
+	case cfg.APIKey != "":
+		r.headers.Set("Authorization", "ApiKey "+base64.StdEncoding.EncodeToString([]byte(cfg.APIKey)))
+	case cfg.Username != "" || cfg.Password != "":
+		auth := base64.StdEncoding.EncodeToString([]byte(cfg.Username + ":" + cfg.Password))
+		r.headers.Set("Authorization", "Basic "+auth)
+	}
+
+	r.client, err = cfg.Transport.Client(httpcommon.WithLogger(r.log))
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// makeURL builds the URL of an Elasticsearch host. The scheme, path and port
+// default to the given scheme, the given path and defaultPort if not set in
+// host.
+func makeURL(scheme, path, host string) (string, error) {
+	if scheme == "" {
+		scheme = "http"
+	}
+	if !strings.Contains(host, "://") {
+		host = scheme + "://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, port := u.Hostname(), u.Port()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	u.Host = net.JoinHostPort(hostname, port)
+	if u.Path == "" {
+		u.Path = path
+	}
+	return u.String(), nil
+}
+
+// fetchCall is a query in progress, shared by concurrent callers.
+type fetchCall struct {
+	done chan struct{}
+	uuid string
+	err  error
+}
+
+// ClusterUUID returns the configured cluster UUID or the cached UUID fetched
+// from Elasticsearch, querying the cluster if the cache has expired. If the
+// query fails, a previously fetched UUID is returned. Concurrent callers
+// share a single query, callers having a cached UUID do not wait for it.
+func (r *Resolver) ClusterUUID(ctx context.Context) (string, error) {
+	if r.configured != "" {
+		return r.configured, nil
+	}
+	if r.client == nil {
+		return "", ErrNotResolved
+	}
+
+	r.mu.Lock()
+	now := r.nowFn()
+	if r.uuid != "" && now.Sub(r.fetchedAt) < r.refresh {
+		uuid := r.uuid
+		r.mu.Unlock()
+		return uuid, nil
+	}
+	if now.Before(r.retryAt) {
+		uuid, err := r.uuid, r.lastErr
+		r.mu.Unlock()
+		if uuid != "" {
+			return uuid, nil
+		}
+		return "", fmt.Errorf("%w: %w", ErrNotResolved, err)
+	}
+
+	call := r.inflight
+	if call == nil {
+		call = &fetchCall{done: make(chan struct{})}
+		r.inflight = call
+		r.mu.Unlock()
+		r.doFetch(ctx, call)
+		return call.uuid, call.err
+	}
+	uuid := r.uuid
+	r.mu.Unlock()
+	if uuid != "" {
+		return uuid, nil
+	}
+
+	select {
+	case <-call.done:
+		return call.uuid, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// doFetch queries the cluster UUID without holding the lock and records the
+// result in the cache and in call.
+func (r *Resolver) doFetch(ctx context.Context, call *fetchCall) {
+	defer close(call.done)
+
+	uuid, err := r.fetch(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inflight = nil
+
+	if err != nil {
+		// a cancelled caller does not indicate that the cluster is unavailable
+		if ctx.Err() == nil {
+			r.retryAt = r.nowFn().Add(r.retry)
+			r.lastErr = err
+		}
+		if r.uuid != "" {
+			r.log.Warnf("Failed to refresh cluster UUID, using cached value %s: %v", r.uuid, err)
+			call.uuid = r.uuid
+			return
+		}
+		call.err = fmt.Errorf("%w: %w", ErrNotResolved, err)
+		return
+	}
+
+	if r.uuid != "" && r.uuid != uuid {
+		r.log.Infof("Cluster UUID changed from %s to %s", r.uuid, uuid)
+	}
+	r.uuid = uuid
+	r.fetchedAt = r.nowFn()
+	r.retryAt = time.Time{}
+	r.lastErr = nil
+	call.uuid = uuid
+}
+
+// fetch queries the hosts in order and returns the first cluster UUID reported.
+func (r *Resolver) fetch(ctx context.Context) (string, error) {
+	var errs []error
+	for _, host := range r.hosts {
+		uuid, err := r.fetchFrom(ctx, host)
+		if err == nil {
+			return uuid, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", host, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+func (r *Resolver) fetchFrom(ctx context.Context, host string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range r.headers {
+		req.Header[k] = v
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := httpcommon.ReadAllWithLimit(resp, 1<<20)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var info struct {
+		ClusterUUID string `json:"cluster_uuid"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if info.ClusterUUID == "" || info.ClusterUUID == notAvailable {
+		return "", errors.New("cluster UUID not available yet")
+	}
+	return info.ClusterUUID, nil
+}
@@ -0,0 +1,247 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clusteruuid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+type fakeES struct {
+	uuid     atomic.Value
+	requests atomic.Int64
+	status   atomic.Int64
+	auth     atomic.Value
+	block    atomic.Value // chan struct{} delaying responses until closed
+}
+
+func newFakeES(t *testing.T, uuid string) (*fakeES, *httptest.Server) {
+	es := &fakeES{}
+	es.uuid.Store(uuid)
+	es.status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		es.requests.Add(1)
+		es.auth.Store(r.Header.Get("Authorization"))
+		if block, ok := es.block.Load().(chan struct{}); ok {
+			<-block
+		}
+		w.WriteHeader(int(es.status.Load()))
+		fmt.Fprintf(w, `{"name":"node","cluster_name":"test","cluster_uuid":%q}`, es.uuid.Load())
+	}))
+	t.Cleanup(srv.Close)
+	return es, srv
+}
+
+func TestResolverConfigured(t *testing.T) {
+	_, srv := newFakeES(t, "remote")
+	r, err := NewResolver(nil,
+		config.MustNewConfigFrom(map[string]interface{}{"cluster_uuid": "configured"}),
+		config.MustNewConfigFrom(map[string]interface{}{"hosts": []string{srv.URL}}),
+		0)
+	require.NoError(t, err)
+
+	uuid, err := r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "configured", uuid)
+}
+
+func TestResolverNoElasticsearch(t *testing.T) {
+	r, err := NewResolver(nil, nil, nil, 0)
+	require.NoError(t, err)
+
+	_, err = r.ClusterUUID(context.Background())
+	assert.ErrorIs(t, err, ErrNotResolved)
+}
+
+func TestResolverFetch(t *testing.T) {
+	es, srv := newFakeES(t, "abc")
+	r, err := NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{
+		"hosts":    []string{"http://127.0.0.1:1", srv.URL},
+		"username": "elastic",
+		"password": "changeme",
+	}), time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	r.nowFn = func() time.Time { return now }
+
+	uuid, err := r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", uuid)
+	assert.Equal(t, "Basic ZWxhc3RpYzpjaGFuZ2VtZQ==", es.auth.Load())
+
+	// cached
+	es.uuid.Store("def")
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", uuid)
+	assert.Equal(t, int64(1), es.requests.Load())
+
+	// refreshed after expiry
+	now = now.Add(time.Minute)
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "def", uuid)
+
+	// stale value is kept if the refresh fails
+	now = now.Add(time.Minute)
+	es.status.Store(http.StatusServiceUnavailable)
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "def", uuid)
+	requests := es.requests.Load()
+
+	// the failed refresh is not retried on every call
+	now = now.Add(retryInterval - time.Second)
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "def", uuid)
+	assert.Equal(t, requests, es.requests.Load())
+
+	now = now.Add(time.Second)
+	es.status.Store(http.StatusOK)
+	es.uuid.Store("ghi")
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ghi", uuid)
+	assert.Equal(t, requests+1, es.requests.Load())
+}
+
+func TestResolverRetry(t *testing.T) {
+	es, srv := newFakeES(t, "abc")
+	es.status.Store(http.StatusServiceUnavailable)
+	r, err := NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{
+		"hosts": []string{srv.URL},
+	}), time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	r.nowFn = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err = r.ClusterUUID(context.Background())
+		assert.ErrorIs(t, err, ErrNotResolved)
+	}
+	assert.Equal(t, int64(1), es.requests.Load(), "failed query must not be repeated before the retry interval")
+
+	es.status.Store(http.StatusOK)
+	now = now.Add(retryInterval)
+	uuid, err := r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", uuid)
+	assert.Equal(t, int64(2), es.requests.Load())
+}
+
+func TestResolverNotBlockedByRefresh(t *testing.T) {
+	es, srv := newFakeES(t, "abc")
+	r, err := NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{
+		"hosts": []string{srv.URL},
+	}), time.Minute)
+	require.NoError(t, err)
+
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	r.nowFn = func() time.Time { return time.Unix(0, now.Load()) }
+
+	uuid, err := r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", uuid)
+
+	// block the refresh until released
+	release := make(chan struct{})
+	es.block.Store(release)
+	es.uuid.Store("def")
+	now.Add(int64(time.Minute))
+
+	refreshed := make(chan string)
+	go func() {
+		uuid, _ := r.ClusterUUID(context.Background())
+		refreshed <- uuid
+	}()
+	require.Eventually(t, func() bool { return es.requests.Load() == 2 }, 5*time.Second, time.Millisecond)
+
+	// concurrent callers get the cached UUID while the refresh is in progress
+	uuid, err = r.ClusterUUID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", uuid)
+
+	close(release)
+	assert.Equal(t, "def", <-refreshed)
+}
+
+func TestResolverNotAvailable(t *testing.T) {
+	es, srv := newFakeES(t, "_na_")
+	r, err := NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{
+		"hosts":   []string{srv.URL},
+		"api_key": "id:key",
+	}), 0)
+	require.NoError(t, err)
+
+	_, err = r.ClusterUUID(context.Background())
+	assert.ErrorIs(t, err, ErrNotResolved)
+	assert.Equal(t, "ApiKey aWQ6a2V5", es.auth.Load())
+}
+
+func TestNewResolverInvalidConfig(t *testing.T) {
+	_, err := NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{
+		"hosts":    []string{"localhost"},
+		"api_key":  "id:key",
+		"username": "elastic",
+	}), 0)
+	assert.Error(t, err)
+
+	_, err = NewResolver(nil, nil, config.MustNewConfigFrom(map[string]interface{}{}), 0)
+	assert.Error(t, err, "hosts are required")
+}
+
+func TestMakeURL(t *testing.T) {
+	tests := map[string]struct {
+		scheme, path, host string
+		want               string
+	}{
+		"host only":      {host: "es.local", want: "http://es.local:9200"},
+		"host and port":  {host: "es.local:9201", want: "http://es.local:9201"},
+		"scheme":         {scheme: "https", host: "es.local", want: "https://es.local:9200"},
+		"host scheme":    {scheme: "https", host: "http://es.local", want: "http://es.local:9200"},
+		"default path":   {path: "/es", host: "es.local", want: "http://es.local:9200/es"},
+		"host path":      {path: "/es", host: "es.local/other", want: "http://es.local:9200/other"},
+		"ipv6":           {host: "[::1]", want: "http://[::1]:9200"},
+		"ipv6 with port": {host: "[::1]:9201", want: "http://[::1]:9201"},
+		"empty host":     {host: "", want: "http://localhost:9200"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := makeURL(tc.scheme, tc.path, tc.host)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := makeURL("", "", "http://es.local:port")
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"slices"
+	"strings"
+)
+
+// EventType describes the kind of structural change reported to subscribers
+// of a registry.
+type EventType uint8
+
+const (
+	// EventAdd is reported when a variable or sub-registry has been added.
+	EventAdd EventType = iota
+
+	// EventRemove is reported when a variable or sub-registry has been removed.
+	EventRemove
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "add"
+	case EventRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is passed to subscribers of a registry when its structure changes.
+type Event struct {
+	Type EventType
+
+	// Name is the `.`-separated name of the variable, relative to the
+	// registry the subscription was made on.
+	Name string
+
+	// Var is the variable or sub-registry that has been added or removed.
+	Var Var
+}
+
+// watchers holds the subscriptions and the structural generation of a registry.
+type watchers struct {
+	next uint64
+	subs map[uint64]func(Event)
+}
+
+// Subscribe registers f to be called whenever a variable or sub-registry is
+// added to or removed from the registry or any of its sub-registries.
+//
+// The callback is run synchronously by the goroutine modifying the registry,
+// after all registry locks have been released. It must not block.
+// The returned function removes the subscription.
+func (r *Registry) Subscribe(f func(Event)) (unsubscribe func()) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if r.watchers.subs == nil {
+		r.watchers.subs = map[uint64]func(Event){}
+	}
+	id := r.watchers.next
+	r.watchers.next++
+	r.watchers.subs[id] = f
+
+	return func() {
+		r.watchMu.Lock()
+		defer r.watchMu.Unlock()
+		delete(r.watchers.subs, id)
+	}
+}
+
+// Generation returns a counter that is incremented every time a variable or
+// sub-registry is added to or removed from the registry or any of its
+// sub-registries. Exporters can use it to cache the flattened structure of the
+// registry until the generation changes. Changes to values do not modify the
+// generation.
+func (r *Registry) Generation() uint64 {
+	return r.generation.Load()
+}
+
+// setParent links a sub-registry to the registry holding it, such that events
+// propagate up the tree.
+func (r *Registry) setParent(parent *Registry, key string) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	r.parent = parent
+	r.key = key
+}
+
+// unsetParent removes the link to parent, if the registry is still linked to it.
+func (r *Registry) unsetParent(parent *Registry) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	if r.parent == parent {
+		r.parent = nil
+		r.key = ""
+	}
+}
+
+// pathRegistries returns the existing sub-registries along the path of the
+// named variable, starting below r. The entry at index i belongs to names[:i+1].
+func (r *Registry) pathRegistries(names []string) []*Registry {
+	var path []*Registry
+	reg := r
+	for i := 0; i < len(names)-1; i++ {
+		reg.mu.RLock()
+		next := reg.entries[names[i]]
+		reg.mu.RUnlock()
+
+		sub, ok := next.Var.(*Registry)
+		if !ok {
+			break
+		}
+		path = append(path, sub)
+		reg = sub
+	}
+	return path
+}
+
+// publish reports a change of the named variable, given relative to r, to
+// all affected registries: r, its ancestors and the sub-registries in path.
+// The number of variables tracked per registry is updated accordingly, except
+// for the counted registries, which have been updated already.
+func (r *Registry) publish(typ EventType, names []string, v Var, path []*Registry, counted ...*Registry) {
+	delta := countVars(v)
+	if typ == EventRemove {
+		delta = -delta
+	}
+	deltaOf := func(reg *Registry) int64 {
+		if slices.Contains(counted, reg) {
+			return 0
+		}
+		return delta
+	}
+
+	for i, sub := range path {
+		sub.emit(Event{Type: typ, Name: strings.Join(names[i+1:], "."), Var: v}, deltaOf(sub))
+	}
+
+	name := strings.Join(names, ".")
+	for reg := r; reg != nil; {
+		parent, key := reg.emit(Event{Type: typ, Name: name, Var: v}, deltaOf(reg))
+		if parent != nil {
+			name = key + "." + name
+		}
+		reg = parent
+	}
+}
+
+// emit increments the generation, adjusts the variable count by delta and
+// calls all subscribers of r.
+// The registry's parent link is returned for propagating the event.
+func (r *Registry) emit(evt Event, delta int64) (parent *Registry, key string) {
+	r.generation.Add(1)
+	r.vars.Add(delta)
+
+	r.watchMu.Lock()
+	parent, key = r.parent, r.key
+	subs := make([]func(Event), 0, len(r.watchers.subs))
+	for _, f := range r.watchers.subs {
+		subs = append(subs, f)
+	}
+	r.watchMu.Unlock()
+
+	for _, f := range subs {
+		f(evt)
+	}
+	return parent, key
+}
@@ -0,0 +1,157 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) record(evt Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, evt)
+}
+
+func (r *eventRecorder) names(typ EventType) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, evt := range r.events {
+		if evt.Type == typ {
+			names = append(names, evt.Name)
+		}
+	}
+	return names
+}
+
+func TestRegistrySubscribe(t *testing.T) {
+	root := NewRegistry()
+	var rootEvents, subEvents eventRecorder
+	unsubscribe := root.Subscribe(rootEvents.record)
+
+	sub := root.NewRegistry("pipeline")
+	sub.Subscribe(subEvents.record)
+
+	v := NewInt(sub, "events.total")
+	NewInt(root, "pipeline.queue.acked")
+	NewString(root, "name")
+
+	assert.Equal(t, []string{"pipeline", "pipeline.events.total", "pipeline.queue.acked", "name"}, rootEvents.names(EventAdd))
+	assert.Equal(t, []string{"events.total", "queue.acked"}, subEvents.names(EventAdd))
+	assert.Same(t, v, subEvents.events[0].Var)
+
+	root.Remove("pipeline.events.total")
+	sub.Remove("queue.acked")
+	root.Remove("missing")
+	root.Remove("pipeline.missing")
+
+	// registries left empty are removed and reported as well
+	assert.Equal(t, []string{
+		"pipeline.events.total", "pipeline.events",
+		"pipeline.queue.acked", "pipeline.queue",
+		"pipeline",
+	}, rootEvents.names(EventRemove))
+	assert.Equal(t, []string{"events.total", "events", "queue.acked", "queue"}, subEvents.names(EventRemove))
+
+	unsubscribe()
+	NewInt(root, "after")
+	assert.NotContains(t, rootEvents.names(EventAdd), "after")
+}
+
+func TestRegistrySubscribeIntermediate(t *testing.T) {
+	root := NewRegistry()
+	NewInt(root, "a.b.first")
+
+	var events eventRecorder
+	root.GetRegistry("a.b").Subscribe(events.record)
+
+	// changes made via an ancestor are reported to registries along the path.
+	NewInt(root, "a.b.second")
+	root.Remove("a.b.first")
+	root.Remove("a.b.second")
+
+	assert.Equal(t, []string{"second"}, events.names(EventAdd))
+	assert.Equal(t, []string{"first", "second"}, events.names(EventRemove))
+	assert.Nil(t, root.GetRegistry("a"), "empty registries are removed")
+}
+
+func TestRegistryPruneEvents(t *testing.T) {
+	root := NewRegistry()
+	NewInt(root, "a.b.c.value")
+	NewInt(root, "a.other")
+	b := root.GetRegistry("a.b")
+
+	var events, aEvents eventRecorder
+	root.Subscribe(events.record)
+	root.GetRegistry("a").Subscribe(aEvents.record)
+
+	root.Remove("a.b.c.value")
+	assert.Equal(t, []string{"a.b.c.value", "a.b.c", "a.b"}, events.names(EventRemove))
+	assert.Equal(t, []string{"b.c.value", "b.c", "b"}, aEvents.names(EventRemove))
+	assert.Same(t, b, events.events[len(events.events)-1].Var)
+	assert.Nil(t, root.GetRegistry("a.b"))
+	assert.EqualValues(t, 1, root.vars.Load())
+}
+
+func TestRegistryClearEvents(t *testing.T) {
+	root := NewRegistry()
+	NewInt(root, "a")
+	NewInt(root, "b.c")
+
+	var events eventRecorder
+	root.Subscribe(events.record)
+	require.NoError(t, root.Clear())
+	assert.ElementsMatch(t, []string{"a", "b"}, events.names(EventRemove))
+}
+
+func TestRegistryGeneration(t *testing.T) {
+	root := NewRegistry()
+	sub := root.NewRegistry("sub")
+	gen := root.Generation()
+	subGen := sub.Generation()
+
+	v := NewInt(sub, "value")
+	assert.Greater(t, root.Generation(), gen)
+	assert.Greater(t, sub.Generation(), subGen)
+
+	gen = root.Generation()
+	v.Inc()
+	root.Visit(Full, newStructSnapshotVisitor())
+	assert.Equal(t, gen, root.Generation(), "value updates must not change the generation")
+
+	sub.Remove("value")
+	assert.Greater(t, root.Generation(), gen)
+
+	gen = root.Generation()
+	root.Remove("sub")
+	assert.Greater(t, root.Generation(), gen)
+
+	// detached registries do not propagate changes anymore.
+	gen = root.Generation()
+	NewInt(sub, "detached")
+	assert.Equal(t, gen, root.Generation())
+}
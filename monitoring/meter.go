@@ -0,0 +1,264 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// meterTickInterval is the interval in which the moving averages of a Meter
+// are updated.
+const meterTickInterval = 5 * time.Second
+
+// Meter counts events and tracks the 1, 5 and 15 minute exponentially-weighted
+// moving average rates, as well as the mean rate since creation, in events per
+// second. It replaces the go-metrics Meter used via the adapter package.
+//
+// The moving averages are updated lazily when the meter is marked or read, so
+// no background goroutine is required.
+//
+// To keep the shape of reported documents compatible with metrics registered via
+// the adapter package, visiting a Meter reports the event count only.
+//
+// The zero value is ready to use, its mean rate is measured from the first use.
+type Meter struct {
+	mu        sync.Mutex
+	count     int64
+	uncounted int64
+	start     time.Time
+	lastTick  time.Time
+	rates     [3]ewma
+
+	// nowFn is used instead of time.Now so it can be mocked for tests.
+	nowFn func() time.Time
+}
+
+// ewma is an exponentially-weighted moving average updated every meterTickInterval.
+type ewma struct {
+	alpha float64
+	rate  float64 // events per nanosecond
+	init  bool
+}
+
+// NewMeter creates and registers a new meter.
+//
+// Note: If the registry is configured to publish variables to expvar, the
+// variable will be available via expvars package as well, but can not be removed
+// anymore.
+func NewMeter(r *Registry, name string, opts ...Option) *Meter {
+	existingVar, r := setupMetric(r, name, opts)
+	if existingVar != nil {
+		cast, ok := existingVar.(*Meter)
+		if ok {
+			return cast
+		} else {
+			panicErr(fmt.Errorf("variable name %s was first registered as a %T, tried to register as Meter", name, existingVar))
+		}
+	}
+
+	v := newMeter(time.Now)
+	addVar(r, name, opts, v, makeExpvar(func() string {
+		return strconv.FormatInt(v.Count(), 10)
+	}))
+	return v
+}
+
+func newMeter(nowFn func() time.Time) *Meter {
+	m := &Meter{nowFn: nowFn}
+	m.init()
+	return m
+}
+
+// init sets up the meter on first use. Must be called with the lock held.
+func (m *Meter) init() {
+	if !m.start.IsZero() {
+		return
+	}
+	if m.nowFn == nil {
+		m.nowFn = time.Now
+	}
+	m.start = m.nowFn()
+	m.lastTick = m.start
+	m.rates = [3]ewma{
+		{alpha: ewmaAlpha(1 * time.Minute)},
+		{alpha: ewmaAlpha(5 * time.Minute)},
+		{alpha: ewmaAlpha(15 * time.Minute)},
+	}
+}
+
+func ewmaAlpha(window time.Duration) float64 {
+	return 1 - math.Exp(-float64(meterTickInterval)/float64(window))
+}
+
+// Mark records the occurrence of n events.
+func (m *Meter) Mark(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tick()
+	m.count += n
+	m.uncounted += n
+}
+
+// Count returns the total number of events recorded.
+func (m *Meter) Count() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.count
+}
+
+// Rate1 returns the one-minute moving average rate of events per second.
+func (m *Meter) Rate1() float64 { return m.rate(0) }
+
+// Rate5 returns the five-minute moving average rate of events per second.
+func (m *Meter) Rate5() float64 { return m.rate(1) }
+
+// Rate15 returns the fifteen-minute moving average rate of events per second.
+func (m *Meter) Rate15() float64 { return m.rate(2) }
+
+// RateMean returns the mean rate of events per second since the meter was created.
+func (m *Meter) RateMean() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+
+	elapsed := m.nowFn().Sub(m.start)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.count) / elapsed.Seconds()
+}
+
+func (m *Meter) Visit(_ Mode, vs Visitor) {
+	vs.OnCounter()
+	vs.OnInt(m.Count())
+}
+
+func (m *Meter) rate(i int) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tick()
+	return m.rates[i].rate * float64(time.Second)
+}
+
+// tick updates the moving averages for all tick intervals passed since the
+// last update. Events not yet accounted for are attributed to the first
+// interval, all following intervals are treated as idle.
+func (m *Meter) tick() {
+	m.init()
+	now := m.nowFn()
+	ticks := int64(now.Sub(m.lastTick) / meterTickInterval)
+	if ticks <= 0 {
+		return
+	}
+	m.lastTick = m.lastTick.Add(time.Duration(ticks) * meterTickInterval)
+
+	instant := float64(m.uncounted) / float64(meterTickInterval)
+	m.uncounted = 0
+	for i := range m.rates {
+		m.rates[i].update(instant, ticks-1)
+	}
+}
+
+// update applies one tick with the instant rate, followed by idle ticks.
+func (e *ewma) update(instant float64, idle int64) {
+	if e.init {
+		e.rate += e.alpha * (instant - e.rate)
+	} else {
+		e.rate = instant
+		e.init = true
+	}
+	if idle > 0 {
+		e.rate *= math.Pow(1-e.alpha, float64(idle))
+	}
+}
+
+// GaugeFunc is an integer gauge whose value is computed by a function every
+// time the gauge is read.
+type GaugeFunc struct {
+	f func() int64
+}
+
+// NewGaugeFunc creates and registers a new integer gauge backed by f.
+// The function must be safe for concurrent use.
+//
+// Note: If the registry is configured to publish variables to expvar, the
+// variable will be available via expvars package as well, but can not be removed
+// anymore.
+func NewGaugeFunc(r *Registry, name string, f func() int64, opts ...Option) *GaugeFunc {
+	existingVar, r := setupMetric(r, name, opts)
+	if existingVar != nil {
+		cast, ok := existingVar.(*GaugeFunc)
+		if ok {
+			return cast
+		} else {
+			panicErr(fmt.Errorf("variable name %s was first registered as a %T, tried to register as GaugeFunc", name, existingVar))
+		}
+	}
+
+	v := &GaugeFunc{f: f}
+	addVar(r, name, opts, v, makeExpvar(func() string {
+		return strconv.FormatInt(v.Get(), 10)
+	}))
+	return v
+}
+
+func (g *GaugeFunc) Get() int64 { return g.f() }
+func (g *GaugeFunc) Visit(_ Mode, vs Visitor) {
+	vs.OnGauge()
+	vs.OnInt(g.Get())
+}
+
+// FloatGaugeFunc is a float gauge whose value is computed by a function every
+// time the gauge is read.
+type FloatGaugeFunc struct {
+	f func() float64
+}
+
+// NewFloatGaugeFunc creates and registers a new float gauge backed by f.
+// The function must be safe for concurrent use.
+//
+// Note: If the registry is configured to publish variables to expvar, the
+// variable will be available via expvars package as well, but can not be removed
+// anymore.
+func NewFloatGaugeFunc(r *Registry, name string, f func() float64, opts ...Option) *FloatGaugeFunc {
+	existingVar, r := setupMetric(r, name, opts)
+	if existingVar != nil {
+		cast, ok := existingVar.(*FloatGaugeFunc)
+		if ok {
+			return cast
+		} else {
+			panicErr(fmt.Errorf("variable name %s was first registered as a %T, tried to register as FloatGaugeFunc", name, existingVar))
+		}
+	}
+
+	v := &FloatGaugeFunc{f: f}
+	addVar(r, name, opts, v, makeExpvar(func() string {
+		return strconv.FormatFloat(v.Get(), 'g', -1, 64)
+	}))
+	return v
+}
+
+func (g *FloatGaugeFunc) Get() float64 { return g.f() }
+func (g *FloatGaugeFunc) Visit(_ Mode, vs Visitor) {
+	vs.OnGauge()
+	vs.OnFloat(g.Get())
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestMeter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := newMeter(clock.Now)
+
+	assert.Zero(t, m.Rate1())
+	assert.Zero(t, m.RateMean())
+
+	m.Mark(10)
+	m.Mark(40)
+	assert.Equal(t, int64(50), m.Count())
+
+	// first tick initialises the moving averages with the instant rate
+	clock.Advance(meterTickInterval)
+	assert.InDelta(t, 10.0, m.Rate1(), 1e-9)
+	assert.InDelta(t, 10.0, m.Rate5(), 1e-9)
+	assert.InDelta(t, 10.0, m.Rate15(), 1e-9)
+	assert.InDelta(t, 10.0, m.RateMean(), 1e-9)
+
+	// idle minute decays the 1m rate by 1/e, longer windows less so
+	clock.Advance(time.Minute)
+	assert.InDelta(t, 10.0/2.718281828, m.Rate1(), 1e-6)
+	assert.Greater(t, m.Rate5(), m.Rate1())
+	assert.Greater(t, m.Rate15(), m.Rate5())
+	assert.InDelta(t, 50.0/65.0, m.RateMean(), 1e-9)
+}
+
+func TestMeterZeroValue(t *testing.T) {
+	var m Meter
+	m.Mark(1)
+	assert.Equal(t, int64(1), m.Count())
+	assert.Zero(t, m.Rate1())
+}
+
+func TestNativeMetricTypes(t *testing.T) {
+	reg := NewRegistry()
+
+	meter := NewMeter(reg, "events")
+	meter.Mark(5)
+	assert.Same(t, meter, NewMeter(reg, "events"))
+
+	gauge := NewGaugeFunc(reg, "queue.size", func() int64 { return 42 })
+	assert.Same(t, gauge, NewGaugeFunc(reg, "queue.size", nil))
+	NewFloatGaugeFunc(reg, "queue.fill", func() float64 { return 0.5 })
+
+	assert.Panics(t, func() { NewMeter(reg, "queue.size") })
+
+	expected := map[string]interface{}{
+		"events": int64(5),
+		"queue": map[string]interface{}{
+			"size": int64(42),
+			"fill": 0.5,
+		},
+	}
+	require.Equal(t, expected, CollectStructSnapshot(reg, Full, false))
+}
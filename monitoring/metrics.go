@@ -34,8 +34,17 @@ const TSLayout = "2006-01-02T15:04:05.000Z"
 // makeExpvar wraps a callback for registering a metrics with expvar.Publish.
 type makeExpvar func() string
 
+// typedVar is implemented by variables reporting a type hint configured via
+// the Counter or Gauge options.
+type typedVar interface {
+	setMetricType(MetricType)
+}
+
 // Int is a 64 bit integer variable satisfying the Var interface.
-type Int struct{ i atomic.Int64 }
+type Int struct {
+	i   atomic.Int64
+	typ MetricType
+}
 
 // NewInt creates and registers a new integer variable.
 //
@@ -60,16 +69,24 @@ func NewInt(r *Registry, name string, opts ...Option) *Int {
 	return v
 }
 
-func (v *Int) Get() int64               { return v.i.Load() }
-func (v *Int) Set(value int64)          { v.i.Store(value) }
-func (v *Int) Add(delta int64)          { v.i.Add(delta) }
-func (v *Int) Sub(delta int64)          { v.i.Add(-delta) }
-func (v *Int) Inc()                     { v.i.Add(1) }
-func (v *Int) Dec()                     { v.i.Add(-1) }
-func (v *Int) Visit(_ Mode, vs Visitor) { vs.OnInt(v.Get()) }
+func (v *Int) Get() int64      { return v.i.Load() }
+func (v *Int) Set(value int64) { v.i.Store(value) }
+func (v *Int) Add(delta int64) { v.i.Add(delta) }
+func (v *Int) Sub(delta int64) { v.i.Add(-delta) }
+func (v *Int) Inc()            { v.i.Add(1) }
+func (v *Int) Dec()            { v.i.Add(-1) }
+func (v *Int) Visit(_ Mode, vs Visitor) {
+	reportType(vs, v.typ)
+	vs.OnInt(v.Get())
+}
+
+func (v *Int) setMetricType(t MetricType) { v.typ = t }
 
 // Uint is a 64bit unsigned integer variable satisfying the Var interface.
-type Uint struct{ u atomic.Uint64 }
+type Uint struct {
+	u   atomic.Uint64
+	typ MetricType
+}
 
 // NewUint creates and registers a new unsigned integer variable.
 //
@@ -101,12 +118,17 @@ func (v *Uint) Sub(delta uint64) { v.u.Add(-delta) }
 func (v *Uint) Inc()             { v.u.Add(1) }
 func (v *Uint) Dec()             { v.u.Add(^uint64(0)) }
 func (v *Uint) Visit(_ Mode, vs Visitor) {
-	value := v.Get() & (^uint64(1 << 63))
-	vs.OnInt(int64(value))
+	reportType(vs, v.typ)
+	vs.OnUint(v.Get())
 }
 
+func (v *Uint) setMetricType(t MetricType) { v.typ = t }
+
 // Float is a 64 bit float variable satisfying the Var interface.
-type Float struct{ f atomic.Uint64 }
+type Float struct {
+	f   atomic.Uint64
+	typ MetricType
+}
 
 // NewFloat creates and registers a new float variable.
 //
@@ -131,10 +153,15 @@ func NewFloat(r *Registry, name string, opts ...Option) *Float {
 	return v
 }
 
-func (v *Float) Get() float64             { return math.Float64frombits(v.f.Load()) }
-func (v *Float) Set(value float64)        { v.f.Store(math.Float64bits(value)) }
-func (v *Float) Sub(delta float64)        { v.Add(-delta) }
-func (v *Float) Visit(_ Mode, vs Visitor) { vs.OnFloat(v.Get()) }
+func (v *Float) Get() float64      { return math.Float64frombits(v.f.Load()) }
+func (v *Float) Set(value float64) { v.f.Store(math.Float64bits(value)) }
+func (v *Float) Sub(delta float64) { v.Add(-delta) }
+func (v *Float) Visit(_ Mode, vs Visitor) {
+	reportType(vs, v.typ)
+	vs.OnFloat(v.Get())
+}
+
+func (v *Float) setMetricType(t MetricType) { v.typ = t }
 
 func (v *Float) Add(delta float64) {
 	for {
@@ -260,8 +287,11 @@ func (m makeExpvar) String() string { return m() }
 
 func addVar(r *Registry, name string, opts []Option, v Var, ev expvar.Var) {
 	O := varOpts(r.opts, opts)
-	r.doAdd(name, v, O)
-	if O.publishExpvar && ev != nil {
+	if tv, ok := v.(typedVar); ok {
+		tv.setMetricType(O.metricType)
+	}
+	added := r.doAdd(name, v, O)
+	if added && O.publishExpvar && ev != nil {
 		expvar.Publish(fullName(r, name), ev)
 	}
 }
@@ -78,6 +78,7 @@ func Clear() error {
 }
 
 // GetClusterUUID returns the value of the monitoring.cluster_uuid setting, if it is set.
+// Use clusteruuid.Resolver to fall back to the UUID reported by Elasticsearch.
 func GetClusterUUID(monitoringCfg *config.C) (string, error) {
 	if monitoringCfg == nil {
 		return "", nil
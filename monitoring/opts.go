@@ -23,8 +23,24 @@ type Option func(options) options
 type options struct {
 	publishExpvar bool
 	mode          Mode
+	metricType    MetricType
 }
 
+// MetricType describes the semantic of a numeric metric. It is reported to
+// visitors via the OnCounter and OnGauge hints.
+type MetricType uint8
+
+const (
+	// UnknownMetric is the default, no type hint is reported.
+	UnknownMetric MetricType = iota
+
+	// CounterMetric is a monotonically increasing value.
+	CounterMetric
+
+	// GaugeMetric is a value that can go up and down.
+	GaugeMetric
+)
+
 var defaultOptions = options{
 	publishExpvar: false,
 	mode:          Full,
@@ -53,6 +69,18 @@ func DoNotReport(o options) options {
 	return o
 }
 
+// Counter marks numeric variables as monotonic counters.
+func Counter(o options) options {
+	o.metricType = CounterMetric
+	return o
+}
+
+// Gauge marks numeric variables as gauges.
+func Gauge(o options) options {
+	o.metricType = GaugeMetric
+	return o
+}
+
 func varOpts(regOpts *options, opts []Option) *options {
 	if regOpts != nil && len(opts) == 0 {
 		return regOpts
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // Registry to store variables and sub-registries.
@@ -34,6 +35,18 @@ type Registry struct {
 	entries map[string]entry
 
 	opts *options
+
+	// structure change notifications
+	generation atomic.Uint64
+	watchMu    sync.Mutex
+	watchers   watchers
+	parent     *Registry
+	key        string
+
+	// cardinality accounting
+	vars     atomic.Int64
+	varLimit atomic.Int64
+	rejected atomic.Uint64
 }
 
 type entry struct {
@@ -72,12 +85,17 @@ func (r *Registry) doVisit(mode Mode, vs Visitor) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	skipper, _ := vs.(keySkipper)
 	for key, v := range r.entries {
-		if _, isReg := v.Var.(*Registry); !isReg {
+		_, isReg := v.Var.(*Registry)
+		if !isReg {
 			if v.Mode > mode {
 				continue
 			}
 		}
+		if skipper != nil && skipper.skipKey(key, isReg) {
+			continue
+		}
 
 		vs.OnKey(key)
 		v.Var.Visit(mode, vs)
@@ -104,6 +122,16 @@ func (r *Registry) Get(name string) Var {
 	return v.Var
 }
 
+// GetWithMode tries to find a registered variable by name, like Get, and
+// returns the mode it has been registered with.
+func (r *Registry) GetWithMode(name string) (Var, Mode) {
+	e, err := r.find(name)
+	if err != nil {
+		return nil, Full
+	}
+	return e.Var, e.Mode
+}
+
 // GetRegistry tries to find a sub-registry by name.
 func (r *Registry) GetRegistry(name string) *Registry {
 	e, err := r.find(name)
@@ -126,19 +154,39 @@ func (r *Registry) GetRegistry(name string) *Registry {
 
 // Remove removes a variable or a sub-registry by name
 func (r *Registry) Remove(name string) {
-	r.removeNames(strings.Split(name, "."))
+	names := strings.Split(name, ".")
+	path := r.pathRegistries(names)
+	v, pruned := r.removeNames(names)
+	if v != nil {
+		r.publish(EventRemove, names, v, path)
+	}
+
+	// report the sub-registries removed for being empty, innermost first
+	if pruned >= 0 {
+		for i := len(path) - 1; i >= pruned; i-- {
+			r.publish(EventRemove, names[:i+1], path[i], path[:i])
+		}
+	}
 }
 
 // Clear removes all entries from the current registry
 func (r *Registry) Clear() error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	if r.opts.publishExpvar {
+		r.mu.Unlock()
 		return errors.New("cannot clear registry with metrics being exported via expvar")
 	}
 
+	removed := r.entries
 	r.entries = map[string]entry{}
+	r.mu.Unlock()
+
+	for name, e := range removed {
+		if reg, ok := e.Var.(*Registry); ok {
+			reg.unsetParent(r)
+		}
+		r.publish(EventRemove, []string{name}, e.Var, nil)
+	}
 	return nil
 }
 
@@ -152,11 +200,23 @@ func (r *Registry) Add(name string, v Var, m Mode) {
 		opts = &tmp
 	}
 
-	panicErr(r.addNames(strings.Split(name, "."), v, opts))
+	r.doAdd(name, v, opts)
 }
 
-func (r *Registry) doAdd(name string, v Var, opts *options) {
-	panicErr(r.addNames(strings.Split(name, "."), v, opts))
+// doAdd adds the variable, unless the variable limit is reached.
+// It returns false if the variable has been rejected.
+func (r *Registry) doAdd(name string, v Var, opts *options) bool {
+	names := strings.Split(name, ".")
+	reserved, ok := r.reserveVars(names, v)
+	if !ok {
+		return false
+	}
+	if err := r.addNames(names, v, opts); err != nil {
+		releaseVars(reserved, v)
+		panic(err)
+	}
+	r.publish(EventAdd, names, v, r.pathRegistries(names), reserved...)
+	return true
 }
 
 func (r *Registry) addNames(names []string, v Var, opts *options) error {
@@ -170,6 +230,9 @@ func (r *Registry) addNames(names []string, v Var, opts *options) error {
 		}
 
 		r.entries[name] = entry{v, opts.mode}
+		if reg, ok := v.(*Registry); ok {
+			reg.setParent(r, name)
+		}
 		return nil
 	}
 
@@ -184,6 +247,7 @@ func (r *Registry) addNames(names []string, v Var, opts *options) error {
 
 	sub := NewRegistry()
 	sub.opts = opts
+	sub.setParent(r, name)
 	if err := sub.addNames(names[1:], v, opts); err != nil {
 		return err
 	}
@@ -220,15 +284,27 @@ func (r *Registry) findNames(names []string) (entry, error) {
 	return entry{}, errInvalidName
 }
 
-func (r *Registry) removeNames(names []string) {
+// removeNames removes the named variable and returns it.
+// If the variable does not exist, nil is returned.
+// Sub-registries left empty are removed as well. pruned is the index of the
+// outermost removed sub-registry, named by names[:pruned+1], or -1 if none
+// has been removed.
+func (r *Registry) removeNames(names []string) (removed Var, pruned int) {
 	switch len(names) {
 	case 0:
-		return
+		return nil, -1
 	case 1:
 		r.mu.Lock()
 		defer r.mu.Unlock()
+		removed, exists := r.entries[names[0]]
+		if !exists {
+			return nil, -1
+		}
 		delete(r.entries, names[0])
-		return
+		if reg, ok := removed.Var.(*Registry); ok {
+			reg.unsetParent(r)
+		}
+		return removed.Var, -1
 	}
 
 	r.mu.Lock()
@@ -237,19 +313,27 @@ func (r *Registry) removeNames(names []string) {
 
 	// if name does not exist => don't remove anything
 	if !exists {
-		return
+		return nil, -1
 	}
 
 	sub, ok := next.Var.(*Registry)
-	if ok {
-		sub.removeNames(names[1:])
-		sub.mu.RLock()
-		defer sub.mu.RUnlock()
+	if !ok {
+		return nil, -1
+	}
 
-		if len(sub.entries) == 0 {
-			delete(r.entries, names[0])
-		}
+	removed, pruned = sub.removeNames(names[1:])
+	if pruned >= 0 {
+		pruned++
+	}
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if len(sub.entries) == 0 {
+		delete(r.entries, names[0])
+		sub.unsetParent(r)
+		pruned = 0
 	}
+	return removed, pruned
 }
 
 func panicErr(err error) {
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// RegistryStats reports the cardinality and approximate memory use of a
+// registry and all its sub-registries.
+type RegistryStats struct {
+	// Vars is the number of variables, not counting registries, in the subtree.
+	Vars int `json:"vars"`
+
+	// Registries is the number of sub-registries in the subtree.
+	Registries int `json:"registries"`
+
+	// MemoryBytes is a rough estimate of the memory used by the registry
+	// structure and the variables in the subtree.
+	MemoryBytes int64 `json:"memory_bytes"`
+
+	// Limit is the configured maximum number of variables, 0 if unlimited.
+	Limit int64 `json:"limit,omitempty"`
+
+	// Rejected counts the variables that have not been registered, because
+	// the limit was reached.
+	Rejected uint64 `json:"rejected,omitempty"`
+
+	// Children contains the stats of the direct sub-registries.
+	Children map[string]RegistryStats `json:"children,omitempty"`
+}
+
+// approximate sizes of the registry internals, used for estimating memory use.
+const (
+	// map slot with string key header, entry and per slot bookkeeping.
+	entryOverhead = int64(unsafe.Sizeof("") + unsafe.Sizeof(entry{}) + 8)
+
+	registrySize = int64(unsafe.Sizeof(Registry{}))
+)
+
+// Stats walks the registry and returns the number of variables and
+// sub-registries, plus the approximate memory use, per subtree.
+func (r *Registry) Stats() RegistryStats {
+	stats := RegistryStats{
+		MemoryBytes: registrySize,
+		Limit:       r.varLimit.Load(),
+		Rejected:    r.rejected.Load(),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for key, e := range r.entries {
+		stats.MemoryBytes += entryOverhead + int64(len(key))
+
+		sub, ok := e.Var.(*Registry)
+		if !ok {
+			stats.Vars++
+			stats.MemoryBytes += varSize(e.Var)
+			continue
+		}
+
+		subStats := sub.Stats()
+		stats.Registries += 1 + subStats.Registries
+		stats.Vars += subStats.Vars
+		stats.MemoryBytes += subStats.MemoryBytes
+		if stats.Children == nil {
+			stats.Children = map[string]RegistryStats{}
+		}
+		stats.Children[key] = subStats
+	}
+	return stats
+}
+
+// SetVarLimit limits the number of variables in the registry and all its
+// sub-registries. Registrations that would exceed the limit are rejected:
+// the variable is returned to the caller, but not added to the registry.
+// The first rejection is logged, all rejections are counted in Stats.
+//
+// The limit is checked against a counter maintained on every add and remove,
+// so it is cheap to enforce. A limit of 0 or less disables the check.
+func (r *Registry) SetVarLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	r.varLimit.Store(int64(limit))
+}
+
+// reserveVars reserves the variables of v in the counters of r, the
+// registries along the name's path and r's ancestors. If adding v would exceed
+// the limit of any of them, nothing is reserved and false is returned.
+// Reserving is atomic per registry, such that concurrent adds can not exceed
+// a limit together. The registries reserved on are returned, their counters
+// must not be adjusted again when publishing the add.
+func (r *Registry) reserveVars(names []string, v Var) ([]*Registry, bool) {
+	delta := countVars(v)
+	if delta == 0 {
+		return nil, true
+	}
+
+	var reserved []*Registry
+	reserve := func(reg *Registry, name string) bool {
+		for {
+			cur := reg.vars.Load()
+			limit := reg.varLimit.Load()
+			if limit > 0 && cur+delta > limit {
+				if reg.rejected.Add(1) == 1 {
+					logp.NewLogger("monitoring").Warnf(
+						"Metric %s rejected: the registry reached its limit of %d metrics. Further rejections are not logged.",
+						name, limit)
+				}
+				return false
+			}
+			if reg.vars.CompareAndSwap(cur, cur+delta) {
+				reserved = append(reserved, reg)
+				return true
+			}
+		}
+	}
+	for i, sub := range r.pathRegistries(names) {
+		if !reserve(sub, strings.Join(names[i+1:], ".")) {
+			releaseVars(reserved, v)
+			return nil, false
+		}
+	}
+
+	name := strings.Join(names, ".")
+	for reg := r; reg != nil; {
+		if !reserve(reg, name) {
+			releaseVars(reserved, v)
+			return nil, false
+		}
+
+		reg.watchMu.Lock()
+		parent, key := reg.parent, reg.key
+		reg.watchMu.Unlock()
+		if parent != nil {
+			name = key + "." + name
+		}
+		reg = parent
+	}
+	return reserved, true
+}
+
+// releaseVars returns the variables reserved by reserveVars.
+func releaseVars(reserved []*Registry, v Var) {
+	delta := countVars(v)
+	for _, reg := range reserved {
+		reg.vars.Add(-delta)
+	}
+}
+
+// countVars returns the number of variables v accounts for.
+func countVars(v Var) int64 {
+	if reg, ok := v.(*Registry); ok {
+		return reg.vars.Load()
+	}
+	return 1
+}
+
+// varSize estimates the memory used by a variable.
+func varSize(v Var) int64 {
+	switch v := v.(type) {
+	case *Int, *Uint, *Float, *Bool:
+		return 8
+	case *String:
+		return int64(unsafe.Sizeof(*v)) + int64(len(v.Get()))
+	case *Timestamp:
+		return int64(unsafe.Sizeof(*v))
+	case *Meter:
+		return int64(unsafe.Sizeof(*v))
+	default:
+		// interface value pointing to an unknown type
+		return int64(unsafe.Sizeof(v))
+	}
+}
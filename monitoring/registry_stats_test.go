@@ -0,0 +1,125 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryStats(t *testing.T) {
+	reg := NewRegistry()
+	NewInt(reg, "uptime")
+	inputs := reg.NewRegistry("inputs")
+	NewInt(inputs, "a.events")
+	NewString(inputs, "a.name").Set("some input")
+	NewInt(inputs, "b.events")
+
+	stats := reg.Stats()
+	assert.Equal(t, 4, stats.Vars)
+	assert.Equal(t, 3, stats.Registries)
+	assert.Equal(t, 3, stats.Children["inputs"].Vars)
+	assert.Equal(t, 2, stats.Children["inputs"].Children["a"].Vars)
+	assert.Greater(t, stats.MemoryBytes, stats.Children["inputs"].MemoryBytes)
+	assert.Greater(t, stats.Children["inputs"].Children["a"].MemoryBytes, stats.Children["inputs"].Children["b"].MemoryBytes)
+
+	before := stats.MemoryBytes
+	reg.Remove("inputs.a")
+	stats = reg.Stats()
+	assert.Equal(t, 2, stats.Vars)
+	assert.Less(t, stats.MemoryBytes, before)
+}
+
+func TestRegistryVarLimit(t *testing.T) {
+	reg := NewRegistry()
+	inputs := reg.NewRegistry("inputs")
+	inputs.SetVarLimit(3)
+
+	NewInt(inputs, "a.events")
+	NewInt(reg, "inputs.a.bytes")
+	NewInt(inputs, "b.events")
+
+	// limit reached, registrations via the registry itself and via its parent are rejected
+	rejected := NewInt(inputs, "c.events")
+	require.NotNil(t, rejected)
+	rejected.Inc()
+	NewInt(reg, "inputs.c.bytes")
+	assert.Nil(t, reg.Get("inputs.c.events"))
+	assert.Nil(t, reg.Get("inputs.c.bytes"))
+
+	// registrations outside the limited subtree are accepted
+	NewInt(reg, "uptime")
+	assert.NotNil(t, reg.Get("uptime"))
+
+	stats := inputs.Stats()
+	assert.Equal(t, 3, stats.Vars)
+	assert.Equal(t, int64(3), stats.Limit)
+	assert.Equal(t, uint64(2), stats.Rejected)
+
+	// removing variables makes room again
+	inputs.Remove("b.events")
+	NewInt(inputs, "c.events")
+	assert.NotNil(t, reg.Get("inputs.c.events"))
+
+	// adding a registry accounts for all its variables
+	other := NewRegistry()
+	NewInt(other, "x")
+	NewInt(other, "y")
+	inputs.Add("other", other, Full)
+	assert.Nil(t, inputs.Get("other"))
+}
+
+func TestRegistryVarLimitAncestor(t *testing.T) {
+	reg := NewRegistry()
+	reg.SetVarLimit(1)
+	sub := reg.NewRegistry("sub")
+
+	NewInt(sub, "a")
+	NewInt(sub, "b")
+	assert.Nil(t, sub.Get("b"))
+	assert.Equal(t, uint64(1), reg.Stats().Rejected)
+}
+
+func TestRegistryVarLimitConcurrent(t *testing.T) {
+	reg := NewRegistry()
+	reg.SetVarLimit(10)
+	sub := reg.NewRegistry("sub")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			NewInt(sub, fmt.Sprintf("v%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	stats := reg.Stats()
+	assert.Equal(t, 10, stats.Vars)
+	assert.Equal(t, 10, stats.Children["sub"].Vars)
+	assert.Equal(t, uint64(40), stats.Rejected)
+
+	count := 0
+	sub.Do(Full, func(string, interface{}) { count++ })
+	assert.Equal(t, 10, count)
+}
@@ -101,6 +101,25 @@ func TestRegistryGet(t *testing.T) {
 	assert.Equal(t, v, v3)
 }
 
+func TestRegistryGetWithMode(t *testing.T) {
+	reg := NewRegistry()
+	reported := NewInt(reg, "sub.reported", Report)
+	full := NewInt(reg, "sub.full")
+
+	v, mode := reg.GetWithMode("sub.reported")
+	assert.Equal(t, reported, v)
+	assert.Equal(t, Reported, mode)
+
+	v, mode = reg.GetRegistry("sub").GetWithMode("full")
+	assert.Equal(t, full, v)
+	assert.Equal(t, Full, mode)
+
+	v, _ = reg.GetWithMode("sub.missing")
+	assert.Nil(t, v)
+	v, _ = reg.GetWithMode("missing.metric")
+	assert.Nil(t, v)
+}
+
 func TestRegistryRemove(t *testing.T) {
 	defer func(t *testing.T) {
 		err := Clear()
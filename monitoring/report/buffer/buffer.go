@@ -48,18 +48,14 @@ func (r *ringBuffer) add(entry interface{}) {
 	}
 }
 
-// getAll returns all entries in the buffer in order
+// getAll returns a copy of all entries in the buffer in order
 func (r *ringBuffer) getAll() []interface{} {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if r.i == 0 && !r.full {
-		return []interface{}{}
-	}
 	if !r.full {
-		return r.entries[:r.i]
-	}
-	if r.full && r.i == 0 {
-		return r.entries
+		return append([]interface{}{}, r.entries[:r.i]...)
 	}
-	return append(r.entries[r.i:], r.entries[:r.i]...)
+	all := make([]interface{}, 0, len(r.entries))
+	all = append(all, r.entries[r.i:]...)
+	return append(all, r.entries[:r.i]...)
 }
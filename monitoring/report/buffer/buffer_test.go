@@ -79,6 +79,16 @@ func Test_ringBuffer(t *testing.T) {
 		r.add("6")
 		assert.ElementsMatch(t, []string{"4", "5", "6"}, r.getAll())
 	})
+
+	t.Run("getAll returns a copy", func(t *testing.T) {
+		r := newBuffer(2)
+		r.add("1")
+		r.add("2")
+		all := r.getAll()
+
+		r.add("3")
+		assert.Equal(t, []interface{}{"1", "2"}, all)
+	})
 }
 
 func Benchmark_ringBuffer_add(b *testing.B) {
@@ -18,13 +18,29 @@
 package buffer
 
 import (
+	"errors"
+	"fmt"
 	"time"
+
+	c "github.com/elastic/elastic-agent-libs/config"
 )
 
 type config struct {
-	Period     time.Duration `config:"period"`
-	Size       int           `config:"size" validate:"min=2"`
-	Namespaces []string      `config:"namespaces"`
+	Period     time.Duration     `config:"period" validate:"positive,nonzero"`
+	Size       int               `config:"size" validate:"min=2"`
+	Namespaces []namespaceConfig `config:"namespaces"`
+}
+
+// namespaceConfig configures the collection of a single namespace.
+// A zero Period or Size inherits the reporter wide setting.
+//
+// In the configuration file a namespace can be given by its name only:
+//
+//	namespaces: ["stats", {name: "state", period: 1m, size: 10}]
+type namespaceConfig struct {
+	Name   string        `config:"name" validate:"required"`
+	Period time.Duration `config:"period" validate:"positive"`
+	Size   int           `config:"size" validate:"positive"`
 }
 
 // defaultConfig will gather 10m of data (every 10s) for the stats registry.
@@ -32,6 +48,63 @@ func defaultConfig() config {
 	return config{
 		Period:     10 * time.Second,
 		Size:       60,
-		Namespaces: []string{"stats"},
+		Namespaces: []namespaceConfig{{Name: "stats"}},
+	}
+}
+
+// Unpack accepts either a plain namespace name or an object with name, period and size.
+func (n *namespaceConfig) Unpack(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		*n = namespaceConfig{Name: v}
+		return nil
+	case map[string]interface{}:
+		cfg, err := c.NewConfigFrom(v)
+		if err != nil {
+			return err
+		}
+		type rawConfig namespaceConfig
+		var tmp rawConfig
+		if err := cfg.Unpack(&tmp); err != nil {
+			return err
+		}
+		*n = namespaceConfig(tmp)
+		return nil
+	default:
+		return fmt.Errorf("namespace must be a string or an object, got %T", v)
+	}
+}
+
+// withDefaults returns the namespace config with unset values taken from the reporter config.
+func (n namespaceConfig) withDefaults(cfg config) namespaceConfig {
+	if n.Period == 0 {
+		n.Period = cfg.Period
+	}
+	if n.Size == 0 {
+		n.Size = cfg.Size
+	}
+	return n
+}
+
+// Validate ensures the namespace can be collected.
+func (n namespaceConfig) Validate() error {
+	if n.Name == "" {
+		return errors.New("namespace name must not be empty")
+	}
+	if n.Size == 1 {
+		return fmt.Errorf("size of namespace %s must be at least 2", n.Name)
+	}
+	return nil
+}
+
+// Validate ensures namespace names are unique.
+func (cfg *config) Validate() error {
+	seen := make(map[string]struct{}, len(cfg.Namespaces))
+	for _, ns := range cfg.Namespaces {
+		if _, found := seen[ns.Name]; found {
+			return fmt.Errorf("namespace %s configured multiple times", ns.Name)
+		}
+		seen[ns.Name] = struct{}{}
 	}
+	return nil
 }
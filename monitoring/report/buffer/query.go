@@ -0,0 +1,231 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package buffer
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+
+	timestampKey = "@timestamp"
+)
+
+// query holds the filters that can be passed to the reporter's HTTP handler.
+//
+// Supported parameters:
+//   - namespace: namespace(s) to return, may be repeated or comma separated.
+//   - since, until: time range of the entries, either as RFC3339 timestamp or
+//     as a duration relative to the time of the request (e.g. 5m).
+//   - metrics: metric path prefix(es), may be repeated or comma separated.
+//   - downsample: only return every n-th entry, the most recent entry is always included.
+//   - format: json (default) or ndjson.
+type query struct {
+	namespaces []string
+	since      time.Time
+	until      time.Time
+	metrics    []string
+	downsample int
+	format     string
+}
+
+// parseQuery parses the reporter query parameters from the url values.
+// The now timestamp is used to resolve relative time ranges.
+func parseQuery(values url.Values, now time.Time) (query, error) {
+	q := query{
+		namespaces: splitValues(values["namespace"]),
+		metrics:    splitValues(values["metrics"]),
+		downsample: 1,
+		format:     formatJSON,
+	}
+
+	var err error
+	if q.since, err = parseTime(values.Get("since"), now); err != nil {
+		return q, fmt.Errorf("invalid since parameter: %w", err)
+	}
+	if q.until, err = parseTime(values.Get("until"), now); err != nil {
+		return q, fmt.Errorf("invalid until parameter: %w", err)
+	}
+	if !q.since.IsZero() && !q.until.IsZero() && q.until.Before(q.since) {
+		return q, fmt.Errorf("until (%v) must not be before since (%v)", q.until, q.since)
+	}
+
+	if s := values.Get("downsample"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid downsample parameter %q: must be a positive integer", s)
+		}
+		q.downsample = n
+	}
+
+	if s := values.Get("format"); s != "" {
+		switch s {
+		case formatJSON, formatNDJSON:
+			q.format = s
+		default:
+			return q, fmt.Errorf("unknown format %q", s)
+		}
+	}
+	return q, nil
+}
+
+// splitValues splits comma separated values and drops empty ones.
+func splitValues(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// parseTime parses s either as RFC3339 timestamp or as a duration before now.
+// An empty string results in the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d.Abs()), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// includeNamespace returns true if entries of the namespace should be returned.
+func (q *query) includeNamespace(name string) bool {
+	if len(q.namespaces) == 0 {
+		return true
+	}
+	for _, ns := range q.namespaces {
+		if ns == name {
+			return true
+		}
+	}
+	return false
+}
+
+// filter applies the time range, downsampling and metric filters to the entries
+// of a namespace. The returned entries are ordered from oldest to newest.
+func (q *query) filter(entries []interface{}) []interface{} {
+	selected := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if q.since.IsZero() && q.until.IsZero() {
+			selected = append(selected, e)
+			continue
+		}
+
+		ts, ok := entryTimestamp(e)
+		if !ok {
+			continue
+		}
+		if !q.since.IsZero() && ts.Before(q.since) {
+			continue
+		}
+		if !q.until.IsZero() && ts.After(q.until) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+
+	if q.downsample > 1 && len(selected) > 0 {
+		sampled := make([]interface{}, 0, len(selected)/q.downsample+1)
+		// count from the end, so the most recent entry is always part of the result.
+		for i := (len(selected) - 1) % q.downsample; i < len(selected); i += q.downsample {
+			sampled = append(sampled, selected[i])
+		}
+		selected = sampled
+	}
+
+	if len(q.metrics) > 0 {
+		for i, e := range selected {
+			selected[i] = q.filterMetrics(e)
+		}
+	}
+	return selected
+}
+
+// filterMetrics returns a copy of the entry only containing the metrics
+// matching one of the configured path prefixes. The timestamp is always kept.
+func (q *query) filterMetrics(e interface{}) interface{} {
+	m, ok := toMapStr(e)
+	if !ok {
+		return e
+	}
+
+	out := mapstr.M{}
+	for key, value := range m.Flatten() {
+		if key == timestampKey || q.matchMetric(key) {
+			_, _ = out.Put(key, value)
+		}
+	}
+	return out
+}
+
+// matchMetric returns true if key is equal to or nested under one of the
+// configured prefixes.
+func (q *query) matchMetric(key string) bool {
+	for _, prefix := range q.metrics {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// entryTimestamp extracts the @timestamp field from a buffered entry.
+func entryTimestamp(e interface{}) (time.Time, bool) {
+	m, ok := toMapStr(e)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	switch ts := m[timestampKey].(type) {
+	case time.Time:
+		return ts, true
+	case string:
+		for _, layout := range []string{monitoring.TSLayout, time.RFC3339Nano} {
+			if t, err := time.Parse(layout, ts); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func toMapStr(e interface{}) (mapstr.M, bool) {
+	switch m := e.(type) {
+	case mapstr.M:
+		return m, true
+	case map[string]interface{}:
+		return mapstr.M(m), true
+	default:
+		return nil, false
+	}
+}
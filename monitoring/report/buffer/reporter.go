@@ -19,8 +19,10 @@ package buffer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -31,12 +33,22 @@ import (
 // reporter is a struct that will fill a ring buffer for each monitored registry.
 type reporter struct {
 	config
-	wg         sync.WaitGroup
-	done       chan struct{}
-	registries map[string]*monitoring.Registry
+	mu      sync.RWMutex
+	wg      sync.WaitGroup
+	stopped bool
 
-	// ring buffers for namespaces
-	entries map[string]*ringBuffer
+	// collectors for namespaces
+	namespaces map[string]*namespaceCollector
+}
+
+// namespaceCollector periodically snapshots a single registry into its ring buffer.
+type namespaceCollector struct {
+	period   time.Duration
+	registry *monitoring.Registry
+	done     chan struct{}
+
+	// ring buffer for the namespace
+	entries *ringBuffer
 }
 
 // MakeReporter creates and starts a reporter with the given config.
@@ -50,60 +62,135 @@ func MakeReporter(cfg *c.C) (*reporter, error) {
 
 	r := &reporter{
 		config:     config,
-		done:       make(chan struct{}),
-		registries: map[string]*monitoring.Registry{},
-		entries:    map[string]*ringBuffer{},
+		namespaces: map[string]*namespaceCollector{},
 	}
 
 	for _, ns := range r.config.Namespaces {
-		reg := monitoring.GetNamespace(ns).GetRegistry()
-		r.registries[ns] = reg
-		r.entries[ns] = newBuffer(r.config.Size)
+		if err := r.AddNamespace(ns.Name, ns.Period, ns.Size); err != nil {
+			r.Stop()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// AddNamespace starts collecting the named namespace every period, keeping size entries.
+// A zero period or size uses the reporter wide setting.
+// An error is returned if the namespace is already collected or the reporter has been stopped.
+func (r *reporter) AddNamespace(name string, period time.Duration, size int) error {
+	ns := namespaceConfig{Name: name, Period: period, Size: size}.withDefaults(r.config)
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+	if ns.Period <= 0 {
+		return fmt.Errorf("period of namespace %s must be positive", name)
+	}
+	if ns.Size < 2 {
+		return fmt.Errorf("size of namespace %s must be at least 2", name)
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return errors.New("reporter has been stopped")
+	}
+	if _, found := r.namespaces[name]; found {
+		return fmt.Errorf("namespace %s is already collected", name)
+	}
+
+	col := &namespaceCollector{
+		period:   ns.Period,
+		registry: monitoring.GetNamespace(name).GetRegistry(),
+		done:     make(chan struct{}),
+		entries:  newBuffer(ns.Size),
+	}
+	r.namespaces[name] = col
+
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		r.snapshotLoop()
+		col.snapshotLoop()
 	}()
-	return r, nil
+	return nil
+}
+
+// RemoveNamespace stops collecting the named namespace and drops its collected data.
+// Removing an unknown namespace is a no-op.
+func (r *reporter) RemoveNamespace(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if col, found := r.namespaces[name]; found {
+		if !r.stopped {
+			close(col.done)
+		}
+		delete(r.namespaces, name)
+	}
 }
 
 // Stop will stop the reporter from collecting new information.
 // It will not clear any previously collected data.
+// Calling Stop more than once is safe.
 func (r *reporter) Stop() {
-	close(r.done)
+	r.mu.Lock()
+	if !r.stopped {
+		r.stopped = true
+		for _, col := range r.namespaces {
+			close(col.done)
+		}
+	}
+	r.mu.Unlock()
 	r.wg.Wait()
 }
 
-// snapshotLoop will collect a snapshot for each monitored registry for the configured period and store them in the correct buffer.
-func (r *reporter) snapshotLoop() {
-	ticker := time.NewTicker(r.config.Period)
+// snapshotLoop will collect a snapshot of the registry for the configured period and store them in the buffer.
+func (col *namespaceCollector) snapshotLoop() {
+	ticker := time.NewTicker(col.period)
 	defer ticker.Stop()
 
 	for {
 		var ts time.Time
 		select {
-		case <-r.done:
+		case <-col.done:
 			return
 		case ts = <-ticker.C:
 		}
 
-		for name, reg := range r.registries {
-			snap := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
-			if _, ok := snap["@timestamp"]; !ok {
-				snap["@timestamp"] = ts.UTC()
-			}
-			r.entries[name].add(snap)
+		snap := monitoring.CollectStructSnapshot(col.registry, monitoring.Full, false)
+		if _, ok := snap["@timestamp"]; !ok {
+			snap["@timestamp"] = ts.UTC()
 		}
+		col.entries.add(snap)
 	}
 }
 
 // ServeHTTP is an http.Handler that will respond with the monitored registries buffer's contents in JSON.
+//
+// The response can be narrowed down with the query parameters described on the
+// query type. With format=ndjson every entry is streamed as a separate JSON
+// document of the form {"namespace": <name>, "entry": <snapshot>}.
 func (r *reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	resp := make(map[string][]interface{}, len(r.entries))
-	for name, entries := range r.entries {
-		resp[name] = entries.getAll()
+	q, err := parseQuery(req.URL.Query(), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid query: %v", err)
+		return
+	}
+
+	entries := r.buffers(&q)
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if q.format == formatNDJSON {
+		serveNDJSON(w, names, entries, &q)
+		return
+	}
+
+	resp := make(map[string][]interface{}, len(names))
+	for _, name := range names {
+		resp[name] = q.filter(entries[name].getAll())
 	}
 
 	p, err := json.Marshal(resp)
@@ -115,3 +202,40 @@ func (r *reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_, _ = w.Write(p)
 }
+
+// serveNDJSON writes one JSON document per buffered entry, flushing after each namespace.
+func serveNDJSON(w http.ResponseWriter, names []string, entries map[string]*ringBuffer, q *query) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+	for _, name := range names {
+		for _, e := range q.filter(entries[name].getAll()) {
+			err := enc.Encode(struct {
+				Namespace string      `json:"namespace"`
+				Entry     interface{} `json:"entry"`
+			}{name, e})
+			if err != nil {
+				// headers have already been sent, the client will see a truncated stream.
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// buffers returns the ring buffers of the namespaces selected by the query.
+func (r *reporter) buffers(q *query) map[string]*ringBuffer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make(map[string]*ringBuffer, len(r.namespaces))
+	for name, col := range r.namespaces {
+		if q.includeNamespace(name) {
+			entries[name] = col.entries
+		}
+	}
+	return entries
+}
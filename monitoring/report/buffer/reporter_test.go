@@ -0,0 +1,241 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package buffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	c "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestReporter(t *testing.T, n int) *reporter {
+	t.Helper()
+	r := &reporter{
+		namespaces: map[string]*namespaceCollector{
+			"stats": {entries: newBuffer(n)},
+			"state": {entries: newBuffer(n)},
+		},
+	}
+	for i := 0; i < n; i++ {
+		ts := testStart.Add(time.Duration(i) * 10 * time.Second)
+		r.namespaces["stats"].entries.add(map[string]interface{}{
+			"@timestamp": ts,
+			"pipeline":   map[string]interface{}{"events": map[string]interface{}{"total": int64(i)}},
+			"output":     map[string]interface{}{"write": map[string]interface{}{"bytes": int64(i * 100)}},
+		})
+		r.namespaces["state"].entries.add(map[string]interface{}{
+			"@timestamp": ts,
+			"host":       map[string]interface{}{"name": "test"},
+		})
+	}
+	return r
+}
+
+func serve(t *testing.T, r *reporter, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder) map[string][]map[string]interface{} {
+	t.Helper()
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestReporterServeHTTP(t *testing.T) {
+	r := newTestReporter(t, 6)
+
+	t.Run("no filters", func(t *testing.T) {
+		resp := decodeJSON(t, serve(t, r, "/buffer"))
+		assert.Len(t, resp, 2)
+		assert.Len(t, resp["stats"], 6)
+		assert.Len(t, resp["state"], 6)
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		resp := decodeJSON(t, serve(t, r, "/buffer?namespace=state"))
+		assert.Len(t, resp, 1)
+		assert.Len(t, resp["state"], 6)
+
+		resp = decodeJSON(t, serve(t, r, "/buffer?namespace=state,stats"))
+		assert.Len(t, resp, 2)
+	})
+
+	t.Run("time range", func(t *testing.T) {
+		since := testStart.Add(10 * time.Second).Format(time.RFC3339)
+		until := testStart.Add(30 * time.Second).Format(time.RFC3339)
+		resp := decodeJSON(t, serve(t, r, "/buffer?namespace=stats&since="+since+"&until="+until))
+		require.Len(t, resp["stats"], 3)
+		assert.Equal(t, testStart.Add(10*time.Second).Format(time.RFC3339Nano), resp["stats"][0]["@timestamp"])
+		assert.Equal(t, testStart.Add(30*time.Second).Format(time.RFC3339Nano), resp["stats"][2]["@timestamp"])
+	})
+
+	t.Run("metrics prefix", func(t *testing.T) {
+		resp := decodeJSON(t, serve(t, r, "/buffer?namespace=stats&metrics=pipeline.events"))
+		require.Len(t, resp["stats"], 6)
+		for _, e := range resp["stats"] {
+			assert.Contains(t, e, "@timestamp")
+			assert.Contains(t, e, "pipeline")
+			assert.NotContains(t, e, "output")
+		}
+	})
+
+	t.Run("metrics prefix matches full path segments only", func(t *testing.T) {
+		resp := decodeJSON(t, serve(t, r, "/buffer?namespace=stats&metrics=pipe"))
+		for _, e := range resp["stats"] {
+			assert.Equal(t, []string{"@timestamp"}, keys(e))
+		}
+	})
+
+	t.Run("downsample", func(t *testing.T) {
+		resp := decodeJSON(t, serve(t, r, "/buffer?namespace=stats&downsample=4"))
+		require.Len(t, resp["stats"], 2)
+		assert.Equal(t, testStart.Add(10*time.Second).Format(time.RFC3339Nano), resp["stats"][0]["@timestamp"])
+		assert.Equal(t, testStart.Add(50*time.Second).Format(time.RFC3339Nano), resp["stats"][1]["@timestamp"])
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rec := serve(t, r, "/buffer?format=ndjson&downsample=3")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+		var namespaces []string
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var doc struct {
+				Namespace string                 `json:"namespace"`
+				Entry     map[string]interface{} `json:"entry"`
+			}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+			assert.Contains(t, doc.Entry, "@timestamp")
+			namespaces = append(namespaces, doc.Namespace)
+		}
+		assert.Equal(t, []string{"state", "state", "stats", "stats"}, namespaces)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, target := range []string{
+			"/buffer?since=yesterday",
+			"/buffer?downsample=0",
+			"/buffer?format=xml",
+			"/buffer?since=2024-01-02T00:00:00Z&until=2024-01-01T00:00:00Z",
+		} {
+			rec := serve(t, r, target)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+		}
+	})
+}
+
+func TestParseQueryRelativeTime(t *testing.T) {
+	now := testStart.Add(time.Hour)
+	q, err := parseQuery(map[string][]string{"since": {"5m"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-5*time.Minute), q.since)
+	assert.True(t, q.until.IsZero())
+}
+
+func TestMakeReporterNamespaceConfig(t *testing.T) {
+	cfg := c.MustNewConfigFrom(map[string]interface{}{
+		"period": "10s",
+		"size":   6,
+		"namespaces": []interface{}{
+			"stats",
+			map[string]interface{}{"name": "state", "period": "1m", "size": 3},
+			map[string]interface{}{"name": "info", "size": 2},
+		},
+	})
+	r, err := MakeReporter(cfg)
+	require.NoError(t, err)
+	defer r.Stop()
+
+	require.Len(t, r.namespaces, 3)
+	assert.Equal(t, 10*time.Second, r.namespaces["stats"].period)
+	assert.Len(t, r.namespaces["stats"].entries.entries, 6)
+	assert.Equal(t, time.Minute, r.namespaces["state"].period)
+	assert.Len(t, r.namespaces["state"].entries.entries, 3)
+	assert.Equal(t, 10*time.Second, r.namespaces["info"].period)
+	assert.Len(t, r.namespaces["info"].entries.entries, 2)
+}
+
+func TestMakeReporterInvalidNamespaceConfig(t *testing.T) {
+	for name, namespaces := range map[string][]interface{}{
+		"duplicate":    {"stats", map[string]interface{}{"name": "stats"}},
+		"missing name": {map[string]interface{}{"period": "1s"}},
+		"size too low": {map[string]interface{}{"name": "stats", "size": 1}},
+		"wrong type":   {42},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := c.MustNewConfigFrom(map[string]interface{}{"namespaces": namespaces})
+			_, err := MakeReporter(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReporterDynamicNamespaces(t *testing.T) {
+	reg := monitoring.GetNamespace("buffer_test_dynamic").GetRegistry()
+	monitoring.NewInt(reg, "counter").Set(42)
+
+	r, err := MakeReporter(c.MustNewConfigFrom(map[string]interface{}{"namespaces": []string{}}))
+	require.NoError(t, err)
+
+	require.NoError(t, r.AddNamespace("buffer_test_dynamic", 10*time.Millisecond, 2))
+	assert.Error(t, r.AddNamespace("buffer_test_dynamic", 0, 0), "namespace must not be added twice")
+	assert.Error(t, r.AddNamespace("buffer_test_invalid", 0, -3), "negative size must be rejected")
+	assert.Error(t, r.AddNamespace("buffer_test_invalid", 0, 1), "size must be at least 2")
+	assert.Error(t, r.AddNamespace("buffer_test_invalid", -time.Second, 0), "negative period must be rejected")
+
+	require.Eventually(t, func() bool {
+		resp := decodeJSON(t, serve(t, r, "/buffer"))
+		return len(resp["buffer_test_dynamic"]) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r.RemoveNamespace("buffer_test_dynamic")
+	r.RemoveNamespace("unknown")
+	resp := decodeJSON(t, serve(t, r, "/buffer"))
+	assert.NotContains(t, resp, "buffer_test_dynamic")
+
+	require.NoError(t, r.AddNamespace("buffer_test_dynamic", time.Hour, 0))
+	r.Stop()
+	r.Stop()
+	assert.Error(t, r.AddNamespace("stats", 0, 0), "namespace must not be added after stop")
+	r.RemoveNamespace("buffer_test_dynamic")
+}
+
+func keys(m map[string]interface{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,312 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package log implements a reporter that periodically writes the changes of
+// monitoring metrics to the log stream.
+package log
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// defaultPeriod is used if the MetricsConfig does not specify a period.
+const defaultPeriod = 30 * time.Second
+
+// Option configures optional reporter settings.
+type Option func(*reporter)
+
+// WithNamespaces selects the monitoring namespaces to report.
+// Only the "stats" namespace is reported by default.
+func WithNamespaces(namespaces ...string) Option {
+	return func(r *reporter) {
+		r.namespaces = namespaces
+	}
+}
+
+// WithGauges marks metrics, given by their flattened name, as gauges. Gauges
+// are reported with their current value if non-zero, instead of the change
+// since the last report. Metrics registered with the monitoring.Gauge or
+// monitoring.Counter option are reported according to their type hint, so
+// WithGauges is only needed for metrics registered without a type.
+func WithGauges(names ...string) Option {
+	return func(r *reporter) {
+		for _, name := range names {
+			r.gauges[name] = struct{}{}
+		}
+	}
+}
+
+// reporter logs the non-zero changes of the selected namespaces every period.
+type reporter struct {
+	log        *logp.Logger
+	period     time.Duration
+	namespaces []string
+	gauges     map[string]struct{}
+
+	// last snapshot per namespace, only accessed by the reporting goroutine
+	// and by Stop after it has finished.
+	last map[string]monitoring.FlatSnapshot
+
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// MakeReporter creates and starts a reporter logging metrics according to
+// cfg. If cfg is not enabled, the returned reporter does nothing.
+//
+// Every period a single line "Non-zero metrics in the last <period>" is logged
+// at info level with the metric changes in the monitoring.metrics field.
+// If more than one namespace is selected, the metrics are nested under the
+// namespace name. Stopping the reporter logs the totals of all non-zero
+// metrics in a final "Total metrics" line.
+func MakeReporter(log *logp.Logger, cfg logp.MetricsConfig, opts ...Option) *reporter {
+	if log == nil {
+		log = logp.NewLogger("")
+	}
+
+	r := &reporter{
+		log:        log.Named("monitoring"),
+		period:     cfg.Period,
+		namespaces: []string{"stats"},
+		gauges:     map[string]struct{}{},
+		last:       map[string]monitoring.FlatSnapshot{},
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.period <= 0 {
+		r.period = defaultPeriod
+	}
+
+	if !cfg.Enabled {
+		close(r.done)
+		return r
+	}
+
+	for _, ns := range r.namespaces {
+		r.last[ns] = monitoring.MakeFlatSnapshot()
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.snapshotLoop()
+	}()
+	return r
+}
+
+// Stop stops the reporter and logs the total metrics.
+// Calling Stop more than once is safe.
+func (r *reporter) Stop() {
+	r.stopOnce.Do(func() {
+		select {
+		case <-r.done:
+			// disabled reporter
+			return
+		default:
+		}
+
+		close(r.done)
+		r.wg.Wait()
+		r.logTotals()
+	})
+}
+
+func (r *reporter) snapshotLoop() {
+	r.log.Infof("Starting metrics logging every %v", r.period)
+	defer r.log.Infof("Stopping metrics logging.")
+
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		r.logSnapshot()
+	}
+}
+
+// logSnapshot logs the changes since the last snapshot, if there are any.
+func (r *reporter) logSnapshot() {
+	metrics := make(map[string]mapstr.M, len(r.namespaces))
+	for _, ns := range r.namespaces {
+		cur, types := collectWithTypes(ns)
+		delta := makeDeltaSnapshot(r.last[ns], cur, r.gaugesOf(types))
+		r.last[ns] = cur
+
+		if m := toMapStr(delta); len(m) > 0 {
+			metrics[ns] = m
+		}
+	}
+
+	if len(metrics) == 0 {
+		r.log.Infof("No non-zero metrics in the last %v", r.period)
+		return
+	}
+	r.log.Infow(fmt.Sprintf("Non-zero metrics in the last %v", r.period), "monitoring", r.event(metrics))
+}
+
+// logTotals logs the current values of all non-zero metrics.
+func (r *reporter) logTotals() {
+	metrics := make(map[string]mapstr.M, len(r.namespaces))
+	for _, ns := range r.namespaces {
+		if m := toMapStr(collect(ns)); len(m) > 0 {
+			metrics[ns] = m
+		}
+	}
+	r.log.Infow("Total metrics", "monitoring", r.event(metrics))
+}
+
+func (r *reporter) event(metrics map[string]mapstr.M) mapstr.M {
+	if len(r.namespaces) == 1 {
+		return mapstr.M{"metrics": metrics[r.namespaces[0]]}
+	}
+
+	nested := mapstr.M{}
+	for ns, m := range metrics {
+		nested[ns] = m
+	}
+	return mapstr.M{"metrics": nested}
+}
+
+// gaugesOf returns the names of the gauges, given the type hints of the
+// collected metrics. Type hints take precedence over the names configured
+// with WithGauges.
+func (r *reporter) gaugesOf(types map[string]monitoring.MetricType) map[string]struct{} {
+	gauges := make(map[string]struct{}, len(r.gauges))
+	for name := range r.gauges {
+		if types[name] == monitoring.UnknownMetric {
+			gauges[name] = struct{}{}
+		}
+	}
+	for name, t := range types {
+		if t == monitoring.GaugeMetric {
+			gauges[name] = struct{}{}
+		}
+	}
+	return gauges
+}
+
+func collect(namespace string) monitoring.FlatSnapshot {
+	reg := monitoring.GetNamespace(namespace).GetRegistry()
+	return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+}
+
+func collectWithTypes(namespace string) (monitoring.FlatSnapshot, map[string]monitoring.MetricType) {
+	reg := monitoring.GetNamespace(namespace).GetRegistry()
+	return monitoring.CollectFlatSnapshotWithTypes(reg, monitoring.Full, false)
+}
+
+// makeDeltaSnapshot returns the metrics that changed between prev and cur.
+// Numeric values are reported as difference to prev, unless they are gauges.
+// All other values are reported with their current value if changed.
+func makeDeltaSnapshot(prev, cur monitoring.FlatSnapshot, gauges map[string]struct{}) monitoring.FlatSnapshot {
+	delta := monitoring.MakeFlatSnapshot()
+
+	for k, b := range cur.Bools {
+		if p, ok := prev.Bools[k]; !ok || p != b {
+			delta.Bools[k] = b
+		}
+	}
+	for k, s := range cur.Strings {
+		if p, ok := prev.Strings[k]; !ok || p != s {
+			delta.Strings[k] = s
+		}
+	}
+	for k, s := range cur.StringSlices {
+		if p, ok := prev.StringSlices[k]; !ok || !slices.Equal(p, s) {
+			delta.StringSlices[k] = s
+		}
+	}
+	for k, i := range cur.Ints {
+		if _, isGauge := gauges[k]; isGauge {
+			delta.Ints[k] = i
+		} else {
+			delta.Ints[k] = i - prev.Ints[k]
+		}
+	}
+	for k, u := range cur.Uints {
+		if _, isGauge := gauges[k]; isGauge || u < prev.Uints[k] {
+			// A counter smaller than before has been reset.
+			delta.Uints[k] = u
+		} else {
+			delta.Uints[k] = u - prev.Uints[k]
+		}
+	}
+	for k, f := range cur.Floats {
+		if _, isGauge := gauges[k]; isGauge {
+			delta.Floats[k] = f
+		} else {
+			delta.Floats[k] = f - prev.Floats[k]
+		}
+	}
+	return delta
+}
+
+// toMapStr converts the snapshot into a nested map, dropping zero numbers,
+// false booleans and empty strings.
+func toMapStr(s monitoring.FlatSnapshot) mapstr.M {
+	m := mapstr.M{}
+	put := func(k string, v interface{}) {
+		_, _ = m.Put(k, v)
+	}
+
+	for k, v := range s.Bools {
+		if v {
+			put(k, true)
+		}
+	}
+	for k, v := range s.Strings {
+		if v != "" {
+			put(k, v)
+		}
+	}
+	for k, v := range s.StringSlices {
+		if len(v) > 0 {
+			put(k, v)
+		}
+	}
+	for k, v := range s.Ints {
+		if v != 0 {
+			put(k, v)
+		}
+	}
+	for k, v := range s.Uints {
+		if v != 0 {
+			put(k, v)
+		}
+	}
+	for k, v := range s.Floats {
+		if v != 0 {
+			put(k, v)
+		}
+	}
+	return m
+}
@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func newObservedLogger() (*logp.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	return logp.NewLogger("", zap.WrapCore(func(zapcore.Core) zapcore.Core { return core })), logs
+}
+
+func TestMakeDeltaSnapshot(t *testing.T) {
+	prev := monitoring.MakeFlatSnapshot()
+	prev.Ints["events.total"] = 10
+	prev.Ints["queue.size"] = 5
+	prev.Uints["bytes.total"] = 1 << 63
+	prev.Uints["bytes.dropped"] = 7
+	prev.Floats["cpu"] = 1.5
+	prev.Strings["name"] = "a"
+	prev.Bools["ready"] = true
+
+	cur := monitoring.MakeFlatSnapshot()
+	cur.Ints["events.total"] = 15
+	cur.Ints["events.failed"] = 2
+	cur.Ints["queue.size"] = 5
+	cur.Uints["bytes.total"] = 1<<63 + 3
+	cur.Uints["bytes.dropped"] = 2 // reset
+	cur.Floats["cpu"] = 1.5
+	cur.Strings["name"] = "b"
+	cur.Bools["ready"] = true
+
+	delta := makeDeltaSnapshot(prev, cur, map[string]struct{}{"queue.size": {}})
+	assert.Equal(t, mapstr.M{
+		"events": mapstr.M{"total": int64(5), "failed": int64(2)},
+		"bytes":  mapstr.M{"total": uint64(3), "dropped": uint64(2)},
+		"queue":  mapstr.M{"size": int64(5)},
+		"name":   "b",
+	}, toMapStr(delta))
+}
+
+func TestReporter(t *testing.T) {
+	reg := monitoring.GetNamespace("log_reporter_test").GetRegistry()
+	events := monitoring.NewInt(reg, "events.total")
+	monitoring.NewInt(reg, "events.failed")
+	queue := monitoring.NewInt(reg, "queue.size")
+
+	log, logs := newObservedLogger()
+	r := MakeReporter(log, logp.MetricsConfig{Enabled: true, Period: 10 * time.Millisecond},
+		WithNamespaces("log_reporter_test"),
+		WithGauges("queue.size"),
+	)
+
+	events.Add(3)
+	queue.Set(7)
+	require.Eventually(t, func() bool {
+		return logs.FilterMessageSnippet("Non-zero metrics").Len() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	r.Stop()
+	r.Stop()
+
+	entry := logs.FilterMessageSnippet("Non-zero metrics").All()[0]
+	assert.Equal(t, "monitoring", entry.LoggerName)
+	assert.Equal(t, map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"events": map[string]interface{}{"total": int64(3)},
+			"queue":  map[string]interface{}{"size": int64(7)},
+		},
+	}, entry.ContextMap()["monitoring"])
+
+	totals := logs.FilterMessage("Total metrics").All()
+	require.Len(t, totals, 1)
+	assert.Equal(t, map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"events": map[string]interface{}{"total": int64(3)},
+			"queue":  map[string]interface{}{"size": int64(7)},
+		},
+	}, totals[0].ContextMap()["monitoring"])
+}
+
+func TestReporterTypeHints(t *testing.T) {
+	reg := monitoring.GetNamespace("log_reporter_test_hints").GetRegistry()
+	active := monitoring.NewInt(reg, "connections.active", monitoring.Gauge)
+	events := monitoring.NewUint(reg, "events.total", monitoring.Counter)
+	queue := monitoring.NewInt(reg, "queue.size")
+
+	log, logs := newObservedLogger()
+	r := MakeReporter(log, logp.MetricsConfig{Enabled: true, Period: time.Hour},
+		WithNamespaces("log_reporter_test_hints"),
+		// the counter hint takes precedence
+		WithGauges("queue.size", "events.total"),
+	)
+	defer r.Stop()
+
+	active.Set(4)
+	events.Add(3)
+	queue.Set(2)
+	r.logSnapshot()
+	events.Add(1)
+	r.logSnapshot()
+
+	entries := logs.FilterMessageSnippet("Non-zero metrics").All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"connections": map[string]interface{}{"active": int64(4)},
+			"events":      map[string]interface{}{"total": uint64(1)},
+			"queue":       map[string]interface{}{"size": int64(2)},
+		},
+	}, entries[1].ContextMap()["monitoring"])
+}
+
+func TestReporterMultipleNamespaces(t *testing.T) {
+	monitoring.NewInt(monitoring.GetNamespace("log_reporter_test_a").GetRegistry(), "count").Set(1)
+	monitoring.NewInt(monitoring.GetNamespace("log_reporter_test_b").GetRegistry(), "count").Set(2)
+
+	log, logs := newObservedLogger()
+	r := MakeReporter(log, logp.MetricsConfig{Enabled: true, Period: time.Hour},
+		WithNamespaces("log_reporter_test_a", "log_reporter_test_b"))
+	r.logSnapshot()
+	r.Stop()
+
+	entries := logs.FilterMessageSnippet("Non-zero metrics").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"log_reporter_test_a": map[string]interface{}{"count": int64(1)},
+			"log_reporter_test_b": map[string]interface{}{"count": int64(2)},
+		},
+	}, entries[0].ContextMap()["monitoring"])
+}
+
+func TestReporterDisabled(t *testing.T) {
+	log, logs := newObservedLogger()
+	r := MakeReporter(log, logp.MetricsConfig{Enabled: false, Period: time.Millisecond})
+	time.Sleep(10 * time.Millisecond)
+	r.Stop()
+	assert.Zero(t, logs.Len())
+}
@@ -0,0 +1,282 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package runtime registers Go runtime and process metrics with a
+// monitoring registry and periodically refreshes them.
+package runtime
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// Config configures the runtime metrics collector.
+type Config struct {
+	// Period is the interval in which the metrics are refreshed.
+	Period time.Duration `config:"period" validate:"positive,nonzero"`
+}
+
+// DefaultConfig returns the default collector configuration.
+func DefaultConfig() Config {
+	return Config{
+		Period: 10 * time.Second,
+	}
+}
+
+// names of the runtime/metrics samples read on every refresh.
+const (
+	sampleGoroutines = iota
+	sampleGCCycles
+	sampleGCPauses
+	sampleGCHeapGoal
+	sampleHeapObjects
+	sampleHeapAllocs
+	sampleMemoryTotal
+	numSamples
+)
+
+var sampleNames = [numSamples]string{
+	sampleGoroutines:  "/sched/goroutines:goroutines",
+	sampleGCCycles:    "/gc/cycles/total:gc-cycles",
+	sampleGCPauses:    "/sched/pauses/total/gc:seconds",
+	sampleGCHeapGoal:  "/gc/heap/goal:bytes",
+	sampleHeapObjects: "/memory/classes/heap/objects:bytes",
+	sampleHeapAllocs:  "/gc/heap/allocs:bytes",
+	sampleMemoryTotal: "/memory/classes/total:bytes",
+}
+
+// Collector keeps runtime and process metrics in a registry up to date.
+//
+// The metrics are stored in monitoring variables, such that visiting the
+// registry only reads the last refreshed values. Refreshing reuses
+// preallocated buffers and does not allocate.
+//
+// The following metrics are registered:
+//
+//	goroutines              number of live goroutines
+//	gc.count                completed GC cycles
+//	gc.pauses.count         stop-the-world pauses caused by the GC
+//	gc.pauses.total.ns      approximated total duration of the GC pauses
+//	gc.pauses.max.ns        approximated longest GC pause
+//	memory.heap.alloc       bytes occupied by live and not yet freed heap objects
+//	memory.heap.goal        heap size target of the current GC cycle
+//	memory.heap.total       cumulative bytes allocated on the heap
+//	memory.sys              bytes of memory mapped by the Go runtime
+//
+// On Linux the process metrics are read from /proc/self in addition:
+//
+//	memory.rss              resident set size in bytes
+//	cpu.user.ns             CPU time spent in user mode
+//	cpu.system.ns           CPU time spent in kernel mode
+//	cpu.total.ns            sum of user and system CPU time
+//	handles.open            number of open file descriptors
+type Collector struct {
+	period time.Duration
+
+	mu      sync.Mutex
+	samples []metrics.Sample
+	proc    procReader
+
+	goroutines    *monitoring.Int
+	gcCount       *monitoring.Uint
+	gcPauses      *monitoring.Uint
+	gcPausesTotal *monitoring.Uint
+	gcPausesMax   *monitoring.Uint
+	heapAlloc     *monitoring.Uint
+	heapGoal      *monitoring.Uint
+	heapTotal     *monitoring.Uint
+	memorySys     *monitoring.Uint
+
+	// process metrics, nil if not supported on the current platform.
+	rss         *monitoring.Uint
+	cpuUser     *monitoring.Uint
+	cpuSystem   *monitoring.Uint
+	cpuTotal    *monitoring.Uint
+	handlesOpen *monitoring.Int
+
+	startMu sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewCollector registers the runtime metrics in reg and returns a collector
+// for refreshing them. If reg is nil, the metrics are registered with
+// monitoring.Default. The metrics are refreshed once before NewCollector
+// returns; call Start to refresh them periodically.
+func NewCollector(reg *monitoring.Registry, cfg Config) *Collector {
+	if reg == nil {
+		reg = monitoring.Default
+	}
+	if cfg.Period <= 0 {
+		cfg.Period = DefaultConfig().Period
+	}
+
+	c := &Collector{
+		period:  cfg.Period,
+		samples: make([]metrics.Sample, numSamples),
+
+		goroutines:    monitoring.NewInt(reg, "goroutines", monitoring.Gauge),
+		gcCount:       monitoring.NewUint(reg, "gc.count", monitoring.Counter),
+		gcPauses:      monitoring.NewUint(reg, "gc.pauses.count", monitoring.Counter),
+		gcPausesTotal: monitoring.NewUint(reg, "gc.pauses.total.ns", monitoring.Counter),
+		gcPausesMax:   monitoring.NewUint(reg, "gc.pauses.max.ns", monitoring.Gauge),
+		heapAlloc:     monitoring.NewUint(reg, "memory.heap.alloc", monitoring.Gauge),
+		heapGoal:      monitoring.NewUint(reg, "memory.heap.goal", monitoring.Gauge),
+		heapTotal:     monitoring.NewUint(reg, "memory.heap.total", monitoring.Counter),
+		memorySys:     monitoring.NewUint(reg, "memory.sys", monitoring.Gauge),
+	}
+	for i, name := range sampleNames {
+		c.samples[i].Name = name
+	}
+
+	if procSupported {
+		c.rss = monitoring.NewUint(reg, "memory.rss", monitoring.Gauge)
+		c.cpuUser = monitoring.NewUint(reg, "cpu.user.ns", monitoring.Counter)
+		c.cpuSystem = monitoring.NewUint(reg, "cpu.system.ns", monitoring.Counter)
+		c.cpuTotal = monitoring.NewUint(reg, "cpu.total.ns", monitoring.Counter)
+		c.handlesOpen = monitoring.NewInt(reg, "handles.open", monitoring.Gauge)
+	}
+
+	c.Refresh()
+	return c
+}
+
+// Start refreshes the metrics every configured period until Stop is called.
+// Calling Start on a running collector is a no-op.
+func (c *Collector) Start() {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.done != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	c.done = done
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.Refresh()
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic refresh. The registered metrics keep their last values.
+func (c *Collector) Stop() {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.done == nil {
+		return
+	}
+
+	close(c.done)
+	c.wg.Wait()
+	c.done = nil
+}
+
+// Refresh updates all metrics immediately.
+func (c *Collector) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refreshRuntime()
+	if procSupported {
+		c.refreshProc()
+	}
+}
+
+func (c *Collector) refreshRuntime() {
+	metrics.Read(c.samples)
+
+	if v, ok := uint64Value(&c.samples[sampleGoroutines]); ok {
+		c.goroutines.Set(int64(v))
+	}
+	setUint(c.gcCount, &c.samples[sampleGCCycles])
+	setUint(c.heapGoal, &c.samples[sampleGCHeapGoal])
+	setUint(c.heapAlloc, &c.samples[sampleHeapObjects])
+	setUint(c.heapTotal, &c.samples[sampleHeapAllocs])
+	setUint(c.memorySys, &c.samples[sampleMemoryTotal])
+
+	if s := &c.samples[sampleGCPauses]; s.Value.Kind() == metrics.KindFloat64Histogram {
+		count, total, maxPause := summarizePauses(s.Value.Float64Histogram())
+		c.gcPauses.Set(count)
+		c.gcPausesTotal.Set(uint64(total * float64(time.Second)))
+		c.gcPausesMax.Set(uint64(maxPause * float64(time.Second)))
+	}
+}
+
+func (c *Collector) refreshProc() {
+	if stat, err := c.proc.readStat(); err == nil {
+		c.rss.Set(stat.rss)
+		c.cpuUser.Set(uint64(stat.user))
+		c.cpuSystem.Set(uint64(stat.system))
+		c.cpuTotal.Set(uint64(stat.user + stat.system))
+	}
+	if n, err := c.proc.countFDs(); err == nil {
+		c.handlesOpen.Set(int64(n))
+	}
+}
+
+func setUint(v *monitoring.Uint, s *metrics.Sample) {
+	if u, ok := uint64Value(s); ok {
+		v.Set(u)
+	}
+}
+
+func uint64Value(s *metrics.Sample) (uint64, bool) {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0, false
+	}
+	return s.Value.Uint64(), true
+}
+
+// summarizePauses returns the number of pauses, and approximations of the total
+// and the longest pause in seconds. Pause durations are approximated by the
+// midpoint of their histogram bucket.
+func summarizePauses(h *metrics.Float64Histogram) (count uint64, total, longest float64) {
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+
+		lower, upper := h.Buckets[i], h.Buckets[i+1]
+		if math.IsInf(lower, -1) {
+			lower = 0
+		}
+		value := lower
+		if !math.IsInf(upper, 1) {
+			value = (lower + upper) / 2
+		}
+
+		count += n
+		total += value * float64(n)
+		longest = value
+	}
+	return count, total, longest
+}
@@ -0,0 +1,152 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package runtime
+
+import (
+	"os"
+	goruntime "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestCollector(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	c := NewCollector(reg, Config{Period: 10 * time.Millisecond})
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Greater(t, snapshot.Ints["goroutines"], int64(0))
+	assert.Greater(t, snapshot.Uints["memory.heap.alloc"], uint64(0))
+	assert.Greater(t, snapshot.Uints["memory.sys"], uint64(0))
+	assert.Contains(t, snapshot.Uints, "gc.pauses.count")
+
+	if procSupported {
+		assert.Greater(t, snapshot.Uints["memory.rss"], uint64(0))
+		assert.Greater(t, snapshot.Ints["handles.open"], int64(0))
+		assert.Equal(t, snapshot.Uints["cpu.total.ns"], snapshot.Uints["cpu.user.ns"]+snapshot.Uints["cpu.system.ns"])
+	}
+
+	gcCount := c.gcCount.Get()
+	c.Start()
+	c.Start()
+	defer c.Stop()
+
+	runtimeGC()
+	require.Eventually(t, func() bool {
+		return c.gcCount.Get() > gcCount
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c.Stop()
+	c.Stop()
+}
+
+func TestCollectorOpenFiles(t *testing.T) {
+	if !procSupported {
+		t.Skip("process metrics not supported")
+	}
+
+	c := NewCollector(monitoring.NewRegistry(), DefaultConfig())
+	before := c.handlesOpen.Get()
+
+	f, err := os.Open(os.Args[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	c.Refresh()
+	assert.Equal(t, before+1, c.handlesOpen.Get())
+}
+
+func TestCollectorRefreshAllocs(t *testing.T) {
+	c := NewCollector(monitoring.NewRegistry(), DefaultConfig())
+	allocs := testing.AllocsPerRun(10, c.Refresh)
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkCollectorRefresh(b *testing.B) {
+	c := NewCollector(monitoring.NewRegistry(), DefaultConfig())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Refresh()
+	}
+}
+
+func runtimeGC() {
+	// allocate some garbage to make sure a GC cycle can complete
+	garbage := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		garbage = append(garbage, make([]byte, 1024))
+	}
+	_ = garbage
+	goruntime.GC()
+}
+
+// hintVisitor records the type hints of the metrics in a registry.
+type hintVisitor struct {
+	path  []string
+	key   string
+	hint  string
+	hints map[string]string
+}
+
+func (v *hintVisitor) OnRegistryStart() {
+	if v.key != "" {
+		v.path = append(v.path, v.key)
+	}
+	v.key = ""
+}
+
+func (v *hintVisitor) OnRegistryFinished() {
+	if len(v.path) > 0 {
+		v.path = v.path[:len(v.path)-1]
+	}
+}
+
+func (v *hintVisitor) OnKey(key string) { v.key = key }
+func (v *hintVisitor) OnCounter()       { v.hint = "counter" }
+func (v *hintVisitor) OnGauge()         { v.hint = "gauge" }
+
+func (v *hintVisitor) onValue() {
+	v.hints[strings.Join(append(v.path, v.key), ".")] = v.hint
+	v.key, v.hint = "", ""
+}
+
+func (v *hintVisitor) OnString(string)        { v.onValue() }
+func (v *hintVisitor) OnBool(bool)            { v.onValue() }
+func (v *hintVisitor) OnInt(int64)            { v.onValue() }
+func (v *hintVisitor) OnUint(uint64)          { v.onValue() }
+func (v *hintVisitor) OnFloat(float64)        { v.onValue() }
+func (v *hintVisitor) OnStringSlice([]string) { v.onValue() }
+
+func TestCollectorMetricTypes(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	NewCollector(reg, Config{Period: time.Hour})
+
+	vs := &hintVisitor{hints: map[string]string{}}
+	reg.Visit(monitoring.Full, vs)
+
+	assert.Equal(t, "counter", vs.hints["memory.heap.total"], "cumulative allocations are a counter")
+	assert.Equal(t, "counter", vs.hints["gc.count"])
+	assert.Equal(t, "gauge", vs.hints["memory.heap.alloc"])
+	assert.Equal(t, "gauge", vs.hints["goroutines"])
+}
@@ -0,0 +1,185 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build linux
+
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const procSupported = true
+
+// userHZ is the unit of the CPU times in /proc/self/stat. It is 100 on all
+// supported architectures and can not be queried without cgo.
+const userHZ = 100
+
+var errProcFormat = errors.New("unexpected /proc/self/stat format")
+
+type procStat struct {
+	user   time.Duration
+	system time.Duration
+	rss    uint64
+}
+
+// procReader reads process metrics from /proc/self. The files are opened on
+// first use and kept open for the lifetime of the reader, they are closed by
+// the os.File finalizer. Together with the reused buffer this keeps reading
+// free of allocations.
+type procReader struct {
+	stat  *os.File
+	fdDir *os.File
+	buf   [4096]byte
+}
+
+func (p *procReader) open() error {
+	if p.stat != nil {
+		return nil
+	}
+
+	stat, err := os.Open("/proc/self/stat")
+	if err != nil {
+		return err
+	}
+	fdDir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		stat.Close()
+		return err
+	}
+	p.stat, p.fdDir = stat, fdDir
+	return nil
+}
+
+// readStat reads the CPU times and the resident set size from /proc/self/stat.
+func (p *procReader) readStat() (procStat, error) {
+	if err := p.open(); err != nil {
+		return procStat{}, err
+	}
+
+	// proc files generate their content on every read from offset 0
+	n, err := p.stat.ReadAt(p.buf[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return procStat{}, err
+	}
+
+	// The command name in the second field can contain spaces and parentheses,
+	// parsing starts after the last ')'.
+	data := p.buf[:n]
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, errProcFormat
+	}
+	data = data[end+1:]
+
+	// Fields are numbered as in proc(5), the state (field 3) is the first
+	// field after the command name.
+	const (
+		fieldUtime = 14
+		fieldStime = 15
+		fieldRSS   = 24
+	)
+	var stat procStat
+	field := 2
+	for len(data) > 0 && field < fieldRSS {
+		data = bytes.TrimLeft(data, " ")
+		i := bytes.IndexByte(data, ' ')
+		if i < 0 {
+			i = len(data)
+		}
+		value := data[:i]
+		data = data[i:]
+		field++
+
+		if field != fieldUtime && field != fieldStime && field != fieldRSS {
+			continue
+		}
+		v, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			return procStat{}, errProcFormat
+		}
+		switch field {
+		case fieldUtime:
+			stat.user = ticksToDuration(v)
+		case fieldStime:
+			stat.system = ticksToDuration(v)
+		case fieldRSS:
+			stat.rss = v * uint64(os.Getpagesize())
+		}
+	}
+	if field != fieldRSS {
+		return procStat{}, errProcFormat
+	}
+	return stat, nil
+}
+
+// countFDs returns the number of open file descriptors, not counting the ones
+// held by the reader.
+//
+// The directory is listed with syscall.Getdents, as os.File.ReadDir allocates
+// for every entry. TestCollectorRefreshAllocs and BenchmarkCollectorRefresh
+// cover that refreshing does not allocate.
+func (p *procReader) countFDs() (int, error) {
+	if err := p.open(); err != nil {
+		return 0, err
+	}
+
+	fd := int(p.fdDir.Fd())
+	if _, err := syscall.Seek(fd, 0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		n, err := syscall.Getdents(fd, p.buf[:])
+		if err != nil {
+			return 0, err
+		}
+		if n <= 0 {
+			break
+		}
+
+		// linux_dirent64: ino (8), off (8), reclen (2), type (1), name
+		for data := p.buf[:n]; len(data) >= 19; {
+			reclen := int(binary.NativeEndian.Uint16(data[16:18]))
+			if reclen < 19 || reclen > len(data) {
+				return 0, errors.New("invalid directory entry in /proc/self/fd")
+			}
+			if name := data[19:reclen]; !isDotEntry(name) {
+				count++
+			}
+			data = data[reclen:]
+		}
+	}
+	return count - 2, nil
+}
+
+func isDotEntry(name []byte) bool {
+	name, _, _ = bytes.Cut(name, []byte{0})
+	return string(name) == "." || string(name) == ".."
+}
+
+func ticksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks) * time.Second / userHZ
+}
@@ -24,6 +24,7 @@ import "strings"
 type FlatSnapshot struct {
 	Bools        map[string]bool
 	Ints         map[string]int64
+	Uints        map[string]uint64
 	Floats       map[string]float64
 	Strings      map[string]string
 	StringSlices map[string][]string
@@ -32,6 +33,10 @@ type FlatSnapshot struct {
 type flatSnapshotVisitor struct {
 	snapshot FlatSnapshot
 	level    []string
+
+	// types records the type hints of the metrics, if not nil.
+	types map[string]MetricType
+	hint  MetricType
 }
 
 type structSnapshotVisitor struct {
@@ -67,10 +72,28 @@ func CollectFlatSnapshot(r *Registry, mode Mode, expvar bool) FlatSnapshot {
 	return vs.snapshot
 }
 
+// CollectFlatSnapshotWithTypes collects a flattened snapshot like
+// CollectFlatSnapshot and additionally returns the type hints of all metrics
+// registered as counter or gauge.
+func CollectFlatSnapshotWithTypes(r *Registry, mode Mode, expvar bool) (FlatSnapshot, map[string]MetricType) {
+	if r == nil {
+		r = Default
+	}
+
+	vs := newFlatSnapshotVisitor()
+	vs.types = map[string]MetricType{}
+	r.Visit(mode, vs)
+	if expvar {
+		VisitExpvars(vs)
+	}
+	return vs.snapshot, vs.types
+}
+
 func MakeFlatSnapshot() FlatSnapshot {
 	return FlatSnapshot{
 		Bools:        map[string]bool{},
 		Ints:         map[string]int64{},
+		Uints:        map[string]uint64{},
 		Floats:       map[string]float64{},
 		Strings:      map[string]string{},
 		StringSlices: map[string][]string{},
@@ -118,10 +141,17 @@ func (vs *flatSnapshotVisitor) OnKey(name string) {
 
 func (vs *flatSnapshotVisitor) getName() string {
 	defer vs.dropName()
-	if len(vs.level) == 1 {
-		return vs.level[0]
+	name := vs.level[0]
+	if len(vs.level) > 1 {
+		name = strings.Join(vs.level, ".")
+	}
+	if vs.hint != UnknownMetric {
+		if vs.types != nil {
+			vs.types[name] = vs.hint
+		}
+		vs.hint = UnknownMetric
 	}
-	return strings.Join(vs.level, ".")
+	return name
 }
 
 func (vs *flatSnapshotVisitor) dropName() {
@@ -140,6 +170,13 @@ func (vs *flatSnapshotVisitor) OnInt(i int64) {
 	vs.snapshot.Ints[vs.getName()] = i
 }
 
+func (vs *flatSnapshotVisitor) OnUint(u uint64) {
+	vs.snapshot.Uints[vs.getName()] = u
+}
+
+func (vs *flatSnapshotVisitor) OnCounter() { vs.hint = CounterMetric }
+func (vs *flatSnapshotVisitor) OnGauge()   { vs.hint = GaugeMetric }
+
 func (vs *flatSnapshotVisitor) OnFloat(f float64) {
 	vs.snapshot.Floats[vs.getName()] = f
 }
@@ -184,7 +221,10 @@ func (s *structSnapshotVisitor) OnKey(key string) {
 func (s *structSnapshotVisitor) OnString(str string) { s.setValue(str) }
 func (s *structSnapshotVisitor) OnBool(b bool)       { s.setValue(b) }
 func (s *structSnapshotVisitor) OnInt(i int64)       { s.setValue(i) }
+func (s *structSnapshotVisitor) OnUint(u uint64)     { s.setValue(u) }
 func (s *structSnapshotVisitor) OnFloat(f float64)   { s.setValue(f) }
+func (s *structSnapshotVisitor) OnCounter()          {}
+func (s *structSnapshotVisitor) OnGauge()            {}
 func (s *structSnapshotVisitor) OnStringSlice(f []string) {
 	c := make([]string, len(f))
 	copy(c, f)
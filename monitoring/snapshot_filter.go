@@ -0,0 +1,346 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/match"
+)
+
+// SnapshotFilter selects the metrics collected by CollectFilteredFlatSnapshot
+// and CollectFilteredStructSnapshot.
+//
+// Patterns are regular expressions, compiled with the match package, that are
+// matched against the `.`-separated path of a metric or sub-registry.
+//   - A metric is collected if no include pattern is configured, or if an
+//     include pattern matches the path of the metric or of any of the
+//     registries it is nested in.
+//   - Metrics and sub-registries matching an exclude pattern are skipped
+//     without being visited. Exclude patterns take precedence.
+//   - If MaxDepth is positive, only metrics with at most MaxDepth path
+//     elements are collected and deeper registries are not visited.
+type SnapshotFilter struct {
+	Include  []match.Matcher
+	Exclude  []match.Matcher
+	MaxDepth int
+}
+
+// NewSnapshotFilter compiles the include and exclude patterns into a SnapshotFilter.
+func NewSnapshotFilter(include, exclude []string, maxDepth int) (*SnapshotFilter, error) {
+	f := &SnapshotFilter{MaxDepth: maxDepth}
+
+	var err error
+	if f.Include, err = compilePatterns(include); err != nil {
+		return nil, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	if f.Exclude, err = compilePatterns(exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]match.Matcher, error) {
+	matchers := make([]match.Matcher, 0, len(patterns))
+	for _, p := range patterns {
+		m, err := match.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// CollectFilteredFlatSnapshot is like CollectFlatSnapshot, but only collects
+// the metrics selected by filter. A nil filter collects all metrics.
+func CollectFilteredFlatSnapshot(r *Registry, mode Mode, expvar bool, filter *SnapshotFilter) FlatSnapshot {
+	if r == nil {
+		r = Default
+	}
+
+	vs := newFlatSnapshotVisitor()
+	r.Visit(mode, filter.wrap(vs))
+	if expvar {
+		VisitExpvars(filter.wrap(vs))
+	}
+	return vs.snapshot
+}
+
+// CollectFilteredStructSnapshot is like CollectStructSnapshot, but only
+// collects the metrics selected by filter. A nil filter collects all metrics.
+func CollectFilteredStructSnapshot(r *Registry, mode Mode, expvar bool, filter *SnapshotFilter) map[string]interface{} {
+	if r == nil {
+		r = Default
+	}
+
+	vs := newStructSnapshotVisitor()
+	r.Visit(mode, filter.wrap(vs))
+	snapshot := vs.event.current
+
+	if expvar {
+		vs := newStructSnapshotVisitor()
+		VisitExpvars(filter.wrap(vs))
+		if snapshot == nil && len(vs.event.current) > 0 {
+			snapshot = map[string]interface{}{}
+		}
+		for k, v := range vs.event.current {
+			snapshot[k] = v
+		}
+	}
+
+	return snapshot
+}
+
+func (f *SnapshotFilter) wrap(vs Visitor) Visitor {
+	if f == nil {
+		return vs
+	}
+	return &filterVisitor{filter: f, inner: vs}
+}
+
+func (f *SnapshotFilter) excluded(path string) bool {
+	for _, m := range f.Exclude {
+		if m.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *SnapshotFilter) included(path string) bool {
+	for _, m := range f.Include {
+		if m.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// keySkipper is implemented by visitors that want registries to not visit
+// some entries at all.
+type keySkipper interface {
+	skipKey(key string, isRegistry bool) bool
+}
+
+// filterVisitor forwards the events selected by a SnapshotFilter to the inner
+// visitor. Keys are forwarded lazily, once it is known whether the value is
+// collected.
+type filterVisitor struct {
+	filter *SnapshotFilter
+	inner  Visitor
+
+	// path of the current key, pending is set if the key has not yet been
+	// forwarded to the inner visitor.
+	path    []string
+	pending bool
+
+	// hint is the type hint reported for the pending key.
+	hint MetricType
+
+	// levels holds the state of all registries currently visited.
+	levels []filterLevel
+
+	// skip is non-zero while visiting an excluded subtree reported by a
+	// Var itself and counts its nesting.
+	skip int
+}
+
+type filterLevel struct {
+	// forwarded is set if the registry start has been passed to the inner visitor.
+	forwarded bool
+
+	// included is set if the registry path matched an include pattern.
+	included bool
+}
+
+func (v *filterVisitor) currentPath() string {
+	return strings.Join(v.path, ".")
+}
+
+func (v *filterVisitor) parentIncluded() bool {
+	if len(v.filter.Include) == 0 {
+		return true
+	}
+	for _, l := range v.levels {
+		if l.included {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *filterVisitor) tooDeep(depth int, isRegistry bool) bool {
+	if v.filter.MaxDepth <= 0 {
+		return false
+	}
+	if isRegistry {
+		return depth >= v.filter.MaxDepth
+	}
+	return depth > v.filter.MaxDepth
+}
+
+func (v *filterVisitor) skipKey(key string, isRegistry bool) bool {
+	if v.skip > 0 {
+		return true
+	}
+	if v.tooDeep(len(v.path)+1, isRegistry) {
+		return true
+	}
+	if len(v.filter.Exclude) == 0 {
+		return false
+	}
+
+	path := key
+	if len(v.path) > 0 {
+		path = v.currentPath() + "." + key
+	}
+	return v.filter.excluded(path)
+}
+
+func (v *filterVisitor) OnKey(key string) {
+	if v.skip > 0 {
+		return
+	}
+	if v.pending {
+		// The previous key is not followed by a value, e.g. if a Var reports
+		// values without starting a new registry. Forward it to keep the
+		// inner visitor's key handling unchanged.
+		v.inner.OnKey(v.path[len(v.path)-1])
+		v.hint = UnknownMetric
+	}
+	v.path = append(v.path, key)
+	v.pending = true
+}
+
+func (v *filterVisitor) OnRegistryStart() {
+	if v.skip > 0 {
+		v.skip++
+		return
+	}
+
+	// root registry
+	if len(v.path) == 0 && len(v.levels) == 0 {
+		v.levels = append(v.levels, filterLevel{forwarded: true})
+		v.inner.OnRegistryStart()
+		return
+	}
+
+	// nested namespaces reported by a Var are not checked by the registry
+	if v.pending && (v.tooDeep(len(v.path), true) || v.filter.excluded(v.currentPath())) {
+		v.skip = 1
+		v.path = v.path[:len(v.path)-1]
+		v.pending = false
+		return
+	}
+
+	included := len(v.filter.Include) > 0 && v.filter.included(v.currentPath())
+	v.inner.OnKey(v.path[len(v.path)-1])
+	v.inner.OnRegistryStart()
+	v.pending = false
+	v.levels = append(v.levels, filterLevel{forwarded: true, included: included})
+}
+
+func (v *filterVisitor) OnRegistryFinished() {
+	if v.skip > 0 {
+		v.skip--
+		return
+	}
+
+	last := len(v.levels) - 1
+	level := v.levels[last]
+	v.levels = v.levels[:last]
+	if level.forwarded {
+		v.inner.OnRegistryFinished()
+	}
+	if len(v.path) > 0 {
+		v.path = v.path[:len(v.path)-1]
+	}
+}
+
+// collect checks if the value for the current key is collected and forwards
+// the key if so.
+func (v *filterVisitor) collect() bool {
+	if v.skip > 0 {
+		return false
+	}
+
+	path := v.currentPath()
+	ok := !v.tooDeep(len(v.path), false) &&
+		!v.filter.excluded(path) &&
+		(v.parentIncluded() || v.filter.included(path))
+	if ok {
+		v.inner.OnKey(v.path[len(v.path)-1])
+		reportType(v.inner, v.hint)
+	}
+	v.path = v.path[:len(v.path)-1]
+	v.pending = false
+	v.hint = UnknownMetric
+	return ok
+}
+
+// OnCounter records the hint until it is known whether the value is collected.
+func (v *filterVisitor) OnCounter() {
+	if v.skip == 0 {
+		v.hint = CounterMetric
+	}
+}
+
+// OnGauge records the hint until it is known whether the value is collected.
+func (v *filterVisitor) OnGauge() {
+	if v.skip == 0 {
+		v.hint = GaugeMetric
+	}
+}
+
+func (v *filterVisitor) OnString(s string) {
+	if v.collect() {
+		v.inner.OnString(s)
+	}
+}
+
+func (v *filterVisitor) OnBool(b bool) {
+	if v.collect() {
+		v.inner.OnBool(b)
+	}
+}
+
+func (v *filterVisitor) OnInt(i int64) {
+	if v.collect() {
+		v.inner.OnInt(i)
+	}
+}
+
+func (v *filterVisitor) OnUint(u uint64) {
+	if v.collect() {
+		v.inner.OnUint(u)
+	}
+}
+
+func (v *filterVisitor) OnFloat(f float64) {
+	if v.collect() {
+		v.inner.OnFloat(f)
+	}
+}
+
+func (v *filterVisitor) OnStringSlice(s []string) {
+	if v.collect() {
+		v.inner.OnStringSlice(s)
+	}
+}
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFilterTestRegistry() *Registry {
+	reg := NewRegistry()
+	NewInt(reg, "pipeline.events.total").Set(10)
+	NewInt(reg, "pipeline.events.failed").Set(1)
+	NewInt(reg, "pipeline.queue.acked").Set(5)
+	NewString(reg, "output.type").Set("elasticsearch")
+	NewInt(reg, "output.write.bytes").Set(100)
+	NewInt(reg, "uptime").Set(42)
+	NewFunc(reg, "inputs", func(_ Mode, vs Visitor) {
+		vs.OnRegistryStart()
+		defer vs.OnRegistryFinished()
+
+		ReportNamespace(vs, "tcp", func() {
+			ReportInt(vs, "received", 3)
+			ReportNamespace(vs, "errors", func() {
+				ReportInt(vs, "parse", 2)
+			})
+		})
+	})
+	return reg
+}
+
+func TestCollectFilteredStructSnapshot(t *testing.T) {
+	tests := map[string]struct {
+		include  []string
+		exclude  []string
+		maxDepth int
+		expected map[string]interface{}
+	}{
+		"include registry": {
+			include: []string{`^pipeline\.events$`},
+			expected: map[string]interface{}{
+				"pipeline": map[string]interface{}{
+					"events": map[string]interface{}{"total": int64(10), "failed": int64(1)},
+				},
+			},
+		},
+		"include metric": {
+			include: []string{`^output\.type$`, `^uptime$`},
+			expected: map[string]interface{}{
+				"output": map[string]interface{}{"type": "elasticsearch"},
+				"uptime": int64(42),
+			},
+		},
+		"exclude registry and metric": {
+			exclude: []string{`^pipeline$`, `^output\.write\.bytes$`, `^inputs\.tcp\.errors$`},
+			expected: map[string]interface{}{
+				"output": map[string]interface{}{"type": "elasticsearch"},
+				"uptime": int64(42),
+				"inputs": map[string]interface{}{
+					"tcp": map[string]interface{}{"received": int64(3)},
+				},
+			},
+		},
+		"exclude takes precedence": {
+			include: []string{`^pipeline`},
+			exclude: []string{`failed`, `queue`},
+			expected: map[string]interface{}{
+				"pipeline": map[string]interface{}{
+					"events": map[string]interface{}{"total": int64(10)},
+				},
+			},
+		},
+		"max depth": {
+			maxDepth: 2,
+			expected: map[string]interface{}{
+				"output": map[string]interface{}{"type": "elasticsearch"},
+				"uptime": int64(42),
+			},
+		},
+		"max depth with var namespaces": {
+			include:  []string{`^inputs`},
+			maxDepth: 3,
+			expected: map[string]interface{}{
+				"inputs": map[string]interface{}{
+					"tcp": map[string]interface{}{"received": int64(3)},
+				},
+			},
+		},
+		"nothing selected": {
+			include:  []string{`^missing`},
+			expected: nil,
+		},
+	}
+
+	reg := newFilterTestRegistry()
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := NewSnapshotFilter(test.include, test.exclude, test.maxDepth)
+			require.NoError(t, err)
+
+			snapshot := CollectFilteredStructSnapshot(reg, Full, false, filter)
+			assert.Equal(t, test.expected, snapshot)
+		})
+	}
+}
+
+func TestCollectFilteredFlatSnapshot(t *testing.T) {
+	reg := newFilterTestRegistry()
+	filter, err := NewSnapshotFilter([]string{`events`, `^inputs`}, []string{`parse`}, 0)
+	require.NoError(t, err)
+
+	snapshot := CollectFilteredFlatSnapshot(reg, Full, false, filter)
+	assert.Equal(t, map[string]int64{
+		"pipeline.events.total":  10,
+		"pipeline.events.failed": 1,
+		"inputs.tcp.received":    3,
+	}, snapshot.Ints)
+	assert.Empty(t, snapshot.Strings)
+}
+
+func TestCollectFilteredSnapshotNilFilter(t *testing.T) {
+	reg := newFilterTestRegistry()
+	assert.Equal(t,
+		CollectStructSnapshot(reg, Full, false),
+		CollectFilteredStructSnapshot(reg, Full, false, nil))
+	assert.Equal(t,
+		CollectFlatSnapshot(reg, Full, false),
+		CollectFilteredFlatSnapshot(reg, Full, false, nil))
+}
+
+func TestNewSnapshotFilterInvalidPattern(t *testing.T) {
+	_, err := NewSnapshotFilter([]string{`(`}, nil, 0)
+	assert.Error(t, err)
+	_, err = NewSnapshotFilter(nil, []string{`[`}, 0)
+	assert.Error(t, err)
+}
+
+func BenchmarkCollectFilteredStructSnapshot(b *testing.B) {
+	reg := NewRegistry()
+	inputs := reg.NewRegistry("inputs")
+	for i := 0; i < 100; i++ {
+		sub := inputs.NewRegistry(fmt.Sprintf("input-%d", i))
+		for j := 0; j < 20; j++ {
+			NewInt(sub, fmt.Sprintf("metric-%d", j))
+		}
+	}
+	NewInt(reg, "pipeline.events.total")
+
+	filter, err := NewSnapshotFilter(nil, []string{`^inputs$`}, 0)
+	require.NoError(b, err)
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CollectStructSnapshot(reg, Full, false)
+		}
+	})
+	b.Run("filtered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CollectFilteredStructSnapshot(reg, Full, false, filter)
+		}
+	})
+}
@@ -104,3 +104,18 @@ func TestSnapshot(t *testing.T) {
 		assert.Equal(t, test.expected, snapshot)
 	}
 }
+
+func TestCollectFlatSnapshotWithTypes(t *testing.T) {
+	reg := NewRegistry()
+	NewInt(reg, "queue.size", Gauge).Set(3)
+	NewUint(reg, "events.total", Counter).Set(5)
+	NewInt(reg, "untyped").Set(1)
+	NewString(reg, "name").Set("test")
+
+	snapshot, types := CollectFlatSnapshotWithTypes(reg, Full, false)
+	assert.Equal(t, CollectFlatSnapshot(reg, Full, false), snapshot)
+	assert.Equal(t, map[string]MetricType{
+		"queue.size":   GaugeMetric,
+		"events.total": CounterMetric,
+	}, types)
+}
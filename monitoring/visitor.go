@@ -28,8 +28,65 @@ type ValueVisitor interface {
 	OnString(s string)
 	OnBool(b bool)
 	OnInt(i int64)
+	OnUint(u uint64)
 	OnFloat(f float64)
 	OnStringSlice(f []string)
+
+	// OnCounter and OnGauge are type hints, called right before the value of a
+	// metric that is known to be a monotonic counter or a gauge. Metrics of
+	// unknown type report no hint.
+	OnCounter()
+	OnGauge()
+}
+
+// LegacyValueVisitor is the ValueVisitor interface without support for
+// unsigned values and metric type hints.
+type LegacyValueVisitor interface {
+	OnString(s string)
+	OnBool(b bool)
+	OnInt(i int64)
+	OnFloat(f float64)
+	OnStringSlice(f []string)
+}
+
+// LegacyVisitor is implemented by visitors written against LegacyValueVisitor.
+type LegacyVisitor interface {
+	LegacyValueVisitor
+	RegistryVisitor
+}
+
+// AdaptLegacyVisitor makes a visitor implementing the legacy interface usable
+// with Registry.Visit. Unsigned values are reported via OnInt with the top bit
+// masked, as before OnUint has been introduced. Type hints are dropped.
+func AdaptLegacyVisitor(vs LegacyVisitor) Visitor {
+	if v, ok := vs.(Visitor); ok {
+		return v
+	}
+	return legacyVisitor{vs}
+}
+
+type legacyVisitor struct {
+	LegacyVisitor
+}
+
+func (v legacyVisitor) OnUint(u uint64) { v.OnInt(uintToInt(u)) }
+func (v legacyVisitor) OnCounter()      {}
+func (v legacyVisitor) OnGauge()        {}
+
+// uintToInt converts an unsigned value for visitors only supporting int64.
+// The top bit is masked, such that the result is never negative.
+func uintToInt(u uint64) int64 {
+	return int64(u & ^uint64(1<<63))
+}
+
+// reportType reports the type hint of a metric to the visitor.
+func reportType(vs Visitor, t MetricType) {
+	switch t {
+	case CounterMetric:
+		vs.OnCounter()
+	case GaugeMetric:
+		vs.OnGauge()
+	}
 }
 
 // RegistryVisitor is the interface type for interacting with a monitoring registry
@@ -71,6 +128,12 @@ func ReportInt(V Visitor, name string, value int64) {
 	V.OnInt(value)
 }
 
+// ReportUint reports an unsigned int type for the visitor
+func ReportUint(V Visitor, name string, value uint64) {
+	V.OnKey(name)
+	V.OnUint(value)
+}
+
 // ReportFloat reports a float type for the visitor
 func ReportFloat(V Visitor, name string, value float64) {
 	V.OnKey(name)
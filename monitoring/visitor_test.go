@@ -0,0 +1,150 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingVisitor records all events as strings.
+type recordingVisitor struct {
+	events []string
+}
+
+func (r *recordingVisitor) OnRegistryStart()          { r.add("start") }
+func (r *recordingVisitor) OnRegistryFinished()       { r.add("finished") }
+func (r *recordingVisitor) OnKey(s string)            { r.add("key:" + s) }
+func (r *recordingVisitor) OnString(s string)         { r.add("string:" + s) }
+func (r *recordingVisitor) OnBool(b bool)             { r.add(fmt.Sprintf("bool:%v", b)) }
+func (r *recordingVisitor) OnInt(i int64)             { r.add(fmt.Sprintf("int:%d", i)) }
+func (r *recordingVisitor) OnUint(u uint64)           { r.add(fmt.Sprintf("uint:%d", u)) }
+func (r *recordingVisitor) OnFloat(f float64)         { r.add(fmt.Sprintf("float:%v", f)) }
+func (r *recordingVisitor) OnStringSlice(ss []string) { r.add("strings:" + strings.Join(ss, ",")) }
+func (r *recordingVisitor) OnCounter()                { r.add("counter") }
+func (r *recordingVisitor) OnGauge()                  { r.add("gauge") }
+
+func (r *recordingVisitor) add(evt string) { r.events = append(r.events, evt) }
+
+// metrics groups the recorded events by key. Registry iteration order is
+// random, so tests compare the events per key.
+func (r *recordingVisitor) metrics() map[string][]string {
+	m := map[string][]string{}
+	var key string
+	for _, evt := range r.events {
+		switch {
+		case strings.HasPrefix(evt, "key:"):
+			key = strings.TrimPrefix(evt, "key:")
+		case evt != "start" && evt != "finished":
+			m[key] = append(m[key], evt)
+		}
+	}
+	return m
+}
+
+// legacyRecorder only implements the legacy visitor interface.
+type legacyRecorder struct {
+	recordingVisitor
+}
+
+func (l *legacyRecorder) visitor() LegacyVisitor {
+	return struct {
+		LegacyValueVisitor
+		RegistryVisitor
+	}{l, l}
+}
+
+func TestUintReportsFullValue(t *testing.T) {
+	reg := NewRegistry()
+	NewUint(reg, "u").Set(math.MaxUint64)
+
+	var rec recordingVisitor
+	reg.Visit(Full, &rec)
+	assert.Equal(t, []string{"start", "key:u", fmt.Sprintf("uint:%d", uint64(math.MaxUint64)), "finished"}, rec.events)
+
+	assert.Equal(t, map[string]interface{}{"u": uint64(math.MaxUint64)}, CollectStructSnapshot(reg, Full, false))
+	assert.Equal(t, map[string]uint64{"u": math.MaxUint64}, CollectFlatSnapshot(reg, Full, false).Uints)
+
+	var kv []interface{}
+	reg.Do(Full, func(_ string, v interface{}) { kv = append(kv, v) })
+	assert.Equal(t, []interface{}{uint64(math.MaxUint64)}, kv)
+}
+
+func TestMetricTypeHints(t *testing.T) {
+	reg := NewRegistry()
+	NewUint(reg, "counter", Counter).Set(1)
+	NewInt(reg, "gauge", Gauge).Set(2)
+	NewFloat(reg, "plain").Set(3)
+	NewMeter(reg, "meter").Mark(4)
+	NewGaugeFunc(reg, "gauge_func", func() int64 { return 5 })
+
+	var rec recordingVisitor
+	reg.Visit(Full, &rec)
+	assert.Equal(t, map[string][]string{
+		"counter":    {"counter", "uint:1"},
+		"gauge":      {"gauge", "int:2"},
+		"plain":      {"float:3"},
+		"meter":      {"counter", "int:4"},
+		"gauge_func": {"gauge", "int:5"},
+	}, rec.metrics())
+}
+
+func TestMetricTypeInheritedFromRegistry(t *testing.T) {
+	reg := NewRegistry()
+	counters := reg.NewRegistry("counters", Counter)
+	NewInt(counters, "x").Set(1)
+
+	var rec recordingVisitor
+	reg.Visit(Full, &rec)
+	assert.Equal(t, []string{"start", "key:counters", "start", "key:x", "counter", "int:1", "finished", "finished"}, rec.events)
+}
+
+func TestAdaptLegacyVisitor(t *testing.T) {
+	reg := NewRegistry()
+	NewUint(reg, "u", Counter).Set(math.MaxUint64)
+	NewString(reg, "s").Set("value")
+
+	var rec legacyRecorder
+	reg.Visit(Full, AdaptLegacyVisitor(rec.visitor()))
+	assert.Equal(t, map[string][]string{
+		"s": {"string:value"},
+		"u": {fmt.Sprintf("int:%d", int64(math.MaxInt64))},
+	}, rec.metrics())
+
+	// visitors implementing the full interface are not wrapped
+	var full recordingVisitor
+	require.Same(t, &full, AdaptLegacyVisitor(&full))
+}
+
+func TestFilteredSnapshotForwardsHints(t *testing.T) {
+	reg := NewRegistry()
+	NewUint(reg, "keep", Counter).Set(1)
+	NewInt(reg, "drop", Gauge).Set(2)
+
+	filter, err := NewSnapshotFilter(nil, []string{"drop"}, 0)
+	require.NoError(t, err)
+
+	var rec recordingVisitor
+	reg.Visit(Full, filter.wrap(&rec))
+	assert.Equal(t, []string{"start", "key:keep", "counter", "uint:1", "finished"}, rec.events)
+}